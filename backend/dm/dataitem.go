@@ -49,6 +49,9 @@ type Dataitem interface {
    Data Size标示了该dataitem中实际存储的data长度
    Valid Flag现在只有两个值， 0表示该dataitem合法， 1表示非法
    xid和flag的存在原因请参考logs.go中描述的恢复机制
+
+   Valid Flag和Data Size在dataitem被解析之后不会再改变, 所以Before和UnBefore只保存和恢复Data部分,
+   于是IsValid可以在其他协程修改该dataitem时, 不加锁的读取Valid Flag.
 */
 
 const (
@@ -95,6 +98,7 @@ func ParseDataitem(pg pcacher.Page, offset Offset, dm *dataManager) *dataitem {
 		uid:    uid,
 		dm:     dm,
 	}
+	copy(di.oldraw[:_OF_DATA], di.raw) // oldraw的头部之后不会再被修改
 	return di
 }
 
@@ -111,10 +115,10 @@ func (di *dataitem) UUID() utils.UUID {
 func (di *dataitem) Before() {
 	di.rwlock.Lock()
 	di.pg.Dirty()
-	copy(di.oldraw, di.raw)
+	copy(di.oldraw[_OF_DATA:], di.raw[_OF_DATA:])
 }
 func (di *dataitem) UnBefore() {
-	copy(di.raw, di.oldraw)
+	copy(di.raw[_OF_DATA:], di.oldraw[_OF_DATA:])
 	di.rwlock.Unlock()
 }
 func (di *dataitem) After(xid tm.XID) {
@@ -29,6 +29,8 @@ type page struct {
 	dirty bool
 	lock  sync.Mutex
 
+	dirtyLock sync.Mutex // 同一页上的多个dataitem可能被并发的修改, 见dataitem.go
+
 	pc *pcacher
 }
 
@@ -53,9 +55,20 @@ func (p *page) Release() {
 }
 
 func (p *page) Dirty() {
+	p.dirtyLock.Lock()
+	defer p.dirtyLock.Unlock()
 	p.dirty = true
 }
 
+// clean 返回该页是否为脏页, 并将其设置为非脏页.
+func (p *page) clean() bool {
+	p.dirtyLock.Lock()
+	defer p.dirtyLock.Unlock()
+	dirty := p.dirty
+	p.dirty = false
+	return dirty
+}
+
 func (p *page) Pgno() Pgno {
 	return p.pgno
 }
@@ -139,9 +139,8 @@ func (p *pcacher) getForCacher(uid utils.UUID) (interface{}, error) {
 // release 释放掉该页的内容, 也就是刷新该页, 然后从内存中释放掉.
 func (p *pcacher) releaseForCacher(underlying interface{}) {
 	pg := underlying.(*page)
-	if pg.dirty == true {
+	if pg.clean() {
 		p.flush(pg)
-		pg.dirty = false
 	}
 }
 
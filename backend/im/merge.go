@@ -0,0 +1,204 @@
+/*
+	merge.go 实现了B+树节点的合并, 以及根节点的收缩.
+
+	Delete之后, 如果叶节点所占的空间不足节点的1/4, 它会与父节点中相邻的兄弟节点合并.
+	合并总是由左边的节点u吸收右边的节点v, 其中s(u) == v:
+	1. 依次获得父节点p, u, v的写锁, 并检查u和v在p中相邻, 且s(u) == v;
+	2. 将p中u和v的两项合并为一项, 于是u的范围扩大到了原来v的范围.
+	   此时v就像一个刚刚分裂出来, 还没有被插入父节点的节点, 依然可以通过s(u)找到;
+	3. 将v的内容追加到u之后, 并令s(u) = s(v). 如果放不下, 则将二者的内容平均分配到u和一个新节点n中,
+	   即u从v中借来一部分内容, 令s(u) = n, s(n) = s(v);
+	4. 将v标记为已合并, v的内容和s(v)都保持不变, 之后也不会再被修改;
+	5. 释放锁. 如果有n, 则像分裂产生的节点一样, 将n插入p.
+	合并使得p少了一项, 如果p因此也过空了, 则继续合并p, 直到根节点.
+	最后, 如果根节点是只有一个子节点的内部节点, 则让该子节点成为新的根节点, 并将原来的根节点标记为已合并.
+
+	合并之后, 被合并的节点只能通过在合并之前读到的指针访问到:
+	读取时依然可以读它的内容, 得到的是它被合并时的状态, 并继续读s(v);
+	修改时则会得到ErrNodeMerged, 叶节点上的插入和删除会从根节点重新开始,
+	而向父节点插入分裂产生的节点时则会从根节点重新找到当前的父节点, 并插入其中(见tree.go中的repost),
+	所以右链不会因为父节点被合并而变长.
+	被合并的节点所占的空间不会被回收, 因为DM不支持释放空间, 并且可能还有操作持有指向它们的指针.
+
+	合并的并发协议和崩溃时的状态分别见protocols/tree_concurrence.go和protocols/tree_recovery.go.
+*/
+package im
+
+import (
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+)
+
+// rebalance 在节点c过空之后, 将其与兄弟节点合并, path为搜索key时经过的c的各层祖先, c中包含key.
+func (bt *bPlusTree) rebalance(path []utils.UUID, c utils.UUID, key []byte) error {
+	for i := len(path) - 1; i >= 0; i-- {
+		parent, err := bt.findParent(path[i], c, key)
+		if err != nil {
+			return err
+		}
+		if parent == utils.NilUUID { // c还没有被插入父节点
+			break
+		}
+		underflow, err := bt.merge(path[:i], parent, c)
+		if err != nil {
+			return err
+		}
+		if underflow == false {
+			break
+		}
+		c = parent
+	}
+	return bt.collapseRoot()
+}
+
+// findParent 从nodeUUID开始向右查找c的父节点, 找不到则返回NilUUID.
+func (bt *bPlusTree) findParent(nodeUUID, c utils.UUID, key []byte) (utils.UUID, error) {
+	for nodeUUID != utils.NilUUID {
+		node, err := loadNode(bt, nodeUUID)
+		if err != nil {
+			return utils.NilUUID, err
+		}
+		found, siblingUUID := node.FindSon(c, key)
+		node.Release()
+		if found {
+			return nodeUUID, nil
+		}
+		nodeUUID = siblingUUID
+	}
+	return utils.NilUUID, nil
+}
+
+/*
+	merge 将父节点parentUUID中的子节点c与其右边的兄弟节点合并, c为最后一个子节点时则与其左边的兄弟节点合并.
+	path为parentUUID的各层祖先, 用于插入合并时产生的新节点. 返回合并之后父节点是否也过空了.
+*/
+func (bt *bPlusTree) merge(path []utils.UUID, parentUUID, c utils.UUID) (bool, error) {
+	parent, err := loadNode(bt, parentUUID)
+	if err != nil {
+		return false, err
+	}
+	defer parent.Release()
+
+	parent.dataitem.Before()
+	pairs := getRawPairs(parent.raw)
+	j := 0
+	for j < len(pairs) && pairs[j].son != c {
+		j++
+	}
+	if getRawMerged(parent.raw) || j == len(pairs) || len(pairs) < 2 {
+		parent.dataitem.UnBefore()
+		return false, nil
+	}
+	if j == len(pairs)-1 {
+		j--
+	}
+
+	u, err := loadNode(bt, pairs[j].son)
+	if err != nil {
+		parent.dataitem.UnBefore()
+		return false, err
+	}
+	defer u.Release()
+	v, err := loadNode(bt, pairs[j+1].son)
+	if err != nil {
+		parent.dataitem.UnBefore()
+		return false, err
+	}
+	defer v.Release()
+
+	u.dataitem.Before()
+	v.dataitem.Before()
+	ok, newSon, newKey, err := u.Absorb(v)
+	if err != nil || ok == false {
+		v.dataitem.UnBefore()
+		u.dataitem.UnBefore()
+		parent.dataitem.UnBefore()
+		return false, err
+	}
+
+	newPairs := make([]pair, 0, len(pairs)-1)
+	newPairs = append(newPairs, pairs[:j]...)
+	newPairs = append(newPairs, pair{son: pairs[j].son, key: pairs[j+1].key, inf: pairs[j+1].inf})
+	newPairs = append(newPairs, pairs[j+2:]...)
+	underflow := newSon == utils.NilUUID && needMerge(newPairs)
+	setRawPairs(parent.raw, newPairs)
+
+	// 按照父节点, u, v的顺序写入, 以保证崩溃时B+树依然是正确的, 见protocols/tree_recovery.go.
+	parent.dataitem.After(tm.SUPER_XID)
+	u.dataitem.After(tm.SUPER_XID)
+	v.dataitem.After(tm.SUPER_XID)
+
+	if newSon != utils.NilUUID {
+		ancestors := make([]utils.UUID, len(path), len(path)+1)
+		copy(ancestors, path)
+		err = bt.post(append(ancestors, parentUUID), newSon, newKey)
+		if err != nil {
+			return false, err
+		}
+	}
+	return underflow, nil
+}
+
+// post 将新节点son插入path中的最后一个节点, 如果该节点因此分裂, 则继续向上插入, path为从根节点开始的路径.
+func (bt *bPlusTree) post(path []utils.UUID, son utils.UUID, key []byte) error {
+	for i := len(path) - 1; i >= 0; i-- {
+		newSon, newKey, err := bt.insertAndSplit(path[i], son, key)
+		if err == ErrNodeMerged { // 和insert一样, 将son插入当前的父节点
+			return bt.repost(son, key)
+		}
+		if err != nil {
+			return err
+		}
+		if newSon == utils.NilUUID {
+			return nil
+		}
+		son, key = newSon, newKey
+	}
+	return bt.updateRootUUID(path[0], son, key)
+}
+
+/*
+	collapseRoot 如果根节点是只有一个子节点的内部节点, 则让该子节点成为新的根节点, 直到不再满足该条件.
+	如果该子节点有右边的兄弟节点, 则其兄弟节点还没有被插入根节点, 此时不能收缩,
+	否则其兄弟节点就会与新的根节点处于同一层, 再也不能被插入父节点.
+*/
+func (bt *bPlusTree) collapseRoot() error {
+	bt.bootLock.Lock()
+	defer bt.bootLock.Unlock()
+
+	for {
+		root, err := loadNode(bt, utils.ParseUUID(bt.bootDataitem.Data()))
+		if err != nil {
+			return err
+		}
+
+		root.dataitem.Before()
+		pairs := getRawPairs(root.raw)
+		if getRawIsLeaf(root.raw) || len(pairs) != 1 || getRawSibling(root.raw) != utils.NilUUID {
+			root.dataitem.UnBefore()
+			root.Release()
+			return nil
+		}
+		son, err := loadNode(bt, pairs[0].son)
+		if err != nil {
+			root.dataitem.UnBefore()
+			root.Release()
+			return err
+		}
+		sibling := son.Sibling()
+		son.Release()
+		if sibling != utils.NilUUID {
+			root.dataitem.UnBefore()
+			root.Release()
+			return nil
+		}
+
+		bt.bootDataitem.Before()
+		copy(bt.bootDataitem.Data(), utils.UUIDToRaw(pairs[0].son))
+		bt.bootDataitem.After(tm.SUPER_XID)
+		// 在boot指向新的根节点之后再标记, 以免崩溃时根节点已经被标记为合并
+		setRawMerged(root.raw)
+		root.dataitem.After(tm.SUPER_XID)
+		root.Release()
+	}
+}
@@ -4,6 +4,8 @@
 package im
 
 import (
+	"bytes"
+	"errors"
 	"nyadb2/backend/dm"
 	"nyadb2/backend/tm"
 	"nyadb2/backend/utils"
@@ -15,21 +17,39 @@ const (
 	_SIBLING_OFFSET   = _NO_KEYS_OFFSET + 2
 	_NODE_HEADER_SIZE = _SIBLING_OFFSET + utils.LEN_UUID
 
-	_BALANCE_NUMBER = 32
-	_NODE_SIZE      = _NODE_HEADER_SIZE + (2*utils.LEN_UUID)*(_BALANCE_NUMBER*2+2)
+	_PAIR_HEADER_SIZE = utils.LEN_UUID + 2
+	_MAX_PAIR_SIZE    = _PAIR_HEADER_SIZE + MAX_KEY_LEN
+	_INF_KEY_LEN      = 0xFFFF
+
+	_NODE_SIZE = 2048
+
+	_LEAF_FLAG   = byte(1)
+	_MERGED_FLAG = byte(2)
 )
 
+// ErrNodeMerged 表示被修改的节点已经被合并, 见merge.go.
+var ErrNodeMerged = errors.New("Node has been merged.")
+
 /*
 	node的二进制结构如下:
-	[Leaf Flag] bool
+	[Flags] byte, 第0位表示是否为叶节点, 第1位表示是否已经被合并(见merge.go)
 	[No Of keys] uint16
 	[Sibling UUID] UUID
 	[Pair1], [Pair2] ... [PariN]
-    [Son0] [Key0] [Son1] [Key1] ... [SonN] [KeyN]
+
+	每个Pair的结构如下:
+	[Son] UUID
+	[Key Len] uint16
+	[Key] *
 
 	在一般的B+树算法中, 内部节点都会有一个MaxPointer, 指向最右边的子节点.
 	我们这里将其特殊处理, 将MaxPointer处理成了SonN, 将keyN固定为INF.
+	INF用[Key Len]为_INF_KEY_LEN来表示, 它比任何key都大.
 	这样, 内部节点和叶节点就有了一致的二进制结构.
+
+	由于key是变长的, 所以节点是否需要分裂, 是按照其所占的字节数来判断的.
+	任何时候, 节点剩余的空间都至少能再放下一个最大的Pair, 所以插入总是能够成功,
+	插入后如果剩余空间不足, 则进行分裂. 反之, 删除后如果所占的空间不足节点的1/4, 则与兄弟节点合并.
 */
 type node struct {
 	bt       *bPlusTree
@@ -39,16 +59,44 @@ type node struct {
 	selfUUID utils.UUID
 }
 
+type pair struct {
+	son utils.UUID
+	key []byte
+	inf bool
+}
+
+func (p *pair) size() int {
+	return _PAIR_HEADER_SIZE + len(p.key)
+}
+
+// less 判断key是否小于p的key
+func (p *pair) less(key []byte) bool {
+	return p.inf || bytes.Compare(key, p.key) < 0
+}
+
+// lessOrEqual 判断key是否小于等于p的key
+func (p *pair) lessOrEqual(key []byte) bool {
+	return p.inf || bytes.Compare(key, p.key) <= 0
+}
+
 func setRawIsLeaf(raw []byte, isLeaf bool) {
 	if isLeaf {
-		raw[_IS_LEAF_OFFSET] = byte(1)
+		raw[_IS_LEAF_OFFSET] |= _LEAF_FLAG
 	} else {
-		raw[_IS_LEAF_OFFSET] = byte(0)
+		raw[_IS_LEAF_OFFSET] &^= _LEAF_FLAG
 	}
 }
 
 func getRawIsLeaf(raw []byte) bool {
-	return raw[_IS_LEAF_OFFSET] == byte(1)
+	return raw[_IS_LEAF_OFFSET]&_LEAF_FLAG != 0
+}
+
+func setRawMerged(raw []byte) {
+	raw[_IS_LEAF_OFFSET] |= _MERGED_FLAG
+}
+
+func getRawMerged(raw []byte) bool {
+	return raw[_IS_LEAF_OFFSET]&_MERGED_FLAG != 0
 }
 
 func setRawNoKeys(raw []byte, noKeys int) {
@@ -67,49 +115,62 @@ func getRawSibling(raw []byte) utils.UUID {
 	return utils.ParseUUID(raw[_SIBLING_OFFSET:])
 }
 
-func setRawKthSon(raw []byte, uid utils.UUID, kth int) {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2)
-	utils.PutUUID(raw[offset:], uid)
-}
-
-func getRawKthSon(raw []byte, kth int) utils.UUID {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2)
-	return utils.ParseUUID(raw[offset:])
-}
-
-func setRawKthKey(raw []byte, key utils.UUID, kth int) {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2) + utils.LEN_UUID
-	utils.PutUUID(raw[offset:], key)
-}
-
-func getRawKthKey(raw []byte, kth int) utils.UUID {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2) + utils.LEN_UUID
-	return utils.ParseUUID(raw[offset:])
+// getRawPairs 解析出raw中所有的pair, pair中的key与raw共享内存.
+func getRawPairs(raw []byte) []pair {
+	noKeys := getRawNoKeys(raw)
+	pairs := make([]pair, noKeys)
+	offset := _NODE_HEADER_SIZE
+	for i := 0; i < noKeys; i++ {
+		pairs[i].son = utils.ParseUUID(raw[offset:])
+		keyLen := int(utils.ParseUint16(raw[offset+utils.LEN_UUID:]))
+		offset += _PAIR_HEADER_SIZE
+		if keyLen == _INF_KEY_LEN {
+			pairs[i].inf = true
+		} else {
+			pairs[i].key = raw[offset : offset+keyLen]
+			offset += keyLen
+		}
+	}
+	return pairs
 }
 
-func copyRawFromKth(from, to []byte, kth int) {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2)
-	copy(to[_NODE_HEADER_SIZE:], from[offset:])
+// setRawPairs 将pairs写入raw, 并更新[No Of Keys].
+// 由于pairs中的key可能与raw共享内存, 所以先写入一个新的buf, 再拷贝回raw.
+func setRawPairs(raw []byte, pairs []pair) {
+	buf := make([]byte, _NODE_SIZE-_NODE_HEADER_SIZE)
+	offset := 0
+	for _, p := range pairs {
+		utils.PutUUID(buf[offset:], p.son)
+		if p.inf {
+			utils.PutUint16(buf[offset+utils.LEN_UUID:], _INF_KEY_LEN)
+		} else {
+			utils.PutUint16(buf[offset+utils.LEN_UUID:], uint16(len(p.key)))
+		}
+		offset += _PAIR_HEADER_SIZE
+		copy(buf[offset:], p.key)
+		offset += len(p.key)
+	}
+	copy(raw[_NODE_HEADER_SIZE:], buf)
+	setRawNoKeys(raw, len(pairs))
 }
 
-func shiftRawKth(raw []byte, kth int) {
-	begin := _NODE_HEADER_SIZE + (kth+1)*(utils.LEN_UUID*2)
-	end := _NODE_SIZE - 1
-	for i := end; i >= begin; i-- { // copy(raw, raw) is dangerous
-		raw[i] = raw[i-(utils.LEN_UUID*2)]
+func pairsSize(pairs []pair) int {
+	size := 0
+	for i := range pairs {
+		size += pairs[i].size()
 	}
+	return size
 }
 
 // newRootRaw 新建一个根节点, 该根节点的初始两个子节点为left和right, 初始键值为key
-func newRootRaw(left, right, key utils.UUID) []byte {
+func newRootRaw(left, right utils.UUID, key []byte) []byte {
 	raw := make([]byte, _NODE_SIZE)
 	setRawIsLeaf(raw, false)
-	setRawNoKeys(raw, 2)
 	setRawSibling(raw, utils.NilUUID)
-	setRawKthSon(raw, left, 0)
-	setRawKthKey(raw, key, 0)
-	setRawKthSon(raw, right, 1)
-	setRawKthKey(raw, utils.INF, 1)
+	setRawPairs(raw, []pair{
+		{son: left, key: key},
+		{son: right, inf: true},
+	})
 	return raw
 }
 
@@ -149,42 +210,48 @@ func (u *node) IsLeaf() bool {
 	return getRawIsLeaf(u.raw)
 }
 
-// SearchNext 寻找对应key的uuid, 如果找不到, 则返回sibling uuid
-func (u *node) SearchNext(key utils.UUID) (utils.UUID, utils.UUID) {
+func (u *node) Sibling() utils.UUID {
 	u.dataitem.RLock()
 	defer u.dataitem.RUnlock()
 
-	noKeys := getRawNoKeys(u.raw)
-	for i := 0; i < noKeys; i++ {
-		ik := getRawKthKey(u.raw, i)
-		if key < ik {
-			return getRawKthSon(u.raw, i), utils.NilUUID
+	return getRawSibling(u.raw)
+}
+
+// SearchNext 寻找对应key的uuid, 如果找不到, 则返回sibling uuid.
+// 由于相同的key可能被分裂到相邻的两个节点中, 查询时需要找到可能包含key的最左边的子节点,
+// 此时leftmost为true; 插入时则没有这个要求, leftmost为false.
+func (u *node) SearchNext(key []byte, leftmost bool) (utils.UUID, utils.UUID) {
+	u.dataitem.RLock()
+	defer u.dataitem.RUnlock()
+
+	pairs := getRawPairs(u.raw)
+	for i := range pairs {
+		if (leftmost && pairs[i].lessOrEqual(key)) || (!leftmost && pairs[i].less(key)) {
+			return pairs[i].son, utils.NilUUID
 		}
 	}
 	return utils.NilUUID, getRawSibling(u.raw)
 }
 
-// LeafSearchRange 在该节点上查询属于[leftKey, rightKey]的地址,
-// 如果rightKey大于等于该节点的最大的key, 则还返回一个sibling uuid.
-func (u *node) LeafSearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, utils.UUID) {
+// LeafSearchRange 在该节点上查询属于[leftKey, rightKey)的地址, rightKey为nil表示正无穷.
+// 如果rightKey大于该节点的最大的key, 则还返回一个sibling uuid.
+func (u *node) LeafSearchRange(leftKey, rightKey []byte) ([]utils.UUID, utils.UUID) {
 	u.dataitem.RLock()
 	defer u.dataitem.RUnlock()
 
-	noKeys := getRawNoKeys(u.raw)
+	pairs := getRawPairs(u.raw)
 	var kth int
-	for kth < noKeys {
-		ik := getRawKthKey(u.raw, kth)
-		if ik >= leftKey {
+	for kth < len(pairs) {
+		if bytes.Compare(pairs[kth].key, leftKey) >= 0 {
 			break
 		}
 		kth++
 	}
 
 	var uuids []utils.UUID
-	for kth < noKeys {
-		ik := getRawKthKey(u.raw, kth)
-		if ik <= rightKey {
-			uuids = append(uuids, getRawKthSon(u.raw, kth))
+	for kth < len(pairs) {
+		if rightKey == nil || bytes.Compare(pairs[kth].key, rightKey) < 0 {
+			uuids = append(uuids, pairs[kth].son)
 			kth++
 		} else {
 			break
@@ -192,7 +259,7 @@ func (u *node) LeafSearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, util
 	}
 
 	var sibling utils.UUID = utils.NilUUID
-	if kth == noKeys {
+	if kth == len(pairs) {
 		sibling = getRawSibling(u.raw)
 	}
 
@@ -206,8 +273,8 @@ func (u *node) LeafSearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, util
 	p0, k0, p1, k1         p2, k2, p3, INF
 */
 // InsertAndSplit 将对应的数据插入该节点, 并尝试进行分裂.
-// 如果该份数据不应该插入到此节点, 则返回一个sibling uuid.
-func (u *node) InsertAndSplit(uuid, key utils.UUID) (utils.UUID, utils.UUID, utils.UUID, error) {
+// 如果该份数据不应该插入到此节点, 则返回一个sibling uuid; 如果该节点已经被合并, 则返回ErrNodeMerged.
+func (u *node) InsertAndSplit(uuid utils.UUID, key []byte) (utils.UUID, utils.UUID, []byte, error) {
 	var succ bool
 	var err error
 
@@ -220,73 +287,197 @@ func (u *node) InsertAndSplit(uuid, key utils.UUID) (utils.UUID, utils.UUID, uti
 		}
 	}()
 
+	if getRawMerged(u.raw) {
+		err = ErrNodeMerged
+		return utils.NilUUID, utils.NilUUID, nil, err
+	}
 	succ = u.insert(uuid, key)
 	if succ == false {
-		return getRawSibling(u.raw), utils.NilUUID, utils.NilUUID, nil
+		return getRawSibling(u.raw), utils.NilUUID, nil, nil
 	}
 
 	if u.needSplit() {
 		var newSon utils.UUID
-		var newKey utils.UUID
+		var newKey []byte
 		newSon, newKey, err = u.split()
 		return utils.NilUUID, newSon, newKey, err
 	} else {
-		return utils.NilUUID, utils.NilUUID, utils.NilUUID, nil
+		return utils.NilUUID, utils.NilUUID, nil, nil
 	}
 }
 
-func (u *node) insert(uuid utils.UUID, key utils.UUID) bool {
-	noKeys := getRawNoKeys(u.raw)
+func (u *node) insert(uuid utils.UUID, key []byte) bool {
+	pairs := getRawPairs(u.raw)
 	var kth int
-	for kth < noKeys {
-		ik := getRawKthKey(u.raw, kth)
-		if ik < key {
+	for kth < len(pairs) {
+		if pairs[kth].less(key) == false && bytes.Equal(pairs[kth].key, key) == false {
 			kth++
 		} else {
 			break
 		}
 	}
-	if kth == noKeys && getRawSibling(u.raw) != utils.NilUUID {
+	if kth == len(pairs) && getRawSibling(u.raw) != utils.NilUUID {
 		// 如果该节点有右继节点, 且该key大于该节点所有key
 		// 则让该key被插入到右继节点去
 		return false
 	}
 
+	newPairs := make([]pair, 0, len(pairs)+1)
+	newPairs = append(newPairs, pairs[:kth]...)
 	if getRawIsLeaf(u.raw) == true {
-		shiftRawKth(u.raw, kth)
-		setRawKthKey(u.raw, key, kth)
-		setRawKthSon(u.raw, uuid, kth)
-		setRawNoKeys(u.raw, noKeys+1)
+		newPairs = append(newPairs, pair{son: uuid, key: key})
+		newPairs = append(newPairs, pairs[kth:]...)
 	} else {
-		kk := getRawKthKey(u.raw, kth)
-		setRawKthKey(u.raw, key, kth)
-		shiftRawKth(u.raw, kth+1)
-		setRawKthKey(u.raw, kk, kth+1)
-		setRawKthSon(u.raw, uuid, kth+1)
-		setRawNoKeys(u.raw, noKeys+1)
+		// 原来的第kth个子节点分裂出了uuid, 其中key及以后的部分在uuid中.
+		newPairs = append(newPairs, pair{son: pairs[kth].son, key: key})
+		newPairs = append(newPairs, pair{son: uuid, key: pairs[kth].key, inf: pairs[kth].inf})
+		newPairs = append(newPairs, pairs[kth+1:]...)
 	}
+	setRawPairs(u.raw, newPairs)
 	return true
 }
 
+// LeafDelete 从该叶节点中删除(key, uuid), 返回是否找到了该键值对, 以及删除之后该节点是否需要合并.
+// 如果没有找到, 且该节点中所有的key都不大于key, 则还返回一个sibling uuid, 该键值对可能在其中.
+// 如果该节点已经被合并, 则返回ErrNodeMerged.
+func (u *node) LeafDelete(uuid utils.UUID, key []byte) (bool, bool, utils.UUID, error) {
+	u.dataitem.Before()
+	if getRawMerged(u.raw) {
+		u.dataitem.UnBefore()
+		return false, false, utils.NilUUID, ErrNodeMerged
+	}
+	pairs := getRawPairs(u.raw)
+	for i := range pairs {
+		cmp := bytes.Compare(pairs[i].key, key)
+		if cmp > 0 {
+			break
+		}
+		if cmp == 0 && pairs[i].son == uuid {
+			newPairs := make([]pair, 0, len(pairs)-1)
+			newPairs = append(newPairs, pairs[:i]...)
+			newPairs = append(newPairs, pairs[i+1:]...)
+			underflow := needMerge(newPairs)
+			setRawPairs(u.raw, newPairs)
+			u.dataitem.After(tm.SUPER_XID)
+			return true, underflow, utils.NilUUID, nil
+		}
+	}
+
+	sibling := utils.NilUUID
+	if len(pairs) == 0 || bytes.Compare(pairs[len(pairs)-1].key, key) <= 0 {
+		sibling = getRawSibling(u.raw)
+	}
+	u.dataitem.UnBefore()
+	return false, false, sibling, nil
+}
+
+// FindSon 在该内部节点中查找子节点son, 该子节点包含key.
+// 如果该节点中有大于key的key, 则son只可能在它之前; 否则还返回一个sibling uuid, son可能在其中.
+func (u *node) FindSon(son utils.UUID, key []byte) (bool, utils.UUID) {
+	u.dataitem.RLock()
+	defer u.dataitem.RUnlock()
+
+	pairs := getRawPairs(u.raw)
+	for i := range pairs {
+		if pairs[i].son == son {
+			return true, utils.NilUUID
+		}
+		if pairs[i].less(key) {
+			return false, utils.NilUUID
+		}
+	}
+	return false, getRawSibling(u.raw)
+}
+
 func (u *node) needSplit() bool {
-	return _BALANCE_NUMBER*2 == getRawNoKeys(u.raw)
+	pairs := getRawPairs(u.raw)
+	return _NODE_HEADER_SIZE+pairsSize(pairs)+_MAX_PAIR_SIZE > _NODE_SIZE
 }
 
-func (u *node) split() (utils.UUID, utils.UUID, error) {
-	nodeRaw := make([]byte, _NODE_SIZE)
+// needMerge 判断pairs所占的空间是否不足节点的1/4, 此时需要与兄弟节点合并.
+func needMerge(pairs []pair) bool {
+	return _NODE_HEADER_SIZE+pairsSize(pairs) < _NODE_SIZE/4
+}
+
+// splitPoint 按照字节数将pairs分为两半, 返回后一半的起始位置.
+func splitPoint(pairs []pair) int {
+	half := pairsSize(pairs) / 2
+	mid, size := 0, 0
+	for mid < len(pairs)-1 && size < half {
+		size += pairs[mid].size()
+		mid++
+	}
+	if mid == len(pairs)-1 && pairs[mid].inf { // 保证新节点的第一个key不为INF
+		mid--
+	}
+	return mid
+}
+
+// split 将该节点按照字节数分为两半, 后一半被移动到新节点中, 返回新节点的地址和它的第一个key.
+func (u *node) split() (utils.UUID, []byte, error) {
+	pairs := getRawPairs(u.raw)
+	mid := splitPoint(pairs)
 
+	nodeRaw := make([]byte, _NODE_SIZE)
 	setRawIsLeaf(nodeRaw, getRawIsLeaf(u.raw))
-	setRawNoKeys(nodeRaw, _BALANCE_NUMBER)
 	setRawSibling(nodeRaw, getRawSibling(u.raw))
-	copyRawFromKth(u.raw, nodeRaw, _BALANCE_NUMBER)
-	son, err := u.bt.DM.Insert(tm.SUPER_XID, nodeRaw)
+	setRawPairs(nodeRaw, pairs[mid:])
+	newKey := make([]byte, len(pairs[mid].key))
+	copy(newKey, pairs[mid].key)
 
+	son, err := u.bt.DM.Insert(tm.SUPER_XID, nodeRaw)
 	if err != nil {
-		return utils.NilUUID, utils.NilUUID, err
+		return utils.NilUUID, nil, err
 	}
 
-	setRawNoKeys(u.raw, _BALANCE_NUMBER)
+	setRawPairs(u.raw, pairs[:mid])
 	setRawSibling(u.raw, son)
 
-	return son, getRawKthKey(nodeRaw, 0), nil
+	return son, newKey, nil
+}
+
+/*
+	Absorb 将右边的兄弟节点v合并到u中, 并将v标记为已合并, 调用者需要持有u和v的写锁.
+	如果合并之后放不下, 则将二者的内容按照字节数平均分配到u和一个新节点中,
+	并返回新节点的地址和它的第一个key, 新节点需要像分裂产生的节点一样被插入父节点.
+	如果u和v不能或不再需要合并, 则返回false, 此时u和v都没有被修改.
+*/
+func (u *node) Absorb(v *node) (bool, utils.UUID, []byte, error) {
+	if getRawMerged(u.raw) || getRawMerged(v.raw) || getRawSibling(u.raw) != v.selfUUID ||
+		getRawIsLeaf(u.raw) != getRawIsLeaf(v.raw) {
+		return false, utils.NilUUID, nil, nil
+	}
+	uPairs, vPairs := getRawPairs(u.raw), getRawPairs(v.raw)
+	if needMerge(uPairs) == false && needMerge(vPairs) == false {
+		return false, utils.NilUUID, nil, nil
+	}
+	if len(uPairs) > 0 && uPairs[len(uPairs)-1].inf { // 只有每层最右边的节点的最后一个key为INF
+		return false, utils.NilUUID, nil, nil
+	}
+
+	pairs := append(uPairs, vPairs...)
+	if _NODE_HEADER_SIZE+pairsSize(pairs)+_MAX_PAIR_SIZE <= _NODE_SIZE {
+		setRawPairs(u.raw, pairs)
+		setRawSibling(u.raw, getRawSibling(v.raw))
+		setRawMerged(v.raw)
+		return true, utils.NilUUID, nil, nil
+	}
+
+	mid := splitPoint(pairs)
+	nodeRaw := make([]byte, _NODE_SIZE)
+	setRawIsLeaf(nodeRaw, getRawIsLeaf(u.raw))
+	setRawSibling(nodeRaw, getRawSibling(v.raw))
+	setRawPairs(nodeRaw, pairs[mid:])
+	newKey := make([]byte, len(pairs[mid].key))
+	copy(newKey, pairs[mid].key)
+
+	son, err := u.bt.DM.Insert(tm.SUPER_XID, nodeRaw)
+	if err != nil {
+		return false, utils.NilUUID, nil, err
+	}
+
+	setRawPairs(u.raw, pairs[:mid])
+	setRawSibling(u.raw, son)
+	setRawMerged(v.raw)
+	return true, son, newKey, nil
 }
@@ -14,10 +14,24 @@ import (
 	"sync"
 )
 
+// MAX_KEY_LEN 为B+树中存储的key的最大长度, 超过该长度的key会被截断.
+const MAX_KEY_LEN = 128
+
+/*
+	B+树的key是任意的字节串, 按照字节序进行比较, 同一个key可以对应多个uuid.
+	SearchRange查询的区间为[leftKey, rightKey), rightKey为nil表示正无穷.
+
+	超过MAX_KEY_LEN的key在插入和查询时都会被截断为前MAX_KEY_LEN个字节,
+	所以对于这样的key, 查询的结果可能是实际结果的超集, 需要上层再次检查.
+
+	Delete删除一个(key, uuid)的键值对, 并返回它是否存在. 删除之后, 过空的节点会与兄弟节点合并,
+	只有一个子节点的根节点会被收缩, 见merge.go.
+*/
 type BPlusTree interface {
-	Insert(key, uuid utils.UUID) error
-	Search(key utils.UUID) ([]utils.UUID, error)
-	SearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, error)
+	Insert(key []byte, uuid utils.UUID) error
+	Delete(key []byte, uuid utils.UUID) (bool, error)
+	Search(key []byte) ([]utils.UUID, error)
+	SearchRange(leftKey, rightKey []byte) ([]utils.UUID, error)
 }
 
 /*
@@ -26,8 +40,6 @@ type BPlusTree interface {
 
 	PS: 因为B+树在算法执行过程中, 根节点可能会发生改变, 所以不能直接用根节点的地址当boot,
 	而需要一个固定的boot, 用来指向它的根节点.
-
-	PS: 目前B+树支持的最大键值为INF-1
 */
 type bPlusTree struct {
 	bootUUID     utils.UUID
@@ -74,25 +86,117 @@ func (bt *bPlusTree) rootUUID() utils.UUID {
 	return utils.ParseUUID(bt.bootDataitem.Data())
 }
 
-// updaterootUUID 更新该树的根节点
-func (bt *bPlusTree) updateRootUUID(left, right, rightKey utils.UUID) error {
+/*
+	updateRootUUID 在根节点left分裂出right之后, 创建以left和right为子节点的新的根节点.
+
+	在插入者读取根节点之后, 根节点可能已经被其他插入者的分裂替换了, 此时left已经不再是根节点,
+	如果依然创建新的根节点, 则会覆盖掉当前的根节点, 丢失其他插入者创建的上层节点.
+	所以这种情况下, 会通过repost将right插入left上面一层中可能包含rightKey的节点.
+*/
+func (bt *bPlusTree) updateRootUUID(left, right utils.UUID, rightKey []byte) error {
+	ok, err := bt.newRoot(left, right, rightKey)
+	if err != nil || ok {
+		return err
+	}
+	return bt.repost(right, rightKey)
+}
+
+/*
+	repost 从当前的根节点开始搜索key, 将新节点son插入比它高一层的节点中, 如果该节点因此分裂, 则继续向上处理.
+	它用于son原来应该被插入的节点已经不是根节点, 或者已经被合并的情况.
+	被合并的节点在被标记之前就已经不再被其上一层的节点指向, 所以如果搜索到的节点在插入时已经被合并,
+	则重新搜索即可. 如果根节点不高于son, 则son依然可以通过其左边的兄弟节点找到, 不需要插入.
+*/
+func (bt *bPlusTree) repost(son utils.UUID, key []byte) error {
+	for {
+		parent, err := bt.searchParentLevel(son, key)
+		if err != nil {
+			return err
+		}
+		if parent == utils.NilUUID {
+			return nil
+		}
+		newSon, newKey, err := bt.insertAndSplit(parent, son, key)
+		if err == ErrNodeMerged {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if newSon == utils.NilUUID {
+			return nil
+		}
+		return bt.updateRootUUID(parent, newSon, newKey)
+	}
+}
+
+// newRoot 如果根节点依然是left, 则创建以left和right为子节点的新的根节点, 否则返回false.
+func (bt *bPlusTree) newRoot(left, right utils.UUID, rightKey []byte) (bool, error) {
 	bt.bootLock.Lock()
 	defer bt.bootLock.Unlock()
 
+	if utils.ParseUUID(bt.bootDataitem.Data()) != left {
+		return false, nil
+	}
 	rootRaw := newRootRaw(left, right, rightKey)
 	newRootUUID, err := bt.DM.Insert(tm.SUPER_XID, rootRaw)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	bt.bootDataitem.Before()
 	copy(bt.bootDataitem.Data(), utils.UUIDToRaw(newRootUUID))
 	bt.bootDataitem.After(tm.SUPER_XID)
-	return nil
+	return true, nil
+}
+
+// height 返回nodeUUID的高度, 叶节点的高度为1.
+func (bt *bPlusTree) height(nodeUUID utils.UUID) (int, error) {
+	for h := 1; ; h++ {
+		node, err := loadNode(bt, nodeUUID)
+		if err != nil {
+			return 0, err
+		}
+		isLeaf := node.IsLeaf()
+		node.Release()
+		if isLeaf {
+			return h, nil
+		}
+
+		nodeUUID, err = bt.searchNext(nodeUUID, nil, true)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// searchParentLevel 从当前的根节点开始搜索key, 返回比node高一层的节点中, 最先访问到的节点.
+// 如果根节点不高于node, 则返回NilUUID.
+func (bt *bPlusTree) searchParentLevel(node utils.UUID, key []byte) (utils.UUID, error) {
+	h, err := bt.height(node)
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	rootUUID := bt.rootUUID()
+	rh, err := bt.height(rootUUID)
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	if rh <= h {
+		return utils.NilUUID, nil
+	}
+
+	for ; rh > h+1; rh-- {
+		rootUUID, err = bt.searchNext(rootUUID, key, false)
+		if err != nil {
+			return utils.NilUUID, err
+		}
+	}
+	return rootUUID, nil
 }
 
-// searchLeaf 根据key, 在nodeUUID代表节点的子树中搜索, 直到找到其对应的叶节点地址.
-func (bt *bPlusTree) searchLeaf(nodeUUID, key utils.UUID) (utils.UUID, error) {
+// searchLeaf 根据key, 在nodeUUID代表节点的子树中搜索, 直到找到可能包含key的最左边的叶节点地址.
+func (bt *bPlusTree) searchLeaf(nodeUUID utils.UUID, key []byte) (utils.UUID, error) {
 	node, err := loadNode(bt, nodeUUID)
 	if err != nil {
 		return utils.NilUUID, err
@@ -104,7 +208,7 @@ func (bt *bPlusTree) searchLeaf(nodeUUID, key utils.UUID) (utils.UUID, error) {
 	if isLeaf {
 		return nodeUUID, nil
 	} else {
-		next, err := bt.searchNext(nodeUUID, key)
+		next, err := bt.searchNext(nodeUUID, key, true)
 		if err != nil {
 			return utils.NilUUID, err
 		}
@@ -112,14 +216,37 @@ func (bt *bPlusTree) searchLeaf(nodeUUID, key utils.UUID) (utils.UUID, error) {
 	}
 }
 
+// searchPath 和searchLeaf一样搜索key, 并返回从nodeUUID开始, 每一层最先访问到的节点,
+// 其中最后一个为可能包含key的最左边的叶节点.
+func (bt *bPlusTree) searchPath(nodeUUID utils.UUID, key []byte) ([]utils.UUID, error) {
+	var path []utils.UUID
+	for {
+		path = append(path, nodeUUID)
+		node, err := loadNode(bt, nodeUUID)
+		if err != nil {
+			return nil, err
+		}
+		isLeaf := node.IsLeaf()
+		node.Release()
+		if isLeaf {
+			return path, nil
+		}
+
+		nodeUUID, err = bt.searchNext(nodeUUID, key, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 // serachNext 从nodeUUID对应节点开始, 不断的向右试探兄弟节点, 找到对应key的next uuid
-func (bt *bPlusTree) searchNext(nodeUUID, key utils.UUID) (utils.UUID, error) {
+func (bt *bPlusTree) searchNext(nodeUUID utils.UUID, key []byte, leftmost bool) (utils.UUID, error) {
 	for {
 		node, err := loadNode(bt, nodeUUID)
 		if err != nil {
 			return utils.NilUUID, err
 		}
-		next, siblingUUID := node.SearchNext(key)
+		next, siblingUUID := node.SearchNext(key, leftmost)
 		node.Release()
 		if next != utils.NilUUID {
 			return next, nil
@@ -128,11 +255,24 @@ func (bt *bPlusTree) searchNext(nodeUUID, key utils.UUID) (utils.UUID, error) {
 	}
 }
 
-func (bt *bPlusTree) Search(key utils.UUID) ([]utils.UUID, error) {
-	return bt.SearchRange(key, key)
+// truncateKey 将超过MAX_KEY_LEN的key截断.
+func truncateKey(key []byte) []byte {
+	if len(key) > MAX_KEY_LEN {
+		return key[:MAX_KEY_LEN]
+	}
+	return key
 }
 
-func (bt *bPlusTree) SearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, error) {
+func (bt *bPlusTree) Search(key []byte) ([]utils.UUID, error) {
+	return bt.SearchRange(key, utils.SuccKey(key))
+}
+
+func (bt *bPlusTree) SearchRange(leftKey, rightKey []byte) ([]utils.UUID, error) {
+	leftKey = truncateKey(leftKey)
+	if len(rightKey) > MAX_KEY_LEN {
+		// 被截断的rightKey本身也可能对应着区间内的key, 所以需要包含它.
+		rightKey = utils.SuccKey(truncateKey(rightKey))
+	}
 	rootUUID := bt.rootUUID()
 
 	leafUUID, err := bt.searchLeaf(rootUUID, leftKey)
@@ -161,35 +301,33 @@ func (bt *bPlusTree) SearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, er
 }
 
 // Insert 向B+树种插入(uuid, key)的键值对
-func (bt *bPlusTree) Insert(key, uuid utils.UUID) error {
-	rootUUID := bt.rootUUID()
-
-	newNode, newKey, err := bt.insert(rootUUID, uuid, key)
-	if err != nil {
-		return err
-	}
-
-	/*
-		如果newNode != nil, 则需要更变根节点了.
+func (bt *bPlusTree) Insert(key []byte, uuid utils.UUID) error {
+	key = truncateKey(key)
+	for {
+		rootUUID := bt.rootUUID()
 
-		// TODO
-		这里有一个小bug, 如果同时有多个事务都准备updaterootUUID，
-		那么会相互覆盖.
-		但是由于我们的_BALANCE_NUMBER比较大, 故一般不会出现这种情况,
-		暂且先作为一个未处理bug.
-	*/
-	if newNode != utils.NilUUID { // 更新根节点
-		err := bt.updateRootUUID(rootUUID, newNode, newKey)
+		newNode, newKey, err := bt.insert(rootUUID, uuid, key)
+		if err == ErrNodeMerged { // 遇到了已经被合并的叶节点, 从根节点重新开始
+			continue
+		}
 		if err != nil {
 			return err
 		}
-	}
 
-	return nil
+		// 如果newNode != nil, 则需要更变根节点了.
+		if newNode != utils.NilUUID { // 更新根节点
+			err := bt.updateRootUUID(rootUUID, newNode, newKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
 }
 
 // insert 将(uuid, key)插入到B+树中, 如果有分裂, 则将分裂产生的新节点也返回.
-func (bt *bPlusTree) insert(nodeUUID, uuid, key utils.UUID) (newNodeUUID, newNodeKey utils.UUID, err error) {
+func (bt *bPlusTree) insert(nodeUUID, uuid utils.UUID, key []byte) (newNodeUUID utils.UUID, newNodeKey []byte, err error) {
 	var node *node
 	node, err = loadNode(bt, nodeUUID)
 	if err != nil {
@@ -203,13 +341,13 @@ func (bt *bPlusTree) insert(nodeUUID, uuid, key utils.UUID) (newNodeUUID, newNod
 		newNodeUUID, newNodeKey, err = bt.insertAndSplit(nodeUUID, uuid, key)
 	} else {
 		var next utils.UUID
-		next, err = bt.searchNext(nodeUUID, key)
+		next, err = bt.searchNext(nodeUUID, key, false)
 		if err != nil {
 			return
 		}
 
 		var newSonUUId utils.UUID
-		var newSonKey utils.UUID
+		var newSonKey []byte
 		newSonUUId, newSonKey, err = bt.insert(next, uuid, key)
 		if err != nil {
 			return
@@ -217,17 +355,21 @@ func (bt *bPlusTree) insert(nodeUUID, uuid, key utils.UUID) (newNodeUUID, newNod
 
 		if newSonUUId != utils.NilUUID { // split
 			newNodeUUID, newNodeKey, err = bt.insertAndSplit(nodeUUID, newSonUUId, newSonKey)
+			if err == ErrNodeMerged {
+				// 该节点已经被合并, 将newSon插入当前的父节点, 上层的分裂也由repost处理.
+				newNodeUUID, newNodeKey, err = utils.NilUUID, nil, bt.repost(newSonUUId, newSonKey)
+			}
 		}
 	}
 	return
 }
 
 // insertAndSplit 函数从node开始, 不断的向右试探兄弟节点, 直到找到一个节点, 能够插入进对应的值
-func (bt *bPlusTree) insertAndSplit(nodeUUID, uuid, key utils.UUID) (utils.UUID, utils.UUID, error) {
+func (bt *bPlusTree) insertAndSplit(nodeUUID, uuid utils.UUID, key []byte) (utils.UUID, []byte, error) {
 	for {
 		node, err := loadNode(bt, nodeUUID)
 		if err != nil {
-			return utils.NilUUID, utils.NilUUID, err
+			return utils.NilUUID, nil, err
 		}
 		siblingSon, newNodeSon, newNodeKey, err := node.InsertAndSplit(uuid, key)
 		node.Release()
@@ -241,6 +383,48 @@ func (bt *bPlusTree) insertAndSplit(nodeUUID, uuid, key utils.UUID) (utils.UUID,
 
 }
 
+// Delete 从B+树中删除(key, uuid)的键值对.
+// 和查询一样, 从可能包含key的最左边的叶节点开始, 向右依次查找, 直到找到该键值对, 或者遇到大于key的key.
+// 删除之后如果叶节点过空, 则将其与兄弟节点合并.
+func (bt *bPlusTree) Delete(key []byte, uuid utils.UUID) (bool, error) {
+	key = truncateKey(key)
+	for {
+		found, err := bt.delete(key, uuid)
+		if err != ErrNodeMerged {
+			return found, err
+		}
+		// 遇到了已经被合并的叶节点, 从根节点重新开始
+	}
+}
+
+// delete 执行一次Delete, 如果遇到了已经被合并的叶节点, 则返回ErrNodeMerged.
+func (bt *bPlusTree) delete(key []byte, uuid utils.UUID) (bool, error) {
+	path, err := bt.searchPath(bt.rootUUID(), key)
+	if err != nil {
+		return false, err
+	}
+
+	leafUUID := path[len(path)-1]
+	for {
+		leaf, err := loadNode(bt, leafUUID)
+		if err != nil {
+			return false, err
+		}
+		found, underflow, siblingUUID, err := leaf.LeafDelete(uuid, key)
+		leaf.Release()
+		if err != nil {
+			return false, err
+		}
+		if found && underflow {
+			return true, bt.rebalance(path[:len(path)-1], leafUUID, key)
+		}
+		if found || siblingUUID == utils.NilUUID {
+			return found, nil
+		}
+		leafUUID = siblingUUID
+	}
+}
+
 func (bt *bPlusTree) Close() {
 	bt.bootDataitem.Release()
 }
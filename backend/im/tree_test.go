@@ -1,8 +1,10 @@
 package im
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
+	"sort"
 	"nyadb2/backend/dm"
 	"nyadb2/backend/dm/pcacher"
 	"nyadb2/backend/tm"
@@ -21,11 +23,11 @@ func TestTreeSingle(t *testing.T) {
 
 	lim := 10000
 	for i := lim - 1; i >= 0; i-- {
-		tree.Insert(utils.UUID(i), utils.UUID(i))
+		tree.Insert(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i))
 	}
 
 	for i := 0; i < lim; i++ {
-		uids, _ := tree.Search(utils.UUID(i))
+		uids, _ := tree.Search(utils.UUIDToKey(utils.UUID(i)))
 		if len(uids) != 1 {
 			t.Fatal("Error")
 		}
@@ -54,7 +56,7 @@ func TestTreeMultiInsert(t *testing.T) {
 	insertor := func() {
 		for i := 0; i < noTasks; i++ {
 			uid := utils.UUID(rand.Uint32())
-			err := tree.Insert(uid, uid)
+			err := tree.Insert(utils.UUIDToKey(uid), uid)
 			if err != nil {
 				continue
 			}
@@ -75,7 +77,7 @@ func TestTreeMultiInsert(t *testing.T) {
 			if key1-key0 > 10000 {
 				key1 = key0 + 10000
 			}
-			tree.SearchRange(key0, key1)
+			tree.SearchRange(utils.UUIDToKey(key0), utils.UUIDToKey(key1))
 		}
 		wg.Done()
 		fmt.Println("reader done.")
@@ -93,10 +95,402 @@ func TestTreeMultiInsert(t *testing.T) {
 
 	fmt.Println("checker begin.")
 	for key, cnt := range aMap {
-		addrs, _ := tree.Search(key)
+		addrs, _ := tree.Search(utils.UUIDToKey(key))
 		if len(addrs) != cnt {
 			t.Fatal("Error")
 		}
 	}
 	fmt.Println("checker end.")
 }
+
+func TestTreeStrKey(t *testing.T) {
+	tm := tm.CreateMock("/tmp/TestTreeStrKey")
+	dm := dm.Create("/tmp/TestTreeStrKey", pcacher.PAGE_SIZE*10, tm)
+	root, _ := Create(dm)
+	tree, _ := Load(root, dm)
+
+	// 大量相同的key会被分裂到多个节点中
+	var keys []string
+	for i := 0; i < 2000; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i%100))
+	}
+	long := string(bytes.Repeat([]byte("x"), MAX_KEY_LEN))
+	keys = append(keys, "", "a", long+"a", long+"b")
+	for i, key := range keys {
+		err := tree.Insert(utils.StrToKey(key), utils.UUID(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(left, right []byte) int {
+		uuids, err := tree.SearchRange(left, right)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(uuids)
+	}
+	if n := count([]byte("key42"), utils.SuccKey([]byte("key42"))); n != 20 {
+		t.Fatal(n)
+	}
+	if n := count(nil, nil); n != len(keys) {
+		t.Fatal(n)
+	}
+	if n := count([]byte("a"), []byte("key")); n != 1 {
+		t.Fatal(n)
+	}
+	if n := count([]byte("key1"), []byte("key2")); n != 11*20 {
+		t.Fatal(n)
+	}
+	// 长key被截断后相同, 查询结果为超集
+	if n := count([]byte(long+"a"), []byte(long+"b")); n != 2 {
+		t.Fatal(n)
+	}
+
+	uuids, _ := tree.SearchRange(nil, []byte("key"))
+	sort.Slice(uuids, func(i, j int) bool { return uuids[i] < uuids[j] })
+	if len(uuids) != 2 || uuids[0] != 2000 || uuids[1] != 2001 {
+		t.Fatal(uuids)
+	}
+}
+
+func TestTreeDelete(t *testing.T) {
+	tm := tm.CreateMock("/tmp/TestTreeDelete")
+	dm := dm.Create("/tmp/TestTreeDelete", pcacher.PAGE_SIZE*10, tm)
+	root, _ := Create(dm)
+	tree, _ := Load(root, dm)
+
+	// 相同的key会被分裂到多个节点中, 删除时需要向右查找
+	lim := 3000
+	for i := 0; i < lim; i++ {
+		err := tree.Insert(utils.StrToKey(fmt.Sprintf("key%d", i%30)), utils.UUID(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < lim; i += 2 {
+		ok, err := tree.Delete(utils.StrToKey(fmt.Sprintf("key%d", i%30)), utils.UUID(i))
+		if err != nil || ok == false {
+			t.Fatal(i, err)
+		}
+	}
+	if ok, _ := tree.Delete(utils.StrToKey("key0"), utils.UUID(0)); ok {
+		t.Fatal("Error")
+	}
+	if ok, _ := tree.Delete(utils.StrToKey("key0"), utils.UUID(1)); ok { // key1的uuid
+		t.Fatal("Error")
+	}
+	if ok, _ := tree.Delete(utils.StrToKey("zzz"), utils.UUID(1)); ok {
+		t.Fatal("Error")
+	}
+
+	for i := 0; i < 30; i++ {
+		uuids, err := tree.Search(utils.StrToKey(fmt.Sprintf("key%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(uuids) != lim/30*(i%2) { // 偶数的uuid都对应偶数的key
+			t.Fatal(i, len(uuids))
+		}
+		for _, uuid := range uuids {
+			if int(uuid)%30 != i || uuid%2 == 0 {
+				t.Fatal(uuid)
+			}
+		}
+	}
+
+	// 删除所有的键值对之后, 依然可以插入和查询
+	for i := 1; i < lim; i += 2 {
+		if ok, _ := tree.Delete(utils.StrToKey(fmt.Sprintf("key%d", i%30)), utils.UUID(i)); ok == false {
+			t.Fatal(i)
+		}
+	}
+	if uuids, _ := tree.SearchRange(nil, nil); len(uuids) != 0 {
+		t.Fatal(len(uuids))
+	}
+	tree.Insert(utils.StrToKey("key5"), utils.UUID(lim))
+	if uuids, _ := tree.SearchRange(nil, nil); len(uuids) != 1 || uuids[0] != utils.UUID(lim) {
+		t.Fatal(uuids)
+	}
+}
+
+// treeShape 返回树的高度, 以及最底层的节点数.
+func treeShape(bt *bPlusTree) (int, int) {
+	height, nodeUUID := 1, bt.rootUUID()
+	for {
+		node, _ := loadNode(bt, nodeUUID)
+		isLeaf := getRawIsLeaf(node.raw)
+		if isLeaf == false {
+			nodeUUID = getRawPairs(node.raw)[0].son
+		}
+		node.Release()
+		if isLeaf {
+			break
+		}
+		height++
+	}
+
+	leaves := 0
+	for nodeUUID != utils.NilUUID {
+		node, _ := loadNode(bt, nodeUUID)
+		nodeUUID = getRawSibling(node.raw)
+		node.Release()
+		leaves++
+	}
+	return height, leaves
+}
+
+func TestTreeMerge(t *testing.T) {
+	tm := tm.CreateMock("/tmp/TestTreeMerge")
+	dm := dm.Create("/tmp/TestTreeMerge", pcacher.PAGE_SIZE*20, tm)
+	root, _ := Create(dm)
+	tree, _ := Load(root, dm)
+	bt := tree.(*bPlusTree)
+
+	lim := 10000
+	for i := 0; i < lim; i++ {
+		tree.Insert(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i))
+	}
+	height, leaves := treeShape(bt)
+	if height < 3 {
+		t.Fatal(height)
+	}
+
+	for i := 0; i < lim; i++ {
+		if i%50 == 0 {
+			continue
+		}
+		ok, err := tree.Delete(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i))
+		if err != nil || ok == false {
+			t.Fatal(i, err)
+		}
+	}
+	for i := 0; i < lim; i++ {
+		uuids, _ := tree.Search(utils.UUIDToKey(utils.UUID(i)))
+		if (i%50 == 0 && (len(uuids) != 1 || uuids[0] != utils.UUID(i))) || (i%50 != 0 && len(uuids) != 0) {
+			t.Fatal(i, uuids)
+		}
+	}
+	uuids, _ := tree.SearchRange(nil, nil)
+	if len(uuids) != lim/50 {
+		t.Fatal(len(uuids))
+	}
+	for i := range uuids {
+		if uuids[i] != utils.UUID(i*50) {
+			t.Fatal(i, uuids[i])
+		}
+	}
+	// 合并之后, 叶节点都至少占用了1/4的空间, 树也变矮了
+	nheight, nleaves := treeShape(bt)
+	if nheight >= height || nleaves > leaves/10 {
+		t.Fatal(height, leaves, nheight, nleaves)
+	}
+
+	// 全部删除之后, 根节点被收缩为一个叶节点
+	for i := 0; i < lim; i += 50 {
+		if ok, _ := tree.Delete(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i)); ok == false {
+			t.Fatal(i)
+		}
+	}
+	if nheight, nleaves = treeShape(bt); nheight != 1 || nleaves != 1 {
+		t.Fatal(nheight, nleaves)
+	}
+	for i := lim - 1; i >= 0; i-- {
+		tree.Insert(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i))
+	}
+	for i := 0; i < lim; i++ {
+		uuids, _ := tree.Search(utils.UUIDToKey(utils.UUID(i)))
+		if len(uuids) != 1 || uuids[0] != utils.UUID(i) {
+			t.Fatal(i, uuids)
+		}
+	}
+}
+
+func TestTreeMergeConcurrent(t *testing.T) {
+	tm := tm.CreateMock("/tmp/TestTreeMergeConcurrent")
+	dm := dm.Create("/tmp/TestTreeMergeConcurrent", pcacher.PAGE_SIZE*80, tm)
+	root, _ := Create(dm)
+	tree, _ := Load(root, dm)
+
+	// [0, lim)中除了10的倍数都会被删除, [lim, 2*lim)中的奇数会被插入
+	lim := 10000
+	for i := 0; i < lim; i++ {
+		tree.Insert(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i))
+	}
+
+	noWorker := 8
+	noReader := 4
+	wg := sync.WaitGroup{}
+	wg.Add(noWorker * 2)
+	done := make(chan struct{})
+	errs := make(chan error, noWorker*2+noReader)
+
+	for w := 0; w < noWorker; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < lim; i += noWorker {
+				if i%10 == 0 {
+					continue
+				}
+				ok, err := tree.Delete(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i))
+				if err != nil || ok == false {
+					errs <- fmt.Errorf("delete %d: %v", i, err)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := lim + 2*w + 1; i < 2*lim; i += 2 * noWorker {
+				err := tree.Insert(utils.UUIDToKey(utils.UUID(i)), utils.UUID(i))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(noReader)
+	for r := 0; r < noReader; r++ {
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// 不会被删除的key总能被查到, 且遍历的结果有序, 没有重复
+				i := rand.Intn(lim/10) * 10
+				uuids, err := tree.Search(utils.UUIDToKey(utils.UUID(i)))
+				if err != nil || len(uuids) != 1 || uuids[0] != utils.UUID(i) {
+					errs <- fmt.Errorf("search %d: %v %v", i, uuids, err)
+					return
+				}
+				uuids, err = tree.SearchRange(nil, nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for j := 1; j < len(uuids); j++ {
+					if uuids[j] <= uuids[j-1] {
+						errs <- fmt.Errorf("range: %d after %d", uuids[j], uuids[j-1])
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	uuids, _ := tree.SearchRange(nil, nil)
+	var expected []utils.UUID
+	for i := 0; i < 2*lim; i++ {
+		if (i < lim && i%10 == 0) || (i >= lim && i%2 == 1) {
+			expected = append(expected, utils.UUID(i))
+		}
+	}
+	if len(uuids) != len(expected) {
+		t.Fatal(len(uuids), len(expected))
+	}
+	for i := range uuids {
+		if uuids[i] != expected[i] {
+			t.Fatal(i, uuids[i], expected[i])
+		}
+	}
+	// 父节点被合并时, 分裂产生的节点会被插入新的父节点, 而不是只能通过兄弟节点找到
+	checkPosted(t, tree.(*bPlusTree))
+}
+
+// checkPosted 检查每一层的每个节点都被上一层的某个节点指向, 即没有被丢失的子树, 且根节点没有兄弟节点.
+func checkPosted(t *testing.T, bt *bPlusTree) {
+	level := []utils.UUID{bt.rootUUID()}
+	root, _ := loadNode(bt, level[0])
+	if sibling := root.Sibling(); sibling != utils.NilUUID {
+		t.Fatal("Root has sibling ", sibling)
+	}
+	root.Release()
+	for {
+		sons := make(map[utils.UUID]bool)
+		var first utils.UUID
+		for _, nodeUUID := range level {
+			node, _ := loadNode(bt, nodeUUID)
+			isLeaf := getRawIsLeaf(node.raw)
+			for _, p := range getRawPairs(node.raw) {
+				sons[p.son] = true
+			}
+			if isLeaf == false && first == utils.NilUUID {
+				first = getRawPairs(node.raw)[0].son
+			}
+			node.Release()
+			if isLeaf {
+				return
+			}
+		}
+
+		level = nil
+		for nodeUUID := first; nodeUUID != utils.NilUUID; {
+			if sons[nodeUUID] == false {
+				t.Fatal("Node ", nodeUUID, " is not posted")
+			}
+			level = append(level, nodeUUID)
+			node, _ := loadNode(bt, nodeUUID)
+			nodeUUID = getRawSibling(node.raw)
+			node.Release()
+		}
+	}
+}
+
+func TestTreeConcurrentRootSplit(t *testing.T) {
+	tm := tm.CreateMock("/tmp/TestTreeConcurrentRootSplit")
+	dm := dm.Create("/tmp/TestTreeConcurrentRootSplit", pcacher.PAGE_SIZE*80, tm)
+
+	// 在很小的树上并发插入, 使得根节点经常同时被多个插入者分裂.
+	// 每次插入都会刷新脏页, 所以轮数不宜过多, 以便能用-race -count多次运行.
+	noRound := 5
+	noInsertor := 50
+	noTasks := 40
+	for r := 0; r < noRound; r++ {
+		root, _ := Create(dm)
+		tree, _ := Load(root, dm)
+
+		wg := sync.WaitGroup{}
+		wg.Add(noInsertor)
+		errs := make(chan error, noInsertor)
+		for i := 0; i < noInsertor; i++ {
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < noTasks; j++ {
+					uid := utils.UUID(j*noInsertor + i)
+					err := tree.Insert(utils.UUIDToKey(uid), uid)
+					if err != nil {
+						errs <- fmt.Errorf("insert %d: %v", uid, err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(r, err)
+		}
+
+		checkPosted(t, tree.(*bPlusTree))
+		for i := 0; i < noInsertor*noTasks; i++ {
+			uuids, _ := tree.Search(utils.UUIDToKey(utils.UUID(i)))
+			if len(uuids) != 1 || uuids[0] != utils.UUID(i) {
+				t.Fatal(r, i, uuids)
+			}
+		}
+		tree.(*bPlusTree).Close()
+	}
+}
@@ -1,11 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"testing"
 
-	"nyadb/backend/parser/statement"
+	"nyadb2/backend/parser/statement"
 )
 
 func TestCreate(t *testing.T) {
@@ -114,3 +115,780 @@ func TestUpdate(t *testing.T) {
 	fmt.Println()
 	fmt.Println("=update==========================")
 }
+
+func TestWhere(t *testing.T) {
+	stat := `
+		read * from student where not (id >= 1 and name != "ZYJ") or age <= 3
+	`
+	result, err := Parse([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := result.(*statement.Read)
+	or, ok := read.Where.Exp.(*statement.LogicExp)
+	if ok == false || or.LogicOp != "or" {
+		t.Fatal("Error")
+	}
+	not, ok := or.Exp1.(*statement.NotExp)
+	if ok == false {
+		t.Fatal("Error")
+	}
+	and, ok := not.Exp.(*statement.LogicExp)
+	if ok == false || and.LogicOp != "and" {
+		t.Fatal("Error")
+	}
+	exp := and.Exp2.(*statement.SingleExp)
+	if exp.Field != "name" || exp.CmpOp != "!=" || exp.Value != "ZYJ" {
+		t.Fatal("Error")
+	}
+	exp = or.Exp2.(*statement.SingleExp)
+	if exp.Field != "age" || exp.CmpOp != "<=" || exp.Value != "3" {
+		t.Fatal("Error")
+	}
+
+	_, err = Parse([]byte("read * from student where (id > 1"))
+	if err == nil {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("read * from student where age > id + 1 or ok = true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	or = result.(*statement.Read).Where.Exp.(*statement.LogicExp)
+	cmp, ok := or.Exp1.(*statement.CmpExp)
+	if ok == false || cmp.Exp1.(*statement.FieldExp).Field != "age" || cmp.CmpOp != ">" {
+		t.Fatal("Error")
+	}
+	if arith, ok := cmp.Exp2.(*statement.ArithExp); ok == false || arith.Exp1.(*statement.FieldExp).Field != "id" {
+		t.Fatal("Error")
+	}
+	if exp = or.Exp2.(*statement.SingleExp); exp.Field != "ok" || exp.Value != "true" {
+		t.Fatal("Error")
+	}
+}
+
+func TestUpdateSets(t *testing.T) {
+	stat := `
+		update student set age = (age + 1) * 2, name = upper(name) + "x" where id = 5
+	`
+	result, err := Parse([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := result.(*statement.Update)
+	if len(update.Sets) != 2 || update.Sets[0].FieldName != "age" || update.Sets[1].FieldName != "name" {
+		t.Fatal("Error")
+	}
+	mul, ok := update.Sets[0].Value.(*statement.ArithExp)
+	if ok == false || mul.ArithOp != "*" {
+		t.Fatal("Error")
+	}
+	add, ok := mul.Exp1.(*statement.ArithExp)
+	if ok == false || add.ArithOp != "+" {
+		t.Fatal("Error")
+	}
+	if f, ok := add.Exp1.(*statement.FieldExp); ok == false || f.Field != "age" {
+		t.Fatal("Error")
+	}
+	if l, ok := add.Exp2.(*statement.LiteralExp); ok == false || l.Value != "1" {
+		t.Fatal("Error")
+	}
+	concat := update.Sets[1].Value.(*statement.ArithExp)
+	if fn, ok := concat.Exp1.(*statement.FuncExp); ok == false || fn.FuncName != "upper" || len(fn.Args) != 1 {
+		t.Fatal("Error")
+	}
+	if l, ok := concat.Exp2.(*statement.LiteralExp); ok == false || l.Value != "x" {
+		t.Fatal("Error")
+	}
+	if update.Where == nil {
+		t.Fatal("Error")
+	}
+
+	_, err = Parse([]byte("update student set age = age +"))
+	if err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func TestInsertTuples(t *testing.T) {
+	stat := `
+		insert into student (name, id) values ("Zhang Yuanjia", 5), ('', 6)
+	`
+	result, err := Parse([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := result.(*statement.Insert)
+	if len(insert.Fields) != 2 || insert.Fields[0] != "name" || insert.Fields[1] != "id" {
+		t.Fatal("Error")
+	}
+	if len(insert.Values) != 2 || *insert.Values[0][0] != "Zhang Yuanjia" || *insert.Values[0][1] != "5" ||
+		*insert.Values[1][0] != "" || *insert.Values[1][1] != "6" {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte(`insert into student values 5 "Zhang Yuanjia" ''`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert = result.(*statement.Insert)
+	if insert.Fields != nil || len(insert.Values) != 1 || len(insert.Values[0]) != 3 {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"insert into student (name, id values (1, 2)",
+		"insert into student values (1, 2",
+		"insert into student values (1, 2) (3, 4)",
+		"insert into student values ()",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestReadOrderBy(t *testing.T) {
+	stat := `
+		read * from student where age > 10 order by age desc, id asc, name limit 10 offset 20
+	`
+	result, err := Parse([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := result.(*statement.Read)
+	if read.Where == nil || len(read.OrderBy) != 3 || read.Limit != 10 || read.Offset != 20 {
+		t.Fatal("Error")
+	}
+	if read.OrderBy[0].Field != "age" || read.OrderBy[0].Desc == false ||
+		read.OrderBy[1].Field != "id" || read.OrderBy[1].Desc ||
+		read.OrderBy[2].Field != "name" || read.OrderBy[2].Desc {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("read * from student offset 5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read = result.(*statement.Read)
+	if read.Where != nil || read.OrderBy != nil || read.Limit != -1 || read.Offset != 5 {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"read * from student order id",
+		"read * from student order by",
+		"read * from student limit -1",
+		"read * from student limit x",
+		"read * from student offset 1 limit 1",
+		"read * from student where id = 1 id = 2",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestReadAggregate(t *testing.T) {
+	stat := `
+		read age, count(*), avg(score + 1) from student where id > 1 group by age, name having count(*) > 1 and not max(score) < 60 order by age
+	`
+	result, err := Parse([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := result.(*statement.Read)
+	if len(read.Fields) != 3 || len(read.GroupBy) != 2 || read.Having == nil || len(read.OrderBy) != 1 {
+		t.Fatal("Error")
+	}
+	if f, ok := read.Fields[0].(*statement.FieldExp); ok == false || f.Field != "age" {
+		t.Fatal("Error")
+	}
+	count, ok := read.Fields[1].(*statement.FuncExp)
+	if ok == false || count.FuncName != "count" || len(count.Args) != 1 {
+		t.Fatal("Error")
+	}
+	if _, ok := count.Args[0].(*statement.StarExp); ok == false {
+		t.Fatal("Error")
+	}
+	and, ok := read.Having.Exp.(*statement.LogicExp)
+	if ok == false || and.LogicOp != "and" {
+		t.Fatal("Error")
+	}
+	cmp, ok := and.Exp1.(*statement.CmpExp)
+	if ok == false || cmp.CmpOp != ">" {
+		t.Fatal("Error")
+	}
+	if _, ok := and.Exp2.(*statement.NotExp); ok == false {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("read * from student"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.(*statement.Read).Fields != nil {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"read count(*, id) from student",
+		"read id from student group by",
+		"read id from student having",
+		"read id, from student",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestReadJoin(t *testing.T) {
+	stat := `
+		read student.name, class.name from student join class on student.class = class.id left join teacher on teacher.id = class.teacher where student.id > 1
+	`
+	result, err := Parse([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := result.(*statement.Read)
+	if read.TableName != "student" || len(read.Joins) != 2 || read.Where == nil {
+		t.Fatal("Error")
+	}
+	if read.Joins[0].TableName != "class" || read.Joins[0].Left ||
+		read.Joins[0].Field1 != "student.class" || read.Joins[0].Field2 != "class.id" {
+		t.Fatal("Error")
+	}
+	if read.Joins[1].TableName != "teacher" || read.Joins[1].Left == false ||
+		read.Joins[1].Field1 != "teacher.id" || read.Joins[1].Field2 != "class.teacher" {
+		t.Fatal("Error")
+	}
+	if f, ok := read.Fields[0].(*statement.FieldExp); ok == false || f.Field != "student.name" {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("read * from a inner join b on x = y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read = result.(*statement.Read); len(read.Joins) != 1 || read.Joins[0].Left {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"read * from a join b",
+		"read * from a join b on x",
+		"read * from a join b on x > y",
+		"read * from a left b on x = y",
+		"read * from a join on x = y",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestParsePrepared(t *testing.T) {
+	stat, params, err := ParsePrepared([]byte("insert into student values (?, 'a?'), (3, ?)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 2 {
+		t.Fatal("Error")
+	}
+	params[0].Set("1", "")
+	params[1].Set("it's", "")
+	insert := stat.(*statement.Insert)
+	if *insert.Values[0][0] != "1" || *insert.Values[0][1] != "a?" || *insert.Values[1][1] != "it's" {
+		t.Fatal("Error")
+	}
+	if params[1].SetNull() != nil || insert.Values[1][1] != nil || *insert.Values[1][0] != "3" {
+		t.Fatal("Error")
+	}
+	params[1].Set("b", statement.KindString)
+	if *insert.Values[1][1] != "b" || insert.Kinds[1][1] != statement.KindString || insert.Kinds[1][0] != "" {
+		t.Fatal("Error")
+	}
+
+	stat, params, err = ParsePrepared([]byte("update student set age = ? + 1 where id = ? or name = ?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 3 {
+		t.Fatal("Error")
+	}
+	params[0].Set("20", "")
+	params[1].Set("5", "")
+	params[2].Set("tom", statement.KindString)
+	update := stat.(*statement.Update)
+	arith := update.Sets[0].Value.(*statement.ArithExp)
+	if *arith.Exp1.(*statement.ParamExp).Value != "20" {
+		t.Fatal("Error")
+	}
+	if params[0].SetNull() != nil || arith.Exp1.(*statement.ParamExp).Value != nil {
+		t.Fatal("Error")
+	}
+	if params[1].SetNull() != ErrNullParam { // where中的比较不能与NULL进行
+		t.Fatal("Error")
+	}
+	or := update.Where.Exp.(*statement.LogicExp)
+	if or.Exp1.(*statement.SingleExp).Value != "5" || or.Exp2.(*statement.SingleExp).Value != "tom" {
+		t.Fatal("Error")
+	}
+	if or.Exp1.(*statement.SingleExp).Kind != "" || or.Exp2.(*statement.SingleExp).Kind != statement.KindString {
+		t.Fatal("Error")
+	}
+
+	stat, params, err = ParsePrepared([]byte("read * from student where age > id + ?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 1 || params[0].SetNull() != ErrNullParam {
+		t.Fatal("Error")
+	}
+
+	if _, err = Parse([]byte("read * from student where id = ?")); err == nil {
+		t.Fatal("Error")
+	}
+	if _, _, err = ParsePrepared([]byte("read * from student where ? = 1")); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	result, err := Parse([]byte("explain read * from student where id > 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	explain := result.(*statement.Explain)
+	if read, ok := explain.Stat.(*statement.Read); ok == false || read.TableName != "student" || read.Where == nil {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("explain delete from student where id = 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.(*statement.Explain).Stat.(*statement.Delete); ok == false {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"explain",
+		"explain insert into student values 1",
+		"explain explain read * from student",
+		"explain show",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestNegative(t *testing.T) {
+	result, err := Parse([]byte("insert into t values (-1, -2.5, 3), (4, -0, x)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := result.(*statement.Insert)
+	if *insert.Values[0][0] != "-1" || *insert.Values[0][1] != "-2.5" || *insert.Values[1][1] != "-0" {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("read * from t where amount > -5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.(*statement.Read).Where.Exp.(*statement.SingleExp).Value != "-5" {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("update t set amount = -amount * -2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mul := result.(*statement.Update).Sets[0].Value.(*statement.ArithExp)
+	neg, ok := mul.Exp1.(*statement.ArithExp)
+	if ok == false || neg.ArithOp != "-" || neg.Exp1.(*statement.LiteralExp).Value != "0" ||
+		neg.Exp2.(*statement.FieldExp).Field != "amount" {
+		t.Fatal("Error")
+	}
+	if mul.Exp2.(*statement.LiteralExp).Value != "-2" {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"read * from t where amount > -",
+		"insert into t values (-, 1)",
+		"insert into t values (-'1')",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	result, err := Parse([]byte("READ Name, COUNT(*) FROM Student WHERE Age > 10 Group By Name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := result.(*statement.Read)
+	if read.TableName != "Student" || read.Fields[0].(*statement.FieldExp).Field != "Name" ||
+		read.Fields[1].(*statement.FuncExp).FuncName != "count" || read.GroupBy[0] != "Name" {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("insert into student values (1, 'Zhang \\'Yuanjia\\'') -- 注释"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *result.(*statement.Insert).Values[0][1] != "Zhang 'Yuanjia'" {
+		t.Fatal("Error")
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	cases := []struct {
+		stat   string
+		line   int
+		column int
+		token  string
+		err    error
+	}{
+		{"read * form student", 1, 8, "form", ErrInvalidStat},
+		{"read *\nfrom student\n  wher id = 1", 3, 3, "wher", ErrInvalidStat},
+		{"insert into 学生 values 1", 1, 13, "学", ErrInvalidStat},
+		{"read * from student where", 1, 26, "", ErrInvalidStat},
+		{"read * from student where name = 'abc", 1, 34, "'abc", ErrUnclosedQuote},
+		{"read * from student /* abc", 1, 21, "/* abc", ErrUnclosedComment},
+		{"read * from student where id = ?", 1, 32, "?", ErrInvalidStat},
+		{"hello", 1, 1, "hello", ErrInvalidStat},
+		{"create table t a uint32 (index", 1, 31, "", ErrInvalidStat},
+		{"create table t a uint32 (index a", 1, 33, "", ErrInvalidStat},
+		{"create table t a uint32, b", 1, 27, "", ErrInvalidStat},
+		{"insert into t (a, b", 1, 20, "", ErrInvalidStat},
+		{"delete from t where name =", 1, 27, "", ErrInvalidStat},
+		{"read * from t where (a = )", 1, 26, ")", ErrInvalidStat},
+		{"read * from t where a = and b = 1", 1, 25, "and", ErrInvalidStat},
+		{"read * from t where a = * or b = 1", 1, 25, "*", ErrInvalidStat},
+	}
+	for _, c := range cases {
+		_, err := Parse([]byte(c.stat))
+		var serr *SyntaxError
+		if errors.As(err, &serr) == false {
+			t.Fatal(c.stat, err)
+		}
+		if serr.Line != c.line || serr.Column != c.column || serr.Token != c.token || serr.Err != c.err {
+			t.Fatal(c.stat, serr)
+		}
+	}
+
+	_, err := Parse([]byte("read * form student"))
+	if err.Error() != `Invalid command at line 1, column 8, near "form".` || errors.Is(err, ErrInvalidStat) == false {
+		t.Fatal(err)
+	}
+}
+
+// 关键字是保留字, 不区分大小写, 不能作为表名或字段名; 函数名和包含关键字的名字依然可以使用.
+func TestReservedWords(t *testing.T) {
+	reserved := []string{
+		"create table key id int32",
+		"create table t level int32",
+		"create table t Key int32",
+		"create table t a int32 (index check)",
+		"create table t a int32, default int32",
+		"alter table t add add int32",
+		"alter table t drop column",
+		"read offset from t",
+		"read a from t where limit = 1",
+		"read a from t order by group",
+		"read a + order from t",
+		"update t set default = 1",
+		"update t set a = key + 1",
+		"insert into t (a, string) values (1, 2)",
+		"create index on t(column)",
+		"delete from t where Level = 1",
+	}
+	for _, stat := range reserved {
+		_, err := Parse([]byte(stat))
+		if errors.Is(err, ErrInvalidStat) == false {
+			t.Fatal(stat, err)
+		}
+	}
+
+	legal := []string{
+		"create table count id int32, now int64, upper string, keys int32, Levels int32, type int32 (index keys)",
+		"read count, keys, Levels from t where type = 1 order by now",
+		"read count(count) from t group by upper",
+		"update t set keys = keys + 1, upper = upper(upper) where count = 1",
+		"alter table t add offsets int32",
+		"insert into t (count, now) values (1, 2)",
+		"delete from t where upper = 'and' or upper = ''", // 带引号的保留字和空字符串可以作为值
+	}
+	for _, stat := range legal {
+		if _, err := Parse([]byte(stat)); err != nil {
+			t.Fatal(stat, err)
+		}
+	}
+}
+
+func TestNull(t *testing.T) {
+	result, err := Parse([]byte("create table t id int32 not null, name string, age int32 NOT NULL (index id)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := result.(*statement.Create)
+	if len(create.NotNull) != 3 || create.NotNull[0] == false || create.NotNull[1] || create.NotNull[2] == false {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("insert into t values (1, null, 'null'), (2, NULL, -3)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := result.(*statement.Insert)
+	if insert.Values[0][1] != nil || *insert.Values[0][2] != "null" || insert.Values[1][1] != nil {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("read * from t where name is null or not age is not null"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	or := result.(*statement.Read).Where.Exp.(*statement.LogicExp)
+	isNull := or.Exp1.(*statement.IsNullExp)
+	isNotNull := or.Exp2.(*statement.NotExp).Exp.(*statement.IsNullExp)
+	if isNull.Field != "name" || isNull.Not || isNotNull.Field != "age" || isNotNull.Not == false {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("update t set name = null"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.(*statement.Update).Sets[0].Value.(*statement.NullExp); ok == false {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"read * from t where name = null",
+		"read * from t where name is 'null'",
+		"read * from t where name is not",
+		"create table t id int32 not (index id)",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestDefault(t *testing.T) {
+	result, err := Parse([]byte("create table t id int64 not null default seq(), name string default 'it''s', " +
+		"age int32 default -1 not null, note string default null, created int64 default NOW() (index id)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := result.(*statement.Create)
+	if len(create.Default) != 5 || create.Default[0].Func != "seq" || *create.Default[1].Value != "it's" ||
+		*create.Default[2].Value != "-1" || create.NotNull[2] == false ||
+		create.Default[3].Func != "" || create.Default[3].Value != nil || create.Default[4].Func != "now" {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("create table t id int64, name string default 'x'"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if create = result.(*statement.Create); create.Default[0] != nil || *create.Default[1].Value != "x" {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"create table t id int64 default",
+		"create table t id int64 default seq(",
+		"create table t id int64 default 1 default 2",
+		"create table t id int64 default , name string",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	result, err := Parse([]byte("create table t id int32 primary key default seq(), email string not null unique, name string"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := result.(*statement.Create)
+	if create.PrimaryKey != "id" || create.Unique[0] != false || create.Unique[1] != true ||
+		create.NotNull[1] != true || create.Unique[2] != false || create.Default[0].Func != "seq" {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"create table t id int32 primary key, uid int32 primary key",
+		"create table t id int32 primary",
+		"create table t id int32 unique unique",
+		"create table t id int32 primary key primary key",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	result, err := Parse([]byte("create table t id int32 primary key, parent int32 references t(id) on delete cascade, " +
+		"cid int32 not null references c(id) on update cascade on delete set null, note string"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := result.(*statement.Create)
+	r1, r2 := create.References[1], create.References[2]
+	if create.References[0] != nil || create.References[3] != nil ||
+		r1.TableName != "t" || r1.FieldName != "id" || r1.OnDelete != "cascade" || r1.OnUpdate != "restrict" ||
+		r2.TableName != "c" || r2.OnDelete != "set null" || r2.OnUpdate != "cascade" || create.NotNull[2] == false {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"create table t cid int32 references c",
+		"create table t cid int32 references c(id",
+		"create table t cid int32 references (id)",
+		"create table t cid int32 references c(id) on delete",
+		"create table t cid int32 references c(id) on delete set",
+		"create table t cid int32 references c(id) on delete nothing",
+		"create table t cid int32 references c(id) on delete cascade on delete restrict",
+		"create table t cid int32 references c(id) references c(id)",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	stat := "create table t qty int32 check (qty >= 0) check (qty < 100), " +
+		"constraint pricerange check ( price * qty <= 1000 or not (name is null) ), price float64, name string"
+	result, err := Parse([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := result.(*statement.Create)
+	if len(create.Checks) != 3 || len(create.FieldName) != 3 ||
+		create.Checks[0].Field != "qty" || create.Checks[0].Name != "" || create.Checks[0].Text != "qty >= 0" ||
+		create.Checks[2].Field != "" || create.Checks[2].Name != "pricerange" ||
+		create.Checks[2].Text != "price * qty <= 1000 or not (name is null)" {
+		t.Fatal("Error")
+	}
+	if _, ok := create.Checks[1].Exp.(*statement.CmpExp); ok == false {
+		t.Fatal("Error")
+	}
+
+	exp, err := ParseCheck([]byte(create.Checks[2].Text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logic, ok := exp.(*statement.LogicExp); ok == false || logic.LogicOp != "or" {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"create table t qty int32 check qty > 0",
+		"create table t qty int32 check (qty > 0",
+		"create table t qty int32 check (qty)",
+		"create table t qty int32 check (qty + 1 is null)",
+		"create table t qty int32 constraint check (qty > 0)",
+		"create table t qty int32, constraint c",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+	if _, _, err = ParsePrepared([]byte("create table t qty int32 check (qty > ?)")); err == nil {
+		t.Fatal("Error")
+	}
+	if _, err = ParseCheck([]byte("qty > 0 )")); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func TestAlter(t *testing.T) {
+	result, err := Parse([]byte("alter table t add column grade int32 not null default 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alter := result.(*statement.Alter)
+	add := alter.Add
+	if alter.TableName != "t" || alter.Drop != "" || add == nil || len(add.FieldName) != 1 ||
+		add.FieldName[0] != "grade" || add.FieldType[0] != "int32" || add.NotNull[0] == false ||
+		add.Default[0] == nil || *add.Default[0].Value != "1" {
+		t.Fatal("Error")
+	}
+
+	result, err = Parse([]byte("ALTER TABLE t ADD name string"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alter = result.(*statement.Alter); alter.Add == nil || alter.Add.FieldName[0] != "name" {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{"alter table t drop column age", "alter table t drop age"} {
+		result, err = Parse([]byte(stat))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alter = result.(*statement.Alter); alter.Add != nil || alter.Drop != "age" {
+			t.Fatal(stat)
+		}
+	}
+
+	for _, stat := range []string{
+		"alter t drop age",
+		"alter table t",
+		"alter table t drop",
+		"alter table t drop age, name",
+		"alter table t add grade",
+		"alter table t add grade int32, name string",
+		"alter table t rename age",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	result, err := Parse([]byte("create index on t(name)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if create, ok := result.(*statement.CreateIndex); ok == false || create.TableName != "t" || create.FieldName != "name" {
+		t.Fatal("Error")
+	}
+	result, err = Parse([]byte("DROP INDEX ON t ( name )"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drop, ok := result.(*statement.DropIndex); ok == false || drop.TableName != "t" || drop.FieldName != "name" {
+		t.Fatal("Error")
+	}
+
+	for _, stat := range []string{
+		"create index t(name)",
+		"create index on t",
+		"create index on t(name",
+		"create index on t(name, age)",
+		"create index on t(name) x",
+		"drop index on (name)",
+		"drop index on t('name')",
+	} {
+		if _, err = Parse([]byte(stat)); err == nil {
+			t.Fatal(stat)
+		}
+	}
+}
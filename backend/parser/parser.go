@@ -6,20 +6,113 @@ package parser
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"nyadb2/backend/parser/statement"
 )
 
 var (
 	ErrInvalidStat = errors.New("Invalid command.")
-	ErrHasNoIndex  = errors.New("Table has no index.")
+	ErrNullParam   = errors.New("Parameter cannot be null.")
 )
 
+// SyntaxError 为解析语句时的错误, 记录了出错的位置和token.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Token  string // 为空表示语句意外结束
+	Err    error
+}
+
+func (e *SyntaxError) Error() string {
+	near := "at end of command"
+	if e.Token != "" {
+		token := []rune(e.Token)
+		if len(token) > 20 {
+			token = append(token[:20], []rune("...")...)
+		}
+		near = "near " + strconv.Quote(string(token))
+	}
+	return fmt.Sprintf("%s at line %d, column %d, %s.",
+		strings.TrimSuffix(e.Err.Error(), "."), e.Line, e.Column, near)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// Parse 解析一条语句, 语句中不能含有占位符.
 func Parse(statement []byte) (interface{}, error) {
-	tokener := newTokener(statement)
+	stat, _, err := parse(statement, false)
+	return stat, err
+}
+
+/*
+	ParsePrepared 解析一条可能含有占位符?的语句, 返回解析出的语句, 以及按照出现的顺序排列的各个占位符.
+	占位符只能出现在值的位置上. 执行之前, 调用者需要通过Param为每个占位符赋值,
+	赋的值不会再被token化, 所以不需要引号或转义.
+	同一条语句可以被反复赋值并执行.
+*/
+func ParsePrepared(statement []byte) (interface{}, []*Param, error) {
+	return parse(statement, true)
+}
+
+/*
+	Param 为预编译语句中的一个占位符?.
+	insert中的值和值表达式中的占位符可以被赋值为NULL; where中的比较不能与NULL进行(需要使用is null),
+	所以其中的占位符不能被赋值为NULL.
+	赋值时还可以给出值的类型, 由TBM检查其与对应字段的类型是否相符, 见statement.ParamExp.
+*/
+type Param struct {
+	value *string  // 指向该占位符在语句中对应的值
+	slot  **string // 可以为NULL时, 指向语句中引用value的指针, 为nil表示不能为NULL
+	kind  *string  // 指向语句中记录该值的类型的位置
+}
+
+// Set 将该占位符赋值为v, kind为v的类型, 如statement.KindInt, 为空表示不指定类型, 与字面值相同.
+func (p *Param) Set(v string, kind string) {
+	*p.value = v
+	if p.slot != nil {
+		*p.slot = p.value
+	}
+	if p.kind != nil {
+		*p.kind = kind
+	}
+}
+
+// SetNull 将该占位符赋值为NULL, 不能为NULL时返回ErrNullParam.
+func (p *Param) SetNull() error {
+	if p.slot == nil {
+		return ErrNullParam
+	}
+	*p.slot = nil
+	return nil
+}
+
+// ParseCheck 解析check约束的原文, 即statement.Check中的Text, 用于TBM从持久化的原文恢复出表达式.
+func ParseCheck(text []byte) (statement.Exp, error) {
+	tokener := newTokener(text, false)
+	exp, err := parseOrExp(tokener, parseCheckLeaf)
+	if err == nil {
+		var next string
+		if next, err = tokener.Peek(); err == nil && next != "" {
+			err = ErrInvalidStat
+		}
+	}
+	if err != nil {
+		return nil, tokener.errorAt(err)
+	}
+	return exp, nil
+}
+
+// parse 解析一条语句, 出错时返回带有位置的*SyntaxError.
+func parse(statement []byte, prepared bool) (interface{}, []*Param, error) {
+	tokener := newTokener(statement, prepared)
 	token, err := tokener.Peek()
 	if err != nil {
-		return nil, err
+		return nil, nil, tokener.errorAt(err)
 	}
 	tokener.Pop()
 
@@ -34,9 +127,19 @@ func Parse(statement []byte) (interface{}, error) {
 	case "abort":
 		stat, staterr = parseAbort(tokener)
 	case "create":
-		stat, staterr = parseCreate(tokener)
+		if isIndex(tokener) {
+			stat, staterr = parseCreateIndex(tokener)
+		} else {
+			stat, staterr = parseCreate(tokener)
+		}
 	case "drop":
-		stat, staterr = parseDrop(tokener)
+		if isIndex(tokener) {
+			stat, staterr = parseDropIndex(tokener)
+		} else {
+			stat, staterr = parseDrop(tokener)
+		}
+	case "alter":
+		stat, staterr = parseAlter(tokener)
 	case "read":
 		stat, staterr = parseRead(tokener)
 	case "insert":
@@ -47,17 +150,25 @@ func Parse(statement []byte) (interface{}, error) {
 		stat, staterr = parseUpdate(tokener)
 	case "show":
 		stat, staterr = parseShow(tokener)
+	case "explain":
+		stat, staterr = parseExplain(tokener)
 	default:
-		return nil, ErrInvalidStat
+		return nil, nil, tokener.errorAt(ErrInvalidStat)
 	}
 
-	next, err := tokener.Peek()
-	if err == nil && next != "" {
-		errStat := tokener.ErrStat()
-		staterr = errors.New("Invalid Stat: " + string(errStat))
+	if staterr == nil {
+		next, err := tokener.Peek()
+		if err != nil {
+			staterr = err
+		} else if next != "" {
+			staterr = ErrInvalidStat
+		}
+	}
+	if staterr != nil {
+		return nil, nil, tokener.errorAt(staterr)
 	}
 
-	return stat, staterr
+	return stat, tokener.params, nil
 }
 
 func parseShow(tokener *tokener) (*statement.Show, error) {
@@ -72,6 +183,30 @@ func parseShow(tokener *tokener) (*statement.Show, error) {
 	}
 }
 
+func parseExplain(tokener *tokener) (*statement.Explain, error) {
+	token, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	tokener.Pop()
+
+	explain := new(statement.Explain)
+	switch token {
+	case "read":
+		explain.Stat, err = parseRead(tokener)
+	case "update":
+		explain.Stat, err = parseUpdate(tokener)
+	case "delete":
+		explain.Stat, err = parseDelete(tokener)
+	default:
+		return nil, ErrInvalidStat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return explain, nil
+}
+
 func parseUpdate(tokener *tokener) (*statement.Update, error) {
 	var err error
 	update := new(statement.Update)
@@ -90,10 +225,50 @@ func parseUpdate(tokener *tokener) (*statement.Update, error) {
 	}
 	tokener.Pop()
 
-	update.FieldName, err = tokener.Peek()
+	for { // parse set list
+		set, err := parseSet(tokener)
+		if err != nil {
+			return nil, err
+		}
+		update.Sets = append(update.Sets, set)
+
+		comma, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if comma != "," {
+			break
+		}
+		tokener.Pop() // pop ,
+	}
+
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "" { // no where statement
+		update.Where = nil
+		return update, nil
+	}
+
+	where, err := parseWhere(tokener) // parse where statement
+	if err != nil {
+		return nil, err
+	}
+	update.Where = where
+	return update, nil
+}
+
+func parseSet(tokener *tokener) (*statement.Set, error) {
+	set := new(statement.Set)
+	fieldName, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
+	if isName(fieldName) == false {
+		return nil, ErrInvalidStat
+	}
+	set.FieldName = fieldName
 	tokener.Pop()
 
 	tmp, err := tokener.Peek()
@@ -105,27 +280,183 @@ func parseUpdate(tokener *tokener) (*statement.Update, error) {
 	}
 	tokener.Pop()
 
-	update.Value, err = tokener.Peek()
+	set.Value, err = parseValueExp(tokener)
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+/*
+	值表达式的文法如下, 优先级从低到高依次为+ -, * /:
+	<value exp>  <term> [(+|-) <term>]*
+	<term>       <factor> [(*|/) <factor>]*
+	<factor>     (<value exp>) | <func name>(<value exp list>) | <field name> | <value>
+
+	以字母开头, 且没有被引号括起来的token被当做字段名, 其他的token都被当做字面值.
+*/
+func parseValueExp(tokener *tokener) (statement.ValueExp, error) {
+	exp, err := parseTerm(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if op != "+" && op != "-" {
+			return exp, nil
+		}
+		tokener.Pop() // pop op
+
+		exp2, err := parseTerm(tokener)
+		if err != nil {
+			return nil, err
+		}
+		exp = &statement.ArithExp{ArithOp: op, Exp1: exp, Exp2: exp2}
+	}
+}
+
+func parseTerm(tokener *tokener) (statement.ValueExp, error) {
+	exp, err := parseFactor(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if op != "*" && op != "/" {
+			return exp, nil
+		}
+		tokener.Pop() // pop op
+
+		exp2, err := parseFactor(tokener)
+		if err != nil {
+			return nil, err
+		}
+		exp = &statement.ArithExp{ArithOp: op, Exp1: exp, Exp2: exp2}
+	}
+}
+
+func parseFactor(tokener *tokener) (statement.ValueExp, error) {
+	token, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
+	if tokener.IsQuoted() {
+		tokener.Pop()
+		return &statement.LiteralExp{Value: token}, nil
+	}
+	if tokener.IsParam() {
+		tokener.Pop()
+		param := &statement.ParamExp{Value: new(string)}
+		p := tokener.addParam(param.Value)
+		p.slot, p.kind = &param.Value, &param.Kind
+		return param, nil
+	}
+	if token == "-" { // 负号
+		tokener.Pop()
+		num, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if tokener.IsQuoted() == false && num != "" && isDigital(num[0]) {
+			tokener.Pop()
+			return &statement.LiteralExp{Value: "-" + num}, nil
+		}
+		exp, err := parseFactor(tokener) // -x 等价于 0 - x
+		if err != nil {
+			return nil, err
+		}
+		return &statement.ArithExp{ArithOp: "-", Exp1: &statement.LiteralExp{Value: "0"}, Exp2: exp}, nil
+	}
+	if token == "" || isSymbol(token[0]) && token != "(" {
+		return nil, ErrInvalidStat
+	}
+	if token == "null" {
+		tokener.Pop()
+		return &statement.NullExp{}, nil
+	}
+	if token == "true" || token == "false" { // bool的字面值
+		tokener.Pop()
+		return &statement.LiteralExp{Value: token}, nil
+	}
+	if keywords[token] { // 保留字不能作为字段名, 见isName
+		return nil, ErrInvalidStat
+	}
 	tokener.Pop()
 
-	tmp, err = tokener.Peek()
+	if token == "(" {
+		exp, err := parseValueExp(tokener)
+		if err != nil {
+			return nil, err
+		}
+		rparen, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if rparen != ")" {
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop() // pop )
+		return exp, nil
+	}
+
+	if isAlphaBeta(token[0]) == false {
+		return &statement.LiteralExp{Value: token}, nil
+	}
+
+	lparen, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if tmp == "" { // no where statement
-		update.Where = nil
-		return update, nil
+	if lparen != "(" {
+		return &statement.FieldExp{Field: token}, nil
 	}
+	tokener.Pop() // pop (
 
-	where, err := parseWhere(tokener) // parse where statement
+	funcExp := &statement.FuncExp{FuncName: strings.ToLower(token)}
+	star, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	update.Where = where
-	return update, nil
+	if star == "*" && tokener.IsQuoted() == false { // count(*)
+		tokener.Pop()
+		rparen, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if rparen != ")" {
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop()
+		funcExp.Args = []statement.ValueExp{&statement.StarExp{}}
+		return funcExp, nil
+	}
+
+	for {
+		arg, err := parseValueExp(tokener)
+		if err != nil {
+			return nil, err
+		}
+		funcExp.Args = append(funcExp.Args, arg)
+
+		tmp, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		tokener.Pop()
+		if tmp == ")" {
+			return funcExp, nil
+		} else if tmp != "," {
+			return nil, ErrInvalidStat
+		}
+	}
 }
 
 func parseDelete(tokener *tokener) (*statement.Delete, error) {
@@ -180,42 +511,13 @@ func parseInsert(tokener *tokener) (*statement.Insert, error) {
 	insert.TableName = tableName
 
 	tokener.Pop()
-	values, err := tokener.Peek()
-	if err != nil {
-		return nil, err
-	}
-	if values != "values" {
-		return nil, ErrInvalidStat
-	}
-
-	for { // get value list
-		tokener.Pop()
-		value, err := tokener.Peek()
-		if err != nil {
-			return nil, err
-		}
-		if value == "" { // eof
-			break
-		} else {
-			insert.Values = append(insert.Values, value)
-		}
-	}
-
-	return insert, nil
-}
-
-func parseRead(tokener *tokener) (*statement.Read, error) {
-	read := new(statement.Read)
-
-	asterisk, err := tokener.Peek()
+	lparen, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if asterisk == "*" {
-		read.Fields = append(read.Fields, "*")
+	if lparen == "(" && tokener.IsQuoted() == false { // parse field list
 		tokener.Pop()
-	} else {
-		for { // parse field to be read
+		for {
 			field, err := tokener.Peek()
 			if err != nil {
 				return nil, err
@@ -223,95 +525,684 @@ func parseRead(tokener *tokener) (*statement.Read, error) {
 			if isName(field) == false {
 				return nil, ErrInvalidStat
 			}
-			read.Fields = append(read.Fields, field)
+			insert.Fields = append(insert.Fields, field)
 
 			tokener.Pop()
-			comma, err := tokener.Peek()
+			tmp, err := tokener.Peek()
 			if err != nil {
 				return nil, err
 			}
-			if comma == "," { // has more fields
-				tokener.Pop() // pop ","
-			} else {
+			tokener.Pop()
+			if tmp == ")" {
 				break
+			} else if tmp != "," {
+				return nil, ErrInvalidStat
 			}
 		}
 	}
 
-	from, err := tokener.Peek()
+	values, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if from != "from" {
+	if values != "values" {
 		return nil, ErrInvalidStat
 	}
 
-	tokener.Pop()                    // pop from
-	tableName, err := tokener.Peek() // get table name
+	tokener.Pop()
+	lparen, err = tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if isName(tableName) == false {
-		return nil, ErrInvalidStat
-	}
-	read.TableName = tableName
+	if lparen == "(" && tokener.IsQuoted() == false { // parse value tuples
+		for {
+			tuple, err := parseValueTuple(tokener)
+			if err != nil {
+				return nil, err
+			}
+			addTuple(tokener, insert, tuple)
 
-	tokener.Pop() // pop table name
-	tmp, err := tokener.Peek()
-	if err != nil {
-		return nil, err
-	}
-	if tmp == "" { // no where statement
-		read.Where = nil
-		return read, nil
+			comma, err := tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if comma != "," {
+				break
+			}
+			tokener.Pop() // pop ,
+		}
+		return insert, nil
 	}
 
-	where, err := parseWhere(tokener) // parse where statement
-	if err != nil {
-		return nil, err
-	}
-	read.Where = where
+	var tuple []*string
+	for { // get value list
+		value, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if value == "" && tokener.IsQuoted() == false { // eof
+			break
+		}
+		v, err := parseValue(tokener)
+		if err != nil {
+			return nil, err
+		}
+		tuple = append(tuple, v)
+	}
+	addTuple(tokener, insert, tuple)
+
+	return insert, nil
+}
+
+// addTuple 将一行值tuple加入insert, 并让其中的占位符可以被绑定为NULL.
+func addTuple(tokener *tokener, insert *statement.Insert, tuple []*string) {
+	kinds := tokener.nullable(tuple)
+	insert.Values = append(insert.Values, tuple)
+	if tokener.prepared {
+		insert.Kinds = append(insert.Kinds, kinds)
+	}
+}
+
+// parseValueTuple 解析形如(v1, v2, ..., vn)的一行值.
+func parseValueTuple(tokener *tokener) ([]*string, error) {
+	lparen, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if lparen != "(" || tokener.IsQuoted() {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	var tuple []*string
+	for {
+		v, err := parseValue(tokener)
+		if err != nil {
+			return nil, err
+		}
+		tuple = append(tuple, v)
+
+		tmp, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		tokener.Pop()
+		if tmp == ")" {
+			return tuple, nil
+		} else if tmp != "," {
+			return nil, ErrInvalidStat
+		}
+	}
+}
+
+// parseValue 解析insert中的一个值, 返回nil表示NULL.
+func parseValue(tokener *tokener) (*string, error) {
+	value, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isNegative(tokener) {
+		value, err = parseNegative(tokener)
+		if err != nil {
+			return nil, err
+		}
+		return &value, nil
+	}
+	if tokener.IsParam() {
+		tokener.addParam(&value)
+	} else if tokener.IsQuoted() == false {
+		if value == "null" {
+			tokener.Pop()
+			return nil, nil
+		}
+		if value == "" || isSymbol(value[0]) {
+			return nil, ErrInvalidStat
+		}
+	}
+	tokener.Pop()
+	return &value, nil
+}
+
+func parseRead(tokener *tokener) (*statement.Read, error) {
+	read := new(statement.Read)
+
+	asterisk, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if asterisk == "*" {
+		tokener.Pop()
+	} else {
+		for { // parse fields to be read
+			field, err := parseValueExp(tokener)
+			if err != nil {
+				return nil, err
+			}
+			read.Fields = append(read.Fields, field)
+
+			comma, err := tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if comma == "," { // has more fields
+				tokener.Pop() // pop ","
+			} else {
+				break
+			}
+		}
+	}
+
+	from, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if from != "from" {
+		return nil, ErrInvalidStat
+	}
+
+	tokener.Pop()                    // pop from
+	tableName, err := tokener.Peek() // get table name
+	if err != nil {
+		return nil, err
+	}
+	if isName(tableName) == false {
+		return nil, ErrInvalidStat
+	}
+	read.TableName = tableName
+
+	tokener.Pop() // pop table name
+	for { // parse joins
+		join, err := parseJoin(tokener)
+		if err != nil {
+			return nil, err
+		}
+		if join == nil {
+			break
+		}
+		read.Joins = append(read.Joins, join)
+	}
+
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "where" {
+		read.Where, err = parseWhere(tokener) // parse where statement
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	read.GroupBy, err = parseGroupBy(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "having" {
+		tokener.Pop()
+		exp, err := parseOrExp(tokener, parseCmpExp)
+		if err != nil {
+			return nil, err
+		}
+		read.Having = &statement.Where{Exp: exp}
+	}
+
+	read.OrderBy, err = parseOrderBy(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	read.Limit = -1
+	tmp, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "limit" {
+		tokener.Pop()
+		read.Limit, err = parseCount(tokener)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "offset" {
+		tokener.Pop()
+		read.Offset, err = parseCount(tokener)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return read, nil
 }
 
+// parseJoin 解析[inner|left] join <table name> on <field name> = <field name>, 如果没有join, 则返回nil.
+func parseJoin(tokener *tokener) (*statement.Join, error) {
+	join := new(statement.Join)
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "inner" || tmp == "left" {
+		join.Left = tmp == "left"
+		tokener.Pop()
+		tmp, err = tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if tmp != "join" {
+			return nil, ErrInvalidStat
+		}
+	}
+	if tmp != "join" {
+		return nil, nil
+	}
+	tokener.Pop()
+
+	join.TableName, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if join.TableName == "" || isName(join.TableName) == false {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	on, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if on != "on" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	join.Field1, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if join.Field1 == "" || isName(join.Field1) == false {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	eq, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if eq != "=" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	join.Field2, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if join.Field2 == "" || isName(join.Field2) == false {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+	return join, nil
+}
+
+// parseGroupBy 解析group by语句, 如果没有group by, 则返回nil.
+func parseGroupBy(tokener *tokener) ([]string, error) {
+	group, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if group != "group" {
+		return nil, nil
+	}
+	tokener.Pop()
+	by, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if by != "by" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	var groupBy []string
+	for {
+		field, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if field == "" || isName(field) == false || tokener.IsQuoted() {
+			return nil, ErrInvalidStat
+		}
+		groupBy = append(groupBy, field)
+		tokener.Pop()
+
+		comma, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if comma != "," {
+			return groupBy, nil
+		}
+		tokener.Pop() // pop ,
+	}
+}
+
+// parseOrderBy 解析order by语句, 如果没有order by, 则返回nil.
+func parseOrderBy(tokener *tokener) ([]*statement.OrderBy, error) {
+	order, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if order != "order" {
+		return nil, nil
+	}
+	tokener.Pop()
+	by, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if by != "by" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	var orderBy []*statement.OrderBy
+	for {
+		field, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if field == "" || isName(field) == false || tokener.IsQuoted() {
+			return nil, ErrInvalidStat
+		}
+		ob := &statement.OrderBy{Field: field}
+		tokener.Pop()
+
+		tmp, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if tmp == "asc" || tmp == "desc" {
+			ob.Desc = tmp == "desc"
+			tokener.Pop()
+			tmp, err = tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+		}
+		orderBy = append(orderBy, ob)
+
+		if tmp != "," {
+			return orderBy, nil
+		}
+		tokener.Pop() // pop ,
+	}
+}
+
+// parseCount 解析limit和offset后的非负整数.
+func parseCount(tokener *tokener) (int, error) {
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(tmp)
+	if err != nil || n < 0 {
+		return 0, ErrInvalidStat
+	}
+	tokener.Pop()
+	return n, nil
+}
+
 func parseWhere(tokener *tokener) (*statement.Where, error) {
-	where := new(statement.Where)
+	whereStr, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if whereStr != "where" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop() // pop where
+
+	exp, err := parseOrExp(tokener, parseSingleExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statement.Where{Exp: exp}, nil
+}
+
+/*
+	where表达式的文法如下, 优先级从低到高依次为or, and, not:
+	<or exp>     <and exp> [or <and exp>]*
+	<and exp>    <not exp> [and <not exp>]*
+	<not exp>    not <not exp> | <primary>
+	<primary>    (<or exp>) | <leaf>
+
+	where中的<leaf>为<single exp>, having中的<leaf>为<cmp exp>, 由parseLeaf解析.
+*/
+func parseOrExp(tokener *tokener, parseLeaf leafParser) (statement.Exp, error) {
+	exp, err := parseAndExp(tokener, parseLeaf)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		or, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if or != "or" {
+			return exp, nil
+		}
+		tokener.Pop() // pop or
+
+		exp2, err := parseAndExp(tokener, parseLeaf)
+		if err != nil {
+			return nil, err
+		}
+		exp = &statement.LogicExp{LogicOp: "or", Exp1: exp, Exp2: exp2}
+	}
+}
+
+func parseAndExp(tokener *tokener, parseLeaf leafParser) (statement.Exp, error) {
+	exp, err := parseNotExp(tokener, parseLeaf)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		and, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if and != "and" {
+			return exp, nil
+		}
+		tokener.Pop() // pop and
+
+		exp2, err := parseNotExp(tokener, parseLeaf)
+		if err != nil {
+			return nil, err
+		}
+		exp = &statement.LogicExp{LogicOp: "and", Exp1: exp, Exp2: exp2}
+	}
+}
+
+func parseNotExp(tokener *tokener, parseLeaf leafParser) (statement.Exp, error) {
+	not, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if not == "not" {
+		tokener.Pop() // pop not
+		exp, err := parseNotExp(tokener, parseLeaf)
+		if err != nil {
+			return nil, err
+		}
+		return &statement.NotExp{Exp: exp}, nil
+	}
+	return parsePrimaryExp(tokener, parseLeaf)
+}
+
+func parsePrimaryExp(tokener *tokener, parseLeaf leafParser) (statement.Exp, error) {
+	lparen, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if lparen != "(" {
+		return parseLeaf(tokener)
+	}
+	tokener.Pop() // pop (
+
+	exp, err := parseOrExp(tokener, parseLeaf)
+	if err != nil {
+		return nil, err
+	}
+
+	rparen, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if rparen != ")" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop() // pop )
+	return exp, nil
+}
+
+type leafParser func(tokener *tokener) (statement.Exp, error)
+
+func parseSingleExpr(tokener *tokener) (statement.Exp, error) {
+	singleExp := new(statement.SingleExp)
+
+	field, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(field) == false {
+		return nil, ErrInvalidStat
+	}
+	singleExp.Field = field
+	tokener.Pop()
+
+	op, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if op == "is" {
+		tokener.Pop()
+		return parseIsNull(tokener, field)
+	}
+	if isCmpOp(op) == false {
+		return nil, ErrInvalidStat
+	}
+	singleExp.CmpOp = op
+	tokener.Pop()
+
+	value, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if value == "null" && tokener.IsQuoted() == false { // 与NULL的比较需要使用is null
+		return nil, ErrInvalidStat
+	}
+	n := len(tokener.params)
+	exp, err := parseValueExp(tokener)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range tokener.params[n:] { // where中的占位符同样不能为NULL
+		p.slot = nil
+	}
+
+	switch v := exp.(type) {
+	case *statement.LiteralExp:
+		singleExp.Value = v.Value
+		return singleExp, nil
+	case *statement.ParamExp: // 单独的占位符与字面值一样, 绑定的值直接写入singleExp.Value
+		tokener.params[n].value, tokener.params[n].kind = &singleExp.Value, &singleExp.Kind
+		return singleExp, nil
+	}
+	// 右边是字段或者更复杂的值表达式, 需要对每条记录分别计算
+	return &statement.CmpExp{Exp1: &statement.FieldExp{Field: field}, CmpOp: op, Exp2: exp}, nil
+}
+
+// parseIsNull 解析field之后的[not] null, is已经被弹出.
+func parseIsNull(tokener *tokener, field string) (statement.Exp, error) {
+	isNullExp := &statement.IsNullExp{Field: field}
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "not" {
+		isNullExp.Not = true
+		tokener.Pop()
+		tmp, err = tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tmp != "null" || tokener.IsQuoted() {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+	return isNullExp, nil
+}
+
+// parseCmpExp 解析having中的比较, 形如<value exp> <cmp op> <value exp>.
+func parseCmpExp(tokener *tokener) (statement.Exp, error) {
+	cmpExp := new(statement.CmpExp)
+
+	var err error
+	cmpExp.Exp1, err = parseValueExp(tokener)
+	if err != nil {
+		return nil, err
+	}
 
-	whereStr, err := tokener.Peek()
+	op, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if whereStr != "where" {
+	if isCmpOp(op) == false {
 		return nil, ErrInvalidStat
 	}
-	tokener.Pop() // pop where
+	cmpExp.CmpOp = op
+	tokener.Pop()
 
-	sexp1, err := parseSingleExpr(tokener)
+	cmpExp.Exp2, err = parseValueExp(tokener)
 	if err != nil {
 		return nil, err
 	}
-	where.SingleExp1 = sexp1
+	return cmpExp, nil
+}
 
-	logicOp, err := tokener.Peek()
+func parseDrop(tokener *tokener) (*statement.Drop, error) {
+	table, err := tokener.Peek() // get table
 	if err != nil {
 		return nil, err
 	}
-	if logicOp == "" { // eof, only one compare statement
-		where.LogicOp = ""
-		return where, nil
-	}
-	if isLogicOp(logicOp) == false {
+	if table != "table" {
 		return nil, ErrInvalidStat
 	}
-	where.LogicOp = logicOp
-	tokener.Pop() // pop logicop
 
-	sexp2, err := parseSingleExpr(tokener)
+	tokener.Pop()
+	tableName, err := tokener.Peek() // get table name
 	if err != nil {
 		return nil, err
 	}
-	where.SingleExp2 = sexp2
+	if isName(tableName) == false {
+		return nil, ErrInvalidStat
+	}
 
+	tokener.Pop()
 	eof, err := tokener.Peek()
 	if err != nil {
 		return nil, err
@@ -320,72 +1211,104 @@ func parseWhere(tokener *tokener) (*statement.Where, error) {
 		return nil, ErrInvalidStat
 	}
 
-	return where, nil
+	drop := new(statement.Drop)
+	drop.TableName = tableName
+	return drop, nil
 }
 
-func parseSingleExpr(tokener *tokener) (*statement.SingleExp, error) {
-	singleExp := new(statement.SingleExp)
+// isIndex 判断当前的token是否为index, 用于区分create/drop table和create/drop index.
+func isIndex(tokener *tokener) bool {
+	token, err := tokener.Peek()
+	return err == nil && token == "index" && tokener.IsQuoted() == false
+}
 
-	field, err := tokener.Peek()
+// parseIndex 解析index on <table name>(<field name>), 返回表名和字段名.
+func parseIndex(tokener *tokener) (string, string, error) {
+	if err := expect(tokener, "index"); err != nil {
+		return "", "", err
+	}
+	if err := expect(tokener, "on"); err != nil {
+		return "", "", err
+	}
+	table, err := parseName(tokener)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
-	if isName(field) == false {
-		return nil, ErrInvalidStat
+	if err = expect(tokener, "("); err != nil {
+		return "", "", err
 	}
-	singleExp.Field = field
-	tokener.Pop()
-
-	op, err := tokener.Peek()
+	field, err := parseName(tokener)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
-	if isCmpOp(op) == false {
-		return nil, ErrInvalidStat
+	if err = expect(tokener, ")"); err != nil {
+		return "", "", err
 	}
-	singleExp.CmpOp = op
-	tokener.Pop()
+	return table, field, nil
+}
 
-	value, err := tokener.Peek()
+func parseCreateIndex(tokener *tokener) (*statement.CreateIndex, error) {
+	table, field, err := parseIndex(tokener)
 	if err != nil {
 		return nil, err
 	}
-	singleExp.Value = value
-	tokener.Pop()
+	return &statement.CreateIndex{TableName: table, FieldName: field}, nil
+}
 
-	return singleExp, nil
+func parseDropIndex(tokener *tokener) (*statement.DropIndex, error) {
+	table, field, err := parseIndex(tokener)
+	if err != nil {
+		return nil, err
+	}
+	return &statement.DropIndex{TableName: table, FieldName: field}, nil
 }
 
-func parseDrop(tokener *tokener) (*statement.Drop, error) {
-	table, err := tokener.Peek() // get table
+// parseAlter 解析alter table <table name> (add [column] <field name> <field type> [<constraint>]* | drop [column] <field name>).
+func parseAlter(tokener *tokener) (*statement.Alter, error) {
+	if err := expect(tokener, "table"); err != nil {
+		return nil, err
+	}
+	alter := new(statement.Alter)
+	var err error
+	if alter.TableName, err = parseName(tokener); err != nil {
+		return nil, err
+	}
+
+	op, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if table != "table" {
+	if op != "add" && op != "drop" {
 		return nil, ErrInvalidStat
 	}
-
 	tokener.Pop()
-	tableName, err := tokener.Peek() // get table name
+	column, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if isName(tableName) == false {
-		return nil, ErrInvalidStat
+	if column == "column" && tokener.IsQuoted() == false {
+		tokener.Pop()
 	}
 
-	tokener.Pop()
-	eof, err := tokener.Peek()
+	if op == "drop" {
+		if alter.Drop, err = parseName(tokener); err != nil {
+			return nil, err
+		}
+		return alter, nil
+	}
+
+	field, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if eof != "" {
+	if field == "" || tokener.IsQuoted() {
 		return nil, ErrInvalidStat
 	}
-
-	drop := new(statement.Drop)
-	drop.TableName = tableName
-	return drop, nil
+	alter.Add = &statement.Create{TableName: alter.TableName}
+	if err = parseField(tokener, alter.Add, field); err != nil {
+		return nil, err
+	}
+	return alter, nil
 }
 
 func parseCreate(tokener *tokener) (*statement.Create, error) {
@@ -419,30 +1342,24 @@ func parseCreate(tokener *tokener) (*statement.Create, error) {
 		if err != nil {
 			return nil, err
 		}
-		if isName(field) == false {
-			return nil, ErrInvalidStat
-		}
-
-		tokener.Pop()                // pop field
-		ftype, err := tokener.Peek() // get field type
-		if err != nil {
+		if field == "check" || field == "constraint" { // 表级的check约束
+			check, err := parseCheck(tokener, "")
+			if err != nil {
+				return nil, err
+			}
+			create.Checks = append(create.Checks, check)
+		} else if err = parseField(tokener, create, field); err != nil {
 			return nil, err
 		}
-		if isType(ftype) == false {
-			return nil, ErrInvalidStat
-		}
 
-		create.FieldName = append(create.FieldName, field)
-		create.FieldType = append(create.FieldType, ftype)
-
-		tokener.Pop() // pop field type
 		next, err := tokener.Peek()
 		if err != nil {
 			return nil, err
 		}
+
 		if next == "," { // has next field
-		} else if next == "" { // is eof, return now
-			return nil, ErrHasNoIndex
+		} else if next == "" { // is eof, has no index
+			return create, nil
 		} else if next == "(" { // has index
 			break
 		} else { // error statement
@@ -484,21 +1401,332 @@ func parseCreate(tokener *tokener) (*statement.Create, error) {
 	return create, nil
 }
 
-func isLogicOp(op string) bool {
-	return op == "and" || op == "or"
+// parseField 解析create中名为field的字段的类型和约束, 并将其加入create, field已经被Peek.
+func parseField(tokener *tokener, create *statement.Create, field string) error {
+	if isName(field) == false {
+		return ErrInvalidStat
+	}
+
+	tokener.Pop()                // pop field
+	ftype, err := tokener.Peek() // get field type
+	if err != nil {
+		return err
+	}
+	if isType(ftype) == false {
+		return ErrInvalidStat
+	}
+
+	tokener.Pop() // pop field type
+	next, err := tokener.Peek()
+	if err != nil {
+		return err
+	}
+	notNull, unique := false, false
+	var def *statement.Default
+	var ref *statement.Reference
+	for { // 各个约束可以以任意顺序出现
+		if next == "not" && notNull == false {
+			tokener.Pop()
+			null, err := tokener.Peek()
+			if err != nil {
+				return err
+			}
+			if null != "null" {
+				return ErrInvalidStat
+			}
+			tokener.Pop()
+			notNull = true
+		} else if next == "primary" && create.PrimaryKey == "" {
+			tokener.Pop()
+			key, err := tokener.Peek()
+			if err != nil {
+				return err
+			}
+			if key != "key" {
+				return ErrInvalidStat
+			}
+			tokener.Pop()
+			create.PrimaryKey = field
+		} else if next == "unique" && unique == false {
+			tokener.Pop()
+			unique = true
+		} else if next == "default" && def == nil {
+			tokener.Pop()
+			def, err = parseDefault(tokener)
+			if err != nil {
+				return err
+			}
+		} else if next == "references" && ref == nil {
+			tokener.Pop()
+			ref, err = parseReference(tokener)
+			if err != nil {
+				return err
+			}
+		} else if next == "check" || next == "constraint" {
+			check, err := parseCheck(tokener, field)
+			if err != nil {
+				return err
+			}
+			create.Checks = append(create.Checks, check)
+		} else {
+			break
+		}
+		next, err = tokener.Peek()
+		if err != nil {
+			return err
+		}
+	}
+
+	create.FieldName = append(create.FieldName, field)
+	create.FieldType = append(create.FieldType, ftype)
+	create.NotNull = append(create.NotNull, notNull)
+	create.Unique = append(create.Unique, unique)
+	create.Default = append(create.Default, def)
+	create.References = append(create.References, ref)
+	return nil
+}
+
+/*
+	parseCheck 解析[constraint <name>] check (<check exp>), 当前的token为constraint或check.
+	field为该约束所属的字段, 为空表示表级的约束.
+
+	check中的表达式的文法与where相同, 但<leaf>为<field> is [not] null, 或者<value exp> <cmp op> <value exp>.
+	表达式的原文会被记录在Text中, 以便TBM将其持久化, 所以其中不能有占位符.
+*/
+func parseCheck(tokener *tokener, field string) (*statement.Check, error) {
+	check := &statement.Check{Field: field}
+	token, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if token == "constraint" {
+		tokener.Pop()
+		if check.Name, err = parseName(tokener); err != nil {
+			return nil, err
+		}
+		if token, err = tokener.Peek(); err != nil {
+			return nil, err
+		}
+	}
+	if token != "check" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+	if err = expect(tokener, "("); err != nil {
+		return nil, err
+	}
+
+	begin, params := tokener.end, len(tokener.params)
+	check.Exp, err = parseOrExp(tokener, parseCheckLeaf)
+	if err != nil {
+		return nil, err
+	}
+	rparen, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if rparen != ")" || tokener.IsQuoted() || len(tokener.params) != params {
+		return nil, ErrInvalidStat
+	}
+	check.Text = strings.TrimSpace(string(tokener.stat[begin:tokener.start]))
+	tokener.Pop()
+	return check, nil
+}
+
+// parseCheckLeaf 解析check表达式中的<leaf>.
+func parseCheckLeaf(tokener *tokener) (statement.Exp, error) {
+	exp1, err := parseValueExp(tokener)
+	if err != nil {
+		return nil, err
+	}
+	op, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if op == "is" {
+		fe, ok := exp1.(*statement.FieldExp)
+		if ok == false {
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop()
+		return parseIsNull(tokener, fe.Field)
+	}
+	if isCmpOp(op) == false {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	exp2, err := parseValueExp(tokener)
+	if err != nil {
+		return nil, err
+	}
+	return &statement.CmpExp{Exp1: exp1, CmpOp: op, Exp2: exp2}, nil
+}
+
+// parseDefault 解析default之后的默认值, 为<value>或<func name>().
+func parseDefault(tokener *tokener) (*statement.Default, error) {
+	token, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tokener.IsParam() {
+		return nil, ErrInvalidStat
+	}
+	if token == "" || tokener.IsQuoted() || token == "null" || isAlphaBeta(token[0]) == false {
+		value, err := parseValue(tokener)
+		if err != nil {
+			return nil, err
+		}
+		return &statement.Default{Value: value}, nil
+	}
+
+	tokener.Pop()
+	lparen, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if lparen != "(" { // 以字母开头的字面值, 如true
+		return &statement.Default{Value: &token}, nil
+	}
+	tokener.Pop()
+	rparen, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if rparen != ")" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+	return &statement.Default{Func: strings.ToLower(token)}, nil
+}
+
+// parseReference 解析references之后的<table name>(<field name>), 以及可选的on delete和on update.
+func parseReference(tokener *tokener) (*statement.Reference, error) {
+	ref := &statement.Reference{OnDelete: "restrict", OnUpdate: "restrict"}
+	var err error
+	if ref.TableName, err = parseName(tokener); err != nil {
+		return nil, err
+	}
+	if err = expect(tokener, "("); err != nil {
+		return nil, err
+	}
+	if ref.FieldName, err = parseName(tokener); err != nil {
+		return nil, err
+	}
+	if err = expect(tokener, ")"); err != nil {
+		return nil, err
+	}
+
+	deleteGiven, updateGiven := false, false
+	for {
+		on, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if on != "on" {
+			return ref, nil
+		}
+		tokener.Pop()
+		event, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		var action *string
+		if event == "delete" && deleteGiven == false {
+			action, deleteGiven = &ref.OnDelete, true
+		} else if event == "update" && updateGiven == false {
+			action, updateGiven = &ref.OnUpdate, true
+		} else {
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop()
+		*action, err = tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		switch *action {
+		case "restrict", "cascade":
+		case "set":
+			tokener.Pop()
+			if err = expect(tokener, "null"); err != nil {
+				return nil, err
+			}
+			*action = "set null"
+			continue
+		default:
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop()
+	}
+}
+
+// parseName 解析一个表名或者字段名.
+func parseName(tokener *tokener) (string, error) {
+	name, err := tokener.Peek()
+	if err != nil {
+		return "", err
+	}
+	if name == "" || tokener.IsQuoted() || isName(name) == false {
+		return "", ErrInvalidStat
+	}
+	tokener.Pop()
+	return name, nil
+}
+
+// expect 要求当前的token为symbol, 并将其弹出.
+func expect(tokener *tokener, symbol string) error {
+	token, err := tokener.Peek()
+	if err != nil {
+		return err
+	}
+	if token != symbol || tokener.IsQuoted() {
+		return ErrInvalidStat
+	}
+	tokener.Pop()
+	return nil
 }
 
 func isType(tp string) bool {
-	return tp == "uint32" || tp == "uint64" ||
-		 tp == "string"
+	return tp == "uint32" || tp == "uint64" || tp == "int32" || tp == "int64" ||
+		tp == "float64" || tp == "bool" || tp == "string"
+}
+
+// isNegative 判断当前的token是否是负号, 需要在Peek之后调用.
+func isNegative(tokener *tokener) bool {
+	token, _ := tokener.Peek()
+	return token == "-" && tokener.IsQuoted() == false
+}
+
+// parseNegative 解析形如-5的负数, 负号之后必须紧跟一个数字.
+func parseNegative(tokener *tokener) (string, error) {
+	tokener.Pop() // pop -
+	num, err := tokener.Peek()
+	if err != nil {
+		return "", err
+	}
+	if tokener.IsQuoted() || num == "" || isDigital(num[0]) == false {
+		return "", ErrInvalidStat
+	}
+	tokener.Pop()
+	return "-" + num, nil
 }
 
+/*
+	isName 判断name能否作为表名或字段名.
+	tokener中的所有关键字(见keywords, 包括字段类型)都是保留字, 不区分大小写, 不能作为名字;
+	函数名(如count, upper, now)不是关键字, 依然可以作为名字.
+	语句意外结束时name为空, 同样不是名字.
+*/
 func isName(name string) bool {
+	if name == "" || keywords[name] {
+		return false
+	}
 	return !(len(name) == 1 && isAlphaBeta(name[0]) == false)
 }
 
 func isCmpOp(op string) bool {
-	return op == "=" || op == ">" || op == "<"
+	return op == "=" || op == ">" || op == "<" ||
+		op == "<=" || op == ">=" || op == "!="
 }
 
 func parseBegin(tokener *tokener) (*statement.Begin, error) {
@@ -14,44 +14,206 @@ type Drop struct {
 type Show struct {
 }
 
+// Alter 修改表的结构. Add不为nil时表示add column, 其中只有一个字段, 没有索引; 否则表示drop column Drop.
+type Alter struct {
+	TableName string
+	Add       *Create
+	Drop      string
+}
+
+// CreateIndex 为表TableName中已经存在的字段FieldName建立索引.
+type CreateIndex struct {
+	TableName string
+	FieldName string
+}
+
+// DropIndex 删除表TableName中字段FieldName的索引.
+type DropIndex struct {
+	TableName string
+	FieldName string
+}
+
+// Explain 查看Stat的执行计划, Stat的类型为*Read, *Update或*Delete.
+type Explain struct {
+	Stat interface{}
+}
+
+// Create 中FieldName, FieldType, NotNull, Unique, Default和References一一对应,
+// Default和References中的nil分别表示没有默认值和没有外键.
+// PrimaryKey为主键的字段名, 为空表示没有主键.
 type Create struct {
+	TableName  string
+	FieldName  []string
+	FieldType  []string
+	NotNull    []bool
+	Unique     []bool
+	Default    []*Default
+	References []*Reference
+	Checks     []*Check
+	PrimaryKey string
+	Index      []string
+}
+
+// Default 为字段的默认值. Func为空时, 默认值为字面值Value, Value为nil表示NULL;
+// 否则默认值由函数Func产生, 如now()和seq().
+type Default struct {
+	Value *string
+	Func  string
+}
+
+// Reference 为字段的外键约束, 表示该字段的值必须是表TableName中字段FieldName的某个值.
+// OnDelete和OnUpdate为被引用的记录被删除或者被修改时的行为, 为restrict, cascade或set null.
+type Reference struct {
 	TableName string
-	FieldName []string
-	FieldType []string
-	Index     []string
+	FieldName string
+	OnDelete  string
+	OnUpdate  string
+}
+
+// Check 为check约束, Field为该约束所在的字段, 为空表示表级的约束; Name为空表示没有给出名字.
+// Exp中只会出现*LogicExp, *NotExp, *IsNullExp和*CmpExp, Text为Exp的原文.
+type Check struct {
+	Name  string
+	Field string
+	Exp   Exp
+	Text  string
 }
 
 type Update struct {
 	TableName string
-	FieldName string
-	Value     string
+	Sets      []*Set
 	Where     *Where
 }
 
+// Set 为update中的一个赋值, 将FieldName更新为Value的计算结果.
+type Set struct {
+	FieldName string
+	Value     ValueExp
+}
+
 type Delete struct {
 	TableName string
 	Where     *Where
 }
 
+// Insert 插入多行记录, Fields为空表示按照表中字段声明的顺序给出每一行的值.
+// Values中的nil表示NULL. 预编译的语句中, Kinds与Values一一对应, 为占位符绑定的值的类型, 见ParamExp.
 type Insert struct {
 	TableName string
-	Values    []string
+	Fields    []string
+	Values    [][]*string
+	Kinds     [][]string
 }
 
+// Read 中Fields为nil表示*, Limit小于0表示没有limit.
 type Read struct {
 	TableName string
-	Fields    []string
+	Joins     []*Join
+	Fields    []ValueExp
 	Where     *Where
+	GroupBy   []string
+	Having    *Where
+	OrderBy   []*OrderBy
+	Limit     int
+	Offset    int
+}
+
+// Join 表示join TableName on Field1 = Field2, Left为true表示left join, 否则为inner join.
+type Join struct {
+	TableName string
+	Left      bool
+	Field1    string
+	Field2    string
+}
+
+type OrderBy struct {
+	Field string
+	Desc  bool
 }
 
 type Where struct {
-	SingleExp1 *SingleExp
-	LogicOp    string
-	SingleExp2 *SingleExp
+	Exp Exp
+}
+
+// Exp 为where, having或check中的表达式, 其类型为*LogicExp, *NotExp, *SingleExp, *IsNullExp或*CmpExp.
+// 其中*SingleExp只出现在where中, *IsNullExp只出现在where和check中.
+// where中比较的右边不是单独的字面值或占位符时, 会被解析为左边为*FieldExp的*CmpExp.
+type Exp interface{}
+
+type LogicExp struct {
+	LogicOp string
+	Exp1    Exp
+	Exp2    Exp
+}
+
+type NotExp struct {
+	Exp Exp
 }
 
+// SingleExp 中Value为占位符时, Kind为其绑定的值的类型, 见ParamExp.
 type SingleExp struct {
 	Field string
 	CmpOp string
 	Value string
+	Kind  string
+}
+
+// IsNullExp 表示Field is null, Not为true时表示Field is not null.
+type IsNullExp struct {
+	Field string
+	Not   bool
+}
+
+// CmpExp 比较两个值表达式, 如having count(*) > 1.
+type CmpExp struct {
+	Exp1  ValueExp
+	CmpOp string
+	Exp2  ValueExp
+}
+
+// ValueExp 为值表达式, 其类型为*LiteralExp, *ParamExp, *NullExp, *FieldExp, *ArithExp, *FuncExp或*StarExp.
+type ValueExp interface{}
+
+// LiteralExp 为字面值, 其类型由使用它的上下文决定.
+type LiteralExp struct {
+	Value string
+}
+
+// ParamExp 为预编译语句中的占位符?, Value为执行时绑定的值, 为nil表示NULL.
+// Kind为绑定的值的类型, 为Kind*中的一个, 为空表示没有指定类型, 这时与字面值相同.
+type ParamExp struct {
+	Value *string
+	Kind  string
 }
+
+// 占位符绑定的值的类型, 与字段类型的对应关系为: uint32和uint64为KindUint, int32和int64为KindInt,
+// float64为KindFloat, bool为KindBool, string为KindString.
+const (
+	KindUint   = "uint"
+	KindInt    = "int"
+	KindFloat  = "float"
+	KindBool   = "bool"
+	KindString = "string"
+)
+
+// NullExp 为NULL.
+type NullExp struct{}
+
+// FieldExp 为对某个字段的引用.
+type FieldExp struct {
+	Field string
+}
+
+type ArithExp struct {
+	ArithOp string
+	Exp1    ValueExp
+	Exp2    ValueExp
+}
+
+type FuncExp struct {
+	FuncName string
+	Args     []ValueExp
+}
+
+// StarExp 只作为count(*)的参数出现.
+type StarExp struct{}
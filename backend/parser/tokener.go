@@ -3,22 +3,61 @@
    使用自动机来完成。
    // TODO
    自动机状态图见http://nothing.com
+
+   字符串可以用单引号或双引号括起来, 其中可以使用以下转义:
+   \' \" \\ \n \t \r, 以及连续两个与外侧相同的引号, 如'it''s'.
+   --到行尾的内容, 以及以"/*"开始, 以"*"和"/"结束的块注释, 都会被当做空白跳过.
+   没有被引号括起来的关键字不区分大小写, 会被统一转换为小写, 字段名和表名保持原样.
 */
 package parser
 
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnclosedQuote   = errors.New("Unclosed quote.")
+	ErrUnclosedComment = errors.New("Unclosed comment.")
+)
+
+// keywords 为所有的关键字, 它们同时也是保留字, 不能作为名字, 见isName.
+var keywords = map[string]bool{
+	"begin": true, "commit": true, "abort": true, "create": true, "drop": true,
+	"read": true, "insert": true, "delete": true, "update": true, "show": true,
+	"explain": true, "isolation": true, "level": true, "committed": true, "repeatable": true,
+	"table": true, "index": true, "from": true, "where": true, "and": true,
+	"or": true, "not": true, "into": true, "values": true, "set": true,
+	"join": true, "inner": true, "left": true, "on": true, "group": true,
+	"having": true, "order": true, "by": true, "asc": true, "desc": true,
+	"limit": true, "offset": true, "uint32": true, "uint64": true, "int32": true,
+	"int64": true, "float64": true, "bool": true, "string": true,
+	"null": true, "is": true, "default": true,
+	"primary": true, "key": true, "unique": true,
+	"references": true, "restrict": true, "cascade": true,
+	"check": true, "constraint": true, "alter": true, "add": true, "column": true,
+}
+
 type tokener struct {
 	stat []byte
 	pos  int
 
 	curToken   string
 	flushToken bool
+	quoted     bool // 当前token是否是由引号括起来的
+	start, end int  // 当前token在语句中的位置, 用于报告错误
+
+	prepared bool      // 是否允许占位符?
+	params   []*Param // 语句中的占位符?, 按照出现的顺序排列
 
 	err error
 }
 
-func newTokener(stat []byte) *tokener {
+func newTokener(stat []byte, prepared bool) *tokener {
 	return &tokener{
-		stat, 0, "", true, nil,
+		stat: stat, flushToken: true, prepared: prepared,
 	}
 }
 
@@ -34,6 +73,10 @@ func (tk *tokener) Peek() (string, error) {
 			tk.err = err
 			return "", err
 		}
+		if tk.prepared == false && token == "?" && tk.quoted == false {
+			tk.err = ErrInvalidStat
+			return "", tk.err
+		}
 		tk.curToken = token
 		tk.flushToken = false
 	}
@@ -41,6 +84,39 @@ func (tk *tokener) Peek() (string, error) {
 	return tk.curToken, nil
 }
 
+// IsQuoted 返回当前token是否是由引号括起来的字符串, 需要在Peek之后调用.
+func (tk *tokener) IsQuoted() bool {
+	return tk.quoted
+}
+
+// IsParam 返回当前token是否是占位符?, 需要在Peek之后调用.
+func (tk *tokener) IsParam() bool {
+	return tk.curToken == "?" && tk.quoted == false
+}
+
+// addParam 记录一个占位符, value指向该占位符在语句中对应的值.
+func (tk *tokener) addParam(value *string) *Param {
+	p := &Param{value: value}
+	tk.params = append(tk.params, p)
+	return p
+}
+
+// nullable 让values中的占位符可以被绑定为NULL, values为insert中的一行值, 需要在其不再改变之后调用.
+// 返回与values一一对应的, 记录占位符绑定的值的类型的kinds.
+func (tk *tokener) nullable(values []*string) []string {
+	kinds := make([]string, len(values))
+	for i := len(tk.params) - 1; i >= 0 && i >= len(tk.params)-len(values); i-- {
+		p := tk.params[i]
+		for j := range values {
+			if values[j] == p.value {
+				p.slot = &values[j]
+				p.kind = &kinds[j]
+			}
+		}
+	}
+	return kinds
+}
+
 // Pop 弹出当前的token
 func (tk *tokener) Pop() {
 	tk.flushToken = true
@@ -60,46 +136,110 @@ func (tk *tokener) peekByte() (byte, bool) {
 	return tk.stat[tk.pos], false
 }
 
+// peekByteAt 查看当前位置之后第i个字节.
+func (tk *tokener) peekByteAt(i int) (byte, bool) {
+	if tk.pos+i >= len(tk.stat) {
+		return 0, true
+	}
+	return tk.stat[tk.pos+i], false
+}
+
 func (tk *tokener) next() (string, error) {
 	if tk.err != nil {
 		return "", tk.err
 	}
-	return tk.nextMetaState()
+	token, err := tk.nextMetaState()
+	tk.end = tk.pos
+	return token, err
 }
 
 func (tk *tokener) nextMetaState() (string, error) {
+	tk.quoted = false
+	if err := tk.skipBlankState(); err != nil {
+		return "", err
+	}
+	tk.start = tk.pos
+
+	b, eof := tk.peekByte()
+	if eof == true {
+		return "", nil
+	}
+	if isSymbol(b) || b == '!' {
+		return tk.nextSymbolState()
+	} else if b == '"' || b == '\'' {
+		return tk.nextQuoteState()
+	} else if isAlphaBeta(b) || isDigital(b) {
+		return tk.nextTokenState()
+	} else {
+		_, size := utf8.DecodeRune(tk.stat[tk.pos:])
+		tk.pos += size
+		tk.err = ErrInvalidStat
+		return "", tk.err
+	}
+}
+
+// skipBlankState 跳过空白和注释.
+func (tk *tokener) skipBlankState() error {
 	for {
 		b, eof := tk.peekByte()
 		if eof == true {
-			return "", nil
+			return nil
 		}
-		if isBlank(b) == false {
-			break
+		next, _ := tk.peekByteAt(1)
+		switch {
+		case isBlank(b):
+			tk.popByte()
+		case b == '-' && next == '-':
+			for b, eof := tk.peekByte(); eof == false && b != '\n'; b, eof = tk.peekByte() {
+				tk.popByte()
+			}
+		case b == '/' && next == '*':
+			tk.start = tk.pos
+			tk.pos += 2
+			for {
+				b, eof := tk.peekByte()
+				if eof == true {
+					tk.err = ErrUnclosedComment
+					return tk.err
+				}
+				if next, _ := tk.peekByteAt(1); b == '*' && next == '/' {
+					tk.pos += 2
+					break
+				}
+				tk.popByte()
+			}
+		default:
+			return nil
 		}
-		tk.popByte()
 	}
+}
 
+// nextSymbolState 解析符号, 其中<=, >=, !=由两个字符组成.
+func (tk *tokener) nextSymbolState() (string, error) {
 	b, _ := tk.peekByte()
-	if isSymbol(b) {
-		tk.popByte()
-		return string(b), nil
-	} else if b == '"' || b == '\'' {
-		return tk.nextQuoteState()
-	} else if isAlphaBeta(b) || isDigital(b) {
-		return tk.nextTokenState()
-	} else {
+	tk.popByte()
+	if b == '<' || b == '>' || b == '!' {
+		next, eof := tk.peekByte()
+		if eof == false && next == '=' {
+			tk.popByte()
+			return string([]byte{b, next}), nil
+		}
+	}
+	if b == '!' {
 		tk.err = ErrInvalidStat
 		return "", tk.err
 	}
+	return string(b), nil
 }
 
+// nextTokenState 解析由字母和数字组成的token, 其中还可以包含'.', 如student.id和2.5.
 func (tk *tokener) nextTokenState() (string, error) {
 	var tmp []byte
 	for {
 		b, eof := tk.peekByte()
-		if eof == true || (isAlphaBeta(b) || isDigital(b)) == false {
-			if isBlank(b) {
-				tk.popByte()
+		if eof == true || (isAlphaBeta(b) || isDigital(b) || b == '.') == false {
+			if lower := strings.ToLower(string(tmp)); keywords[lower] {
+				return lower, nil
 			}
 			return string(tmp), nil
 		}
@@ -116,31 +256,61 @@ func (tk *tokener) nextQuoteState() (string, error) {
 	for {
 		b, eof := tk.peekByte()
 		if eof == true {
-			tk.err = ErrInvalidStat
+			tk.err = ErrUnclosedQuote
 			return "", tk.err
 		}
+		tk.popByte()
 		if b == quote {
+			if next, _ := tk.peekByte(); next != quote {
+				tk.quoted = true
+				break
+			}
+			tk.popByte() // 连续两个引号表示引号本身
+		} else if b == '\\' {
+			b, eof = tk.peekByte()
+			if eof == true {
+				tk.err = ErrUnclosedQuote
+				return "", tk.err
+			}
 			tk.popByte()
-			break
+			b = unescape(b)
 		}
 		tmp = append(tmp, b)
-		tk.popByte()
 	}
 
 	return string(tmp), nil
 }
 
-func (tk *tokener) ErrStat() []byte {
-	tmp := make([]byte, len(tk.stat)+3)
-	copy(tmp, tk.stat[:tk.pos])
-	copy(tmp[tk.pos:], []byte("<< "))
-	copy(tmp[tk.pos+3:], tk.stat[tk.pos:])
-	return tmp
+// unescape 返回\b所表示的字符, 不认识的转义表示字符本身, 如\\, \'.
+func unescape(b byte) byte {
+	switch b {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	}
+	return b
+}
+
+// errorAt 为err加上当前token的位置, 行号和列号都从1开始, 列号按字符计算.
+func (tk *tokener) errorAt(err error) error {
+	line := 1 + bytes.Count(tk.stat[:tk.start], []byte{'\n'})
+	lineStart := bytes.LastIndexByte(tk.stat[:tk.start], '\n') + 1
+	column := 1 + utf8.RuneCount(tk.stat[lineStart:tk.start])
+	return &SyntaxError{
+		Line:   line,
+		Column: column,
+		Token:  string(tk.stat[tk.start:tk.end]),
+		Err:    err,
+	}
 }
 
 func isSymbol(b byte) bool {
 	return b == '>' || b == '<' || b == '=' || b == '*' ||
-		b == ',' || b == '(' || b == ')'
+		b == ',' || b == '(' || b == ')' || b == '+' ||
+		b == '-' || b == '/' || b == '?'
 }
 
 func isAlphaBeta(b byte) bool {
@@ -152,5 +322,5 @@ func isDigital(b byte) bool {
 }
 
 func isBlank(b byte) bool {
-	return b == '\n' || b == ' ' || b == '\t'
+	return b == '\n' || b == ' ' || b == '\t' || b == '\r'
 }
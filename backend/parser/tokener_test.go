@@ -7,7 +7,7 @@ import (
 
 func TestToken(t *testing.T) {
 	cmd := []byte("update student32 set name='ZYJ' where id = 5")
-	tk := newTokener(cmd)
+	tk := newTokener(cmd, false)
 	for {
 		token, err := tk.Peek()
 		if token == "" {
@@ -21,3 +21,42 @@ func TestToken(t *testing.T) {
 		tk.Pop()
 	}
 }
+
+func TestLexer(t *testing.T) {
+	cmd := "SELECT -- comment\n/* block\n comment */ 'it''s' \"a \\\"b\\\"\\n\" 'C:\\\\' Name >= ''"
+	expected := []string{"SELECT", "it's", "a \"b\"\n", "C:\\", "Name", ">=", ""}
+	tk := newTokener([]byte(cmd), false)
+	for _, e := range expected {
+		token, err := tk.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != e {
+			t.Fatalf("expected %q, got %q", e, token)
+		}
+		tk.Pop()
+	}
+	if token, err := tk.Peek(); token != "" || err != nil {
+		t.Fatal(token, err)
+	}
+
+	tk = newTokener([]byte("READ * From student WHERE Name = 'x'"), false)
+	for _, e := range []string{"read", "*", "from", "student", "where", "Name"} {
+		if token, _ := tk.Peek(); token != e {
+			t.Fatalf("expected %q, got %q", e, token)
+		}
+		tk.Pop()
+	}
+
+	for _, cmd := range []string{"'abc", "'abc\\'", "/* abc", "a # b"} {
+		tk := newTokener([]byte(cmd), false)
+		var err error
+		for err == nil {
+			var token string
+			if token, err = tk.Peek(); token == "" && err == nil {
+				t.Fatal(cmd)
+			}
+			tk.Pop()
+		}
+	}
+}
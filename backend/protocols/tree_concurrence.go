@@ -5,7 +5,7 @@
    每个节点都有一个读写锁, 并规定, 在对u做任意读取之前, 都必须要调用u.RLock(),
    在对u做任意修改之前, 都必须要调用u.WLock(). 且u.Leaf()能够返回该节点是否为叶子节点.
 
-   另外, 我们规定, 任意的事务Ti, 在某一时刻, 最多只能取得一个节点的锁.
+   另外, 我们规定, 任意的事务Ti, 在某一时刻, 最多只能取得一个节点的锁(节点的合并除外, 见后文).
    也就是说, 现在Ti取得u的锁, 如果它想访问下一个节点v, 那么它必须先释放掉u的锁.
    该协议能够保证在并发访问的情况下, 不会出现死锁.
 
@@ -22,6 +22,8 @@
    3.2.1)如果不需要, u.WUnlock(), 插入成功, 直接返回.
    3.2.2)如果需要, 则依照B+树算法, 创建新节点v, 另s(u):=v, u.WUnlock().
    4)递归的向父亲节点插入新增加的节点(如果需要的话), 直至根节点.
+   4.1)如果根节点u分裂了, 则在boot的锁的保护下检查u是否依然是根节点, 如果是, 则创建新的根节点.
+       否则说明根节点已经被其他事务的分裂替换了, 这时从新的根节点开始找到u上面一层的节点, 像3)一样插入, 并继续4).
 
    现在说明插入失败, 和查找失败的原因. 假设T1准备向B+树中插入(10, 10), 并有如下的执行序列:
    1) T1当前在u节点, 并查询到它下一个需要访问的节点是v; // 注意此时T1并没有v的锁
@@ -37,5 +39,26 @@
    插入操作也是同理.
    对于S(key)的操作就不用赘述了, 就是I(key, value)中的1), 2), 3)步, 只不过把对应的插入操作改为查询操作.
 
+   下面描述节点的合并M(p, u, v), 其中u和v在父节点p中相邻, 且s(u) = v, 具体过程见im/merge.go:
+   1)p.WLock(), u.WLock(), v.WLock(), 检查上述条件, 如果不满足, 则全部释放并放弃合并;
+   2)将p中u的范围扩大到原来v的范围, p.WUnlock();
+   3)将v的内容合并到u中(放不下时分配到u和新节点n中), 令s(u) = s(v)(或者n), u.WUnlock();
+   4)将v标记为已合并, v.WUnlock();
+   5)如果有n, 则像I(k, v)中的4)一样, 将n插入父节点.
+   合并是唯一会同时持有多个锁的操作, 它总是先获得父节点的锁, 再从左到右获得同一层的两个子节点的锁.
+   将节点按照"层数高的在前, 同一层中从左到右"排序, 则合并总是按照该顺序加锁, 而其他操作在等待锁时不持有任何锁.
+   于是任何等待链上被等待的节点都是严格递增的, 不会形成环, 所以该协议依然是无死锁的.
+   根节点的收缩会先获得boot的锁, 再获得根节点的锁, 然后读取其唯一的子节点(获得该子节点的读锁),
+   加锁的顺序依然是先父节点后子节点, 而持有节点的锁时从不会去获得boot的锁, 所以也不会死锁.
+
+   合并之后, 数据只会从v移动到它左边的u中. 对于在合并之前读到了指向v的指针的操作:
+   查询会读到v被合并时的内容, 再继续读s(v), 由于v的内容和s(v)都不再改变,
+   这等价于在合并之前读取了v. 而如果它在合并之前已经读过了u, 那么它读到的是u原来的内容, 不会重复读到v的内容.
+   插入和删除则会发现v已经被合并, 并从根节点重新开始. 在v被标记之前, p和u都已经不再指向v,
+   所以重新开始的操作不会再遇到v. 向父节点插入分裂产生的新节点时, 如果父节点已经被合并,
+   则从根节点重新找到新节点上面一层中对应的节点, 并插入其中. 在此期间新节点依然可以通过s(u)找到,
+   这和崩溃时的情况相同, 见tree_recovery.go. 由于每个新节点最终都会被插入父节点,
+   右链的长度不会因为合并而持续增长. 被合并的节点不会被回收, 它们所占的空间会一直保留在DM中.
+
 */
 package protocols
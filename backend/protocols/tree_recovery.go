@@ -3,7 +3,10 @@
 
    B+树是事务无关的, 既B+树直接以超级事务(SUPER_XID)在执行.
    试想如果某个事务, 在B+树中插入了很多索引, 然后又被回滚了, 会怎么样?
-   结果就是该事务的索引依然留在B+树种, 但是VM却”读不出来”, 和B+树没有Delete操作类似, 因此不会对其他事务造成影响, 这些废弃的索引是安全的.
+   结果就是该事务的索引依然留在B+树种, 但是VM却”读不出来”, 因此不会对其他事务造成影响, 这些废弃的索引是安全的.
+   B+树的Delete同样以超级事务执行, 不会随着事务回滚而恢复. 所以只有在删除记录的事务已经提交,
+   且该记录已经对所有事务都不可见之后, TBM才会将其从索引中删除(见tbm/vacuum.go).
+   如果崩溃时还有没来得及删除的条目, 它们就和被回滚的事务留下的索引一样, 是安全的.
 
    现在再来看看如果B+树在执行过程中, 发生了崩溃会怎么样?
    如果Ti在对u进行修改时, 发生了崩溃, 那么节点u的内部结构就被破坏了, 但是由于B+树是建立在DM上的, 在下一次数据库重启时, u就会被恢复成修改之前的状态.
@@ -25,6 +28,15 @@
    但是这样是没问题的! 因为在插入和查询操作中, 如果失败, 就会不断的向右兄弟节点迭代.
    因此, 在错误的状态下, 如果想找v中的内容, 那么情况是:
         1)找到parent, 2)通过parent找到u, 3)在u中查找失败, 4)通过u找到v, 5)查找成功.
+   节点的合并(见im/merge.go)会依次修改父节点p, 左边的节点u, 以及被合并的节点v, 崩溃时的状态有以下几种:
+        1)p已经修改, u没有: u在p中的范围已经包含了v, 但v的内容还没有被移动到u中.
+          此时v可以通过s(u)找到, 这和上面的错误状态相同, 是没问题的.
+        2)u已经修改, v没有: v的内容已经在u中, 且s(u)不再指向v, 于是v不会再被访问到, 它只是一块被浪费的空间.
+          如果合并时产生了新节点n, n此时还没有被插入p, 同样可以通过s(u)找到.
+        3)v已经被标记为已合并, 但n还没有被插入p: 同上.
+   根节点的收缩会先将boot指向唯一的子节点, 再将原来的根节点标记为已合并, 于是崩溃时, boot总是指向一个没有被合并的节点.
+   被合并的节点所占的空间不会被回收, 这些被浪费的空间和被回滚的事务留下的索引一样, 是安全的.
+
    于是, 在DM的原子性保护下, 结合B+树本身的算法过程, 能够证明B+树是完全能够应对数据库崩坏的.
 */
 package protocols
@@ -12,21 +12,44 @@ import (
 var (
 	ErrNoNestedTransaction = errors.New("No Nested Transaction.")
 	ErrNotInAnyTransaction = errors.New("Not in any transaction.")
+	ErrNoThatStmt          = errors.New("No that prepared statement.")
+	ErrInvalidParams       = errors.New("Invalid parameters.")
 )
 
+/*
+	Executor 执行一个连接中的语句.
+	除了直接执行sql外, 还可以先用Prepare预编译一条含有占位符?的语句, 得到该语句的id,
+	再用ExecutePrepared绑定参数并执行, 从而避免每次执行都重新解析语句.
+	参数的类型只能为uint32, uint64, int32, int64, float64, bool或string, nil表示NULL.
+	insert中的值和值表达式中的参数可以为NULL, where中的比较的参数不能为NULL.
+	参数的类型需要与其对应的字段相符: 整数可以作为任何数值, 其他的类型必须相同, 否则返回tbm.ErrParamType.
+*/
 type Executor interface {
 	Execute(sql []byte) ([]byte, error)
+	Prepare(sql []byte) (uint32, error)
+	ExecutePrepared(id uint32, params []interface{}) ([]byte, error)
+	ClosePrepared(id uint32) error
 	Close()
 }
 
+// prepared 为预编译的语句, params依次为stat中的各个占位符.
+type prepared struct {
+	stat   interface{}
+	params []*parser.Param
+}
+
 type executor struct {
 	xid tm.XID
 	tbm tbm.TableManager
+
+	stmts  map[uint32]*prepared
+	nextID uint32
 }
 
 func NewExecutor(tbm tbm.TableManager) *executor {
 	return &executor{
-		tbm: tbm,
+		tbm:   tbm,
+		stmts: make(map[uint32]*prepared),
 	}
 }
 
@@ -44,8 +67,69 @@ func (e *executor) Execute(sql []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	return e.execute(stat)
+}
+
+// Prepare 预编译sql, 并返回其id.
+func (e *executor) Prepare(sql []byte) (uint32, error) {
+	utils.Info("Prepare: ", string(sql))
+
+	stat, params, err := parser.ParsePrepared(sql)
+	if err != nil {
+		return 0, err
+	}
+	e.nextID++
+	e.stmts[e.nextID] = &prepared{stat: stat, params: params}
+	return e.nextID, nil
+}
+
+// ExecutePrepared 将params依次绑定到id对应语句的占位符上, 并执行该语句.
+func (e *executor) ExecutePrepared(id uint32, params []interface{}) ([]byte, error) {
+	p, ok := e.stmts[id]
+	if ok == false {
+		return nil, ErrNoThatStmt
+	}
+	if len(params) != len(p.params) {
+		return nil, ErrInvalidParams
+	}
+	for i, param := range params {
+		switch v := param.(type) {
+		case nil:
+			if err := p.params[i].SetNull(); err != nil {
+				return nil, err
+			}
+		case uint32:
+			p.params[i].Set(utils.Uint32ToStr(v), statement.KindUint)
+		case uint64:
+			p.params[i].Set(utils.Uint64ToStr(v), statement.KindUint)
+		case int32:
+			p.params[i].Set(utils.Int32ToStr(v), statement.KindInt)
+		case int64:
+			p.params[i].Set(utils.Int64ToStr(v), statement.KindInt)
+		case float64:
+			p.params[i].Set(utils.Float64ToStr(v), statement.KindFloat)
+		case bool:
+			p.params[i].Set(utils.BoolToStr(v), statement.KindBool)
+		case string:
+			p.params[i].Set(v, statement.KindString)
+		default:
+			return nil, ErrInvalidParams
+		}
+	}
+	return e.execute(p.stat)
+}
 
+func (e *executor) ClosePrepared(id uint32) error {
+	if _, ok := e.stmts[id]; ok == false {
+		return ErrNoThatStmt
+	}
+	delete(e.stmts, id)
+	return nil
+}
+
+func (e *executor) execute(stat interface{}) ([]byte, error) {
 	var result []byte
+	var err error
 	switch st := stat.(type) {
 	case *statement.Begin:
 		if e.xid != 0 {
@@ -97,9 +181,17 @@ func (e *executor) execute2(stat interface{}) ([]byte, error) {
 	var result []byte
 	switch st := stat.(type) {
 	case *statement.Show:
-		result = e.tbm.Show(e.xid)
+		result, err = e.tbm.Show(e.xid)
 	case *statement.Create:
 		result, err = e.tbm.Create(e.xid, st)
+	case *statement.Drop:
+		result, err = e.tbm.Drop(e.xid, st)
+	case *statement.Alter:
+		result, err = e.tbm.Alter(e.xid, st)
+	case *statement.CreateIndex:
+		result, err = e.tbm.CreateIndex(e.xid, st)
+	case *statement.DropIndex:
+		result, err = e.tbm.DropIndex(e.xid, st)
 	case *statement.Read:
 		result, err = e.tbm.Read(e.xid, st)
 	case *statement.Insert:
@@ -108,6 +200,8 @@ func (e *executor) execute2(stat interface{}) ([]byte, error) {
 		result, err = e.tbm.Delete(e.xid, st)
 	case *statement.Update:
 		result, err = e.tbm.Update(e.xid, st)
+	case *statement.Explain:
+		result, err = e.tbm.Explain(e.xid, st)
 	}
 
 	return result, err
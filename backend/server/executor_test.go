@@ -1,14 +1,21 @@
 package server_test
 
 import (
+	"errors"
+	"io/ioutil"
 	"nyadb2/backend/dm"
+	"nyadb2/backend/im"
+	"nyadb2/backend/parser"
 	"nyadb2/backend/server"
 	"nyadb2/backend/sm"
 	"nyadb2/backend/tbm"
 	"nyadb2/backend/tm"
 	"nyadb2/backend/utils"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 const (
@@ -115,3 +122,1409 @@ func TestInsert10000000With30(t *testing.T) {
 func TestInsert10000000With40(t *testing.T) {
 	testMultiInsert(10000000, 40, t)
 }
+
+// 之前的版本的Booter中只有第一个link的UUID, 打开这样的数据库时应该报ErrIncompatibleFormat.
+func TestIncompatibleFormat(t *testing.T) {
+	path := "/tmp/TestIncompatibleFormat"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	testExecute(t, server.NewExecutor(tbm0), "create table t id uint32")
+	tm0.Close()
+	dm0.Close()
+	if err := ioutil.WriteFile(path+".bt", utils.UUIDToRaw(utils.NilUUID), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := recover(); err != tbm.ErrIncompatibleFormat {
+			t.Fatal(err)
+		}
+	}()
+	testOpenTBM(path, false)
+}
+
+func testOpenTBM(path string, create bool) (tm.TransactionManager, dm.DataManager, tbm.TableManager) {
+	utils.LOG_LEVEL = utils.LOG_LEVEL_FATAL
+	if create {
+		tm := tm.Create(path)
+		dm := dm.Create(path, _DEFAULT_MEM, tm)
+		sm := sm.NewSerializabilityManager(tm, dm)
+		return tm, dm, tbm.Create(path, sm, dm)
+	}
+	tm := tm.Open(path)
+	dm := dm.Open(path, _DEFAULT_MEM, tm)
+	sm := sm.NewSerializabilityManager(tm, dm)
+	return tm, dm, tbm.Open(path, sm, dm)
+}
+
+func testExecute(t *testing.T, exe server.Executor, sql string) string {
+	result, err := exe.Execute([]byte(sql))
+	if err != nil {
+		t.Fatal(sql, ": ", err)
+	}
+	return string(result)
+}
+
+func testExecuteErr(t *testing.T, exe server.Executor, sql string) {
+	_, err := exe.Execute([]byte(sql))
+	if err == nil {
+		t.Fatal(sql, ": should fail")
+	}
+}
+
+func TestDropTable(t *testing.T) {
+	path := "/tmp/TestDropTable"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32 (index id)")
+	testExecute(t, exe, "create table s id uint32 (index id)")
+	testExecute(t, exe, "insert into t values 1")
+
+	testExecute(t, exe, "begin")
+	testExecute(t, exe, "drop table t")
+	testExecuteErr(t, exe, "read * from t")
+	testExecute(t, exe, "abort")
+	if testExecute(t, exe, "read * from t") != "[1]\n" {
+		t.Fatal("drop should be rolled back")
+	}
+
+	testExecute(t, exe, "drop table t")
+	testExecuteErr(t, exe, "read * from t")
+	testExecuteErr(t, exe, "drop table t")
+	testExecute(t, exe, "create table t id uint32 (index id)")
+	if testExecute(t, exe, "read * from t") != "" {
+		t.Fatal("table should be recreated")
+	}
+	testExecute(t, exe, "drop table s")
+	dm0.Close()
+	tm0.Close()
+
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe = server.NewExecutor(tbm1)
+	testExecuteErr(t, exe, "read * from s")
+	if testExecute(t, exe, "read * from t") != "" {
+		t.Fatal("table should be recreated")
+	}
+}
+
+func TestCreateTableVisibility(t *testing.T) {
+	path := "/tmp/TestCreateTableVisibility"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe1 := server.NewExecutor(tbm0)
+	exe2 := server.NewExecutor(tbm0)
+
+	testExecute(t, exe1, "begin")
+	testExecute(t, exe1, "create table t id uint32 (index id)")
+	testExecute(t, exe1, "insert into t values 1")
+	testExecuteErr(t, exe2, "read * from t")
+	testExecuteErr(t, exe2, "create table t id uint32 (index id)")
+	if testExecute(t, exe2, "show") != "" {
+		t.Fatal("uncommitted table should be invisible")
+	}
+	testExecute(t, exe1, "abort")
+	testExecuteErr(t, exe1, "read * from t")
+
+	testExecute(t, exe2, "begin")
+	testExecute(t, exe2, "create table t id uint32 (index id)")
+	testExecute(t, exe2, "insert into t values 2")
+	testExecute(t, exe2, "commit")
+	if testExecute(t, exe1, "read * from t") != "[2]\n" {
+		t.Fatal("committed table should be visible")
+	}
+
+	testExecute(t, exe1, "begin")
+	testExecute(t, exe1, "drop table t")
+	testExecute(t, exe1, "create table t name string (index name)")
+	testExecute(t, exe1, "insert into t values abc")
+	if testExecute(t, exe2, "read * from t") != "[2]\n" {
+		t.Fatal("old version should be visible before commit")
+	}
+	testExecute(t, exe1, "commit")
+	if testExecute(t, exe2, "read * from t") != "[abc]\n" {
+		t.Fatal("new version should be visible after commit")
+	}
+
+	testExecute(t, exe1, "begin")
+	testExecute(t, exe1, "create table s id uint32 (index id)")
+	dm0.Close() // 模拟在事务结束前关闭数据库
+	tm0.Close()
+
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe := server.NewExecutor(tbm1)
+	testExecuteErr(t, exe, "read * from s")
+	if testExecute(t, exe, "show") != "{t: (name, string, Index)}\n" {
+		t.Fatal("only committed tables should be loaded")
+	}
+}
+
+func TestWhereExpression(t *testing.T) {
+	path := "/tmp/TestWhereExpression"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, name string, age uint32 (index id name)")
+	testExecute(t, exe, "insert into t values 1 a 10")
+	testExecute(t, exe, "insert into t values 2 b 20")
+	testExecute(t, exe, "insert into t values 3 c 30")
+	testExecute(t, exe, "insert into t values 4 d 40")
+
+	cases := map[string]string{
+		"read * from t where id >= 3":                                "[3, c, 30]\n[4, d, 40]\n",
+		"read * from t where id <= 1 or id > 3":                      "[1, a, 10]\n[4, d, 40]\n",
+		"read * from t where id != 2 and age < 30":                   "[1, a, 10]\n",
+		"read * from t where not (id < 2 or id > 3)":                 "[2, b, 20]\n[3, c, 30]\n",
+		"read * from t where name = 'c' or age = 10":                 "[1, a, 10]\n[3, c, 30]\n",
+		"read * from t where (id > 1 and name >= 'c') or not id > 0": "[3, c, 30]\n[4, d, 40]\n",
+		"read * from t where id < 0":                                 "",
+	}
+	for sql, expected := range cases {
+		if result := testExecute(t, exe, sql); result != expected {
+			t.Fatal(sql, ": ", result)
+		}
+	}
+
+	testExecute(t, exe, "update t set age = 0 where age >= 20 and age <= 30")
+	testExecute(t, exe, "delete from t where name > 'b' and not age = 0")
+	if result := testExecute(t, exe, "read * from t where id > 0"); result != "[1, a, 10]\n[2, b, 0]\n[3, c, 0]\n" {
+		t.Fatal(result)
+	}
+	testExecuteErr(t, exe, "read * from t where nothing = 1")
+	testExecuteErr(t, exe, "read * from t where name > b") // 没有被引号括起来的b是字段名
+}
+
+func TestWhereFieldCompare(t *testing.T) {
+	path := "/tmp/TestWhereFieldCompare"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32, a int64, b uint32, x string, y string (index id)")
+	testExecute(t, exe, "insert into t values (1, 5, 3, a, b), (2, 2, 2, b, b), (3, -1, 4, c, a), (4, null, 1, d, null)")
+
+	cases := []struct {
+		sql    string
+		result string
+	}{
+		{"read id from t where a > b order by id", "[1]\n"},
+		{"read id from t where a <= b order by id", "[2]\n[3]\n"},
+		{"read id from t where not a > b order by id", "[2]\n[3]\n"},
+		{"read id from t where x < y order by id", "[1]\n"},
+		{"read id from t where x != y order by id", "[1]\n[3]\n"},
+		{"read id from t where id > b + 1 and id >= 2 order by id", "[4]\n"},
+		{"read id from t where x = lower('B') order by id", "[2]\n"},
+		{"read id from t where b >= -a + 2 order by id", "[1]\n[2]\n[3]\n"},
+	}
+	for _, c := range cases {
+		if result := testExecute(t, exe, c.sql); result != c.result {
+			t.Fatal(c.sql, ": ", result)
+		}
+	}
+
+	// 与字段的比较不能缩小索引上的区间, 其他的条件仍然可以
+	if result := testExecute(t, exe, "explain read * from t where id > b"); strings.Contains(result, "access: scan") == false {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "explain read * from t where id > b and id >= 2"); strings.Contains(result, "ranges: [2, +inf)") == false {
+		t.Fatal(result)
+	}
+
+	testExecute(t, exe, "update t set a = a + 1 where a < b")
+	testExecute(t, exe, "delete from t where x = y")
+	if result := testExecute(t, exe, "read * from t order by id"); result != "[1, 5, 3, a, b]\n[3, 0, 4, c, a]\n[4, NULL, 1, d, NULL]\n" {
+		t.Fatal(result)
+	}
+
+	testExecuteErr(t, exe, "read * from t where x > a")
+	testExecuteErr(t, exe, "read * from t where a > b / 0")
+	testExecuteErr(t, exe, "read * from t where a > z")
+}
+
+func TestTableWithoutIndex(t *testing.T) {
+	path := "/tmp/TestTableWithoutIndex"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, name string")
+	testExecute(t, exe, "insert into t values 1 a")
+	testExecute(t, exe, "insert into t values 2 b")
+	testExecute(t, exe, "insert into t values 3 c")
+
+	if result := testExecute(t, exe, "read * from t"); result != "[1, a]\n[2, b]\n[3, c]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe, "update t set name = 'z' where id = 2")
+	testExecute(t, exe, "delete from t where name = 'a'")
+	if result := testExecute(t, exe, "read * from t where id > 1"); result != "[3, c]\n[2, z]\n" {
+		t.Fatal(result)
+	}
+	dm0.Close()
+	tm0.Close()
+
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe = server.NewExecutor(tbm1)
+	if result := testExecute(t, exe, "read * from t where name >= 'c'"); result != "[3, c]\n[2, z]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestWhereRecheck(t *testing.T) {
+	path := "/tmp/TestWhereRecheck"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, name string (index name)")
+	// 两个name的前im.MAX_KEY_LEN个字节相同, 在索引中被截断为同一个key
+	prefix := strings.Repeat("x", im.MAX_KEY_LEN)
+	a, b := prefix+"a", prefix+"b"
+	testExecute(t, exe, "insert into t values 1 '"+a+"'")
+	testExecute(t, exe, "insert into t values 2 '"+b+"'")
+
+	if result := testExecute(t, exe, "read * from t where name = '"+a+"'"); result != "[1, "+a+"]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from t where not name = '"+a+"'"); result != "[2, "+b+"]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "delete from t where name = '"+a+"'"); result != "Delete 1" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "update t set id = 3 where name = '"+a+"'"); result != "Update 0" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from t"); result != "[2, "+b+"]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestStringIndexRange(t *testing.T) {
+	path := "/tmp/TestStringIndexRange"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, name string (index name)")
+	for i, name := range []string{"apple", "banana", "cherry", "date"} {
+		testExecute(t, exe, "insert into t values "+strconv.Itoa(i)+" '"+name+"'")
+	}
+
+	if result := testExecute(t, exe, "read * from t where name > 'banana' and name <= 'date'"); result != "[2, cherry]\n[3, date]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from t where name < 'b' or name >= 'dat'"); result != "[0, apple]\n[3, date]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from t where name != 'cherry' and name > 'apple'"); result != "[1, banana]\n[3, date]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestUpdateExpression(t *testing.T) {
+	path := "/tmp/TestUpdateExpression"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, count uint64, name string (index id count)")
+	testExecute(t, exe, "insert into t values 1 10 Alice")
+	testExecute(t, exe, "insert into t values 2 20 Bob")
+
+	if result := testExecute(t, exe, "update t set count = count * 2 + id, name = upper(name) + '!' where id = 1"); result != "Update 1" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from t where count = 21"); result != "[1, 21, ALICE!]\n" {
+		t.Fatal(result)
+	}
+	// 所有的set都基于原来的记录计算
+	testExecute(t, exe, "update t set id = count, count = id where id = 2")
+	if result := testExecute(t, exe, "read * from t where id = 20"); result != "[20, 2, Bob]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from t where id = 2"); result != "" {
+		t.Fatal(result)
+	}
+
+	testExecuteErr(t, exe, "update t set id = id - 100")
+	testExecuteErr(t, exe, "update t set count = count / (id - id)")
+	testExecuteErr(t, exe, "update t set name = name - 'a'")
+	testExecuteErr(t, exe, "update t set name = upper(id)")
+	testExecuteErr(t, exe, "update t set id = 1, id = 2")
+	testExecuteErr(t, exe, "update t set age = 1")
+
+	// 在事务中, 某一行出错的update不会修改任何一行, 事务可以继续并提交
+	testExecute(t, exe, "begin")
+	testExecuteErr(t, exe, "update t set count = count - 10")
+	testExecute(t, exe, "update t set name = 'x' where id = 20")
+	testExecute(t, exe, "commit")
+	if result := testExecute(t, exe, "read * from t where id >= 1"); result != "[1, 21, ALICE!]\n[20, 2, x]\n" {
+		t.Fatal(result)
+	}
+	// 删除旧版本之后才失败的update会让事务被自动回滚, 于是旧版本不会丢失
+	testExecute(t, exe, "begin")
+	testExecuteErr(t, exe, "update t set name = '"+strings.Repeat("x", 10000)+"' where id = 1")
+	testExecuteErr(t, exe, "commit")
+	testExecute(t, exe, "abort")
+	if result := testExecute(t, exe, "read * from t where id >= 1"); result != "[1, 21, ALICE!]\n[20, 2, x]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestInsertTuples(t *testing.T) {
+	path := "/tmp/TestInsertTuples"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, age uint64, name string (index id)")
+
+	if result := testExecute(t, exe, "insert into t (name, id) values ('a', 1), ('b', 2), ('c', 3)"); result != "Insert 3" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "insert into t values (4, 40, 'd')"); result != "Insert 1" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "insert into t values 5 50 e"); result != "Insert 1" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from t where id >= 2 and id <= 4"); result != "[2, 0, b]\n[3, 0, c]\n[4, 40, d]\n" {
+		t.Fatal(result)
+	}
+
+	// 任何一行有错误, 都不会插入任何一行
+	testExecuteErr(t, exe, "insert into t (id) values (6), (x)")
+	testExecuteErr(t, exe, "insert into t (id, name) values (6)")
+	testExecuteErr(t, exe, "insert into t (id, id) values (6, 7)")
+	testExecuteErr(t, exe, "insert into t (height) values (6)")
+	if result := testExecute(t, exe, "read * from t where id = 6"); result != "" {
+		t.Fatal(result)
+	}
+
+	// 在事务中, 插入到一半失败的语句也不会留下已经插入的行, 事务可以继续并提交
+	testExecute(t, exe, "create table u id uint32 unique, name string")
+	testExecute(t, exe, "begin")
+	testExecuteErr(t, exe, "insert into u values (1, a), (2, b), (1, c)")
+	testExecuteErr(t, exe, "insert into u values (3, c), (4, '"+strings.Repeat("x", 10000)+"')")
+	testExecute(t, exe, "insert into u values (1, d), (2, e)")
+	testExecute(t, exe, "commit")
+	if result := testExecute(t, exe, "read * from u"); result != "[1, d]\n[2, e]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestOrderByLimit(t *testing.T) {
+	path := "/tmp/TestOrderByLimit"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, age uint32, name string (index id name)")
+	testExecute(t, exe, "insert into t values (3, 20, c), (1, 30, a), (4, 20, d), (2, 10, b), (5, 30, e)")
+
+	cases := []struct {
+		sql    string
+		result string
+	}{
+		// 利用id的索引, 不需要排序
+		{"read * from t order by id", "[1, 30, a]\n[2, 10, b]\n[3, 20, c]\n[4, 20, d]\n[5, 30, e]\n"},
+		{"read * from t order by id desc limit 2", "[5, 30, e]\n[4, 20, d]\n"},
+		{"read * from t order by id limit 2 offset 1", "[2, 10, b]\n[3, 20, c]\n"},
+		{"read * from t where id >= 2 order by name desc limit 2", "[5, 30, e]\n[4, 20, d]\n"},
+		// age没有索引, 需要排序
+		{"read * from t order by age desc, id", "[1, 30, a]\n[5, 30, e]\n[3, 20, c]\n[4, 20, d]\n[2, 10, b]\n"},
+		{"read * from t where id != 1 order by age, name desc limit 3", "[2, 10, b]\n[4, 20, d]\n[3, 20, c]\n"},
+		{"read * from t where age = 20 order by id desc", "[4, 20, d]\n[3, 20, c]\n"},
+		{"read * from t order by id offset 10", ""},
+		{"read * from t limit 0", ""},
+	}
+	for _, c := range cases {
+		if result := testExecute(t, exe, c.sql); result != c.result {
+			t.Fatal(c.sql, ": ", result)
+		}
+	}
+
+	// 按页读取
+	var pages string
+	for offset := 0; offset < 5; offset += 2 {
+		pages += testExecute(t, exe, "read * from t order by name limit 2 offset "+strconv.Itoa(offset))
+	}
+	if pages != testExecute(t, exe, "read * from t order by name") {
+		t.Fatal(pages)
+	}
+
+	testExecuteErr(t, exe, "read * from t order by height")
+}
+
+func TestAggregate(t *testing.T) {
+	path := "/tmp/TestAggregate"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, class string, score uint64 (index id)")
+
+	// 空表上的聚合
+	if result := testExecute(t, exe, "read count(*), sum(score), max(class) from t"); result != "[0, NULL, NULL]\n" {
+		t.Fatal(result)
+	}
+
+	testExecute(t, exe, "insert into t values (1, a, 90), (2, b, 60), (3, a, 70), (4, c, 85), (5, b, 75), (6, a, 95)")
+
+	cases := []struct {
+		sql    string
+		result string
+	}{
+		{"read class, id from t where id <= 2", "[a, 1]\n[b, 2]\n"},
+		{"read id * 10 + 1, upper(class) from t where id = 3", "[31, A]\n"},
+		{"read count(*), sum(score), min(score), max(class), avg(score) from t", "[6, 475, 60, c, 79.16666666666667]\n"},
+		{"read count(*) from t where score >= 80", "[3]\n"},
+		{"read class, count(*), sum(score) from t group by class order by class", "[a, 3, 255]\n[b, 2, 135]\n[c, 1, 85]\n"},
+		{"read class, avg(score) from t group by class having avg(score) > 80 order by class desc", "[c, 85]\n[a, 85]\n"},
+		{"read class from t group by class having count(*) >= 2 and min(score) < 70", "[b]\n"},
+		{"read class, max(id) from t group by class order by class limit 1 offset 1", "[b, 5]\n"},
+		{"read upper(class), count(*) from t where id > 1 group by class having class != 'c' order by class", "[A, 2]\n[B, 2]\n"},
+	}
+	for _, c := range cases {
+		if result := testExecute(t, exe, c.sql); result != c.result {
+			t.Fatal(c.sql, ": ", result)
+		}
+	}
+
+	testExecuteErr(t, exe, "read class, id from t group by class")
+	testExecuteErr(t, exe, "read class, count(*) from t group by class order by id")
+	testExecuteErr(t, exe, "read * from t group by class")
+	testExecuteErr(t, exe, "read sum(class) from t")
+	testExecuteErr(t, exe, "read count(max(id)) from t")
+	testExecuteErr(t, exe, "read class from t group by class having max(class) > sum(score)")
+	testExecuteErr(t, exe, "read height from t")
+}
+
+func TestJoin(t *testing.T) {
+	path := "/tmp/TestJoin"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table student id uint32, name string, class uint32 (index id)")
+	testExecute(t, exe, "create table class id uint32, name string, teacher string (index id)")
+	testExecute(t, exe, "create table score sid uint32, score uint64 (index score)")
+	testExecute(t, exe, "insert into student values (1, tom, 1), (2, amy, 2), (3, bob, 1), (4, eve, 9)")
+	testExecute(t, exe, "insert into class values (1, math, lee), (2, art, kim), (3, music, kim)")
+	testExecute(t, exe, "insert into score values (1, 90), (3, 70), (1, 80)")
+
+	cases := []struct {
+		sql    string
+		result string
+	}{
+		// class.id有索引, 使用index nested loop join
+		{"read student.name, class.name from student join class on student.class = class.id",
+			"[tom, math]\n[amy, art]\n[bob, math]\n"},
+		{"read student.name, class.name from student left join class on class.id = student.class order by student.id desc",
+			"[eve, NULL]\n[bob, math]\n[amy, art]\n[tom, math]\n"},
+		{"read * from student inner join class on class = class.id where teacher = 'kim'",
+			"[2, amy, 2, 2, art, kim]\n"},
+		// score.sid没有索引, 使用hash join
+		{"read name, score from student join score on id = sid order by score",
+			"[bob, 70]\n[tom, 80]\n[tom, 90]\n"},
+		{"read name, count(score), sum(score) from student left join score on sid = id group by name order by name",
+			"[amy, 0, NULL]\n[bob, 1, 70]\n[eve, 0, NULL]\n[tom, 2, 170]\n"},
+		{"read class.name, score from class join student on student.class = class.id join score on sid = student.id where score > 75",
+			"[math, 90]\n[math, 80]\n"},
+		// where中对NULL的比较为false
+		{"read student.name from student left join class on class.id = student.class where class.name != 'math'",
+			"[amy]\n"},
+	}
+	for _, c := range cases {
+		if result := testExecute(t, exe, c.sql); result != c.result {
+			t.Fatal(c.sql, ": ", result)
+		}
+	}
+
+	testExecuteErr(t, exe, "read name from student join class on class = class.id")
+	testExecuteErr(t, exe, "read * from student join class on student.id = student.class")
+	testExecuteErr(t, exe, "read * from student join class on student.name = class.id")
+	testExecuteErr(t, exe, "read * from student join class on student.height = class.id")
+	testExecuteErr(t, exe, "read * from student join nothing on id = nothing.id")
+}
+
+func TestPrepared(t *testing.T) {
+	path := "/tmp/TestPrepared"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, name string, score uint64 (index id)")
+
+	insert, err := exe.Prepare([]byte("insert into t values (?, ?, ?)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"it's", "a \"b\"", "x, y"} {
+		_, err := exe.ExecutePrepared(insert, []interface{}{uint32(i), name, uint64(i * 10)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read, err := exe.Prepare([]byte("read name, score + ? from t where id >= ? order by id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := exe.ExecutePrepared(read, []interface{}{uint64(1), uint32(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "[a \"b\", 11]\n[x, y, 21]\n" {
+		t.Fatal(string(result))
+	}
+	result, err = exe.ExecutePrepared(read, []interface{}{uint64(0), uint32(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "[x, y, 20]\n" {
+		t.Fatal(string(result))
+	}
+
+	// 参数的个数或类型不对
+	if _, err = exe.ExecutePrepared(read, []interface{}{uint64(1)}); err == nil {
+		t.Fatal("Error")
+	}
+	if _, err = exe.ExecutePrepared(read, []interface{}{uint64(1), 1.5}); err == nil {
+		t.Fatal("Error")
+	}
+	if _, err = exe.ExecutePrepared(insert, []interface{}{"x", "y", uint64(1)}); err == nil {
+		t.Fatal("Error")
+	}
+
+	// 参数的类型需要与对应的字段相符, 不会被转换为字符串之后再重新解析
+	for _, params := range [][]interface{}{
+		{"5", "e", uint64(50)},
+		{uint32(5), int64(5), uint64(50)},
+		{uint32(5), "e", 50.0},
+		{uint32(5), "e", true},
+	} {
+		if _, err = exe.ExecutePrepared(insert, params); errors.Is(err, tbm.ErrParamType) == false {
+			t.Fatal(params, err)
+		}
+	}
+	if _, err = exe.ExecutePrepared(read, []interface{}{uint64(0), "1"}); errors.Is(err, tbm.ErrParamType) == false {
+		t.Fatal(err)
+	}
+	if _, err = exe.ExecutePrepared(read, []interface{}{"0", uint32(1)}); errors.Is(err, tbm.ErrParamType) == false {
+		t.Fatal(err)
+	}
+	result, err = exe.ExecutePrepared(read, []interface{}{int64(10), int32(2)}) // 整数之间可以互相转换
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "[x, y, 30]\n" {
+		t.Fatal(string(result))
+	}
+	update, err := exe.Prepare([]byte("update t set name = ? where id = ?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = exe.ExecutePrepared(update, []interface{}{int64(1), uint32(0)}); errors.Is(err, tbm.ErrParamType) == false {
+		t.Fatal(err)
+	}
+
+	// 绑定NULL, 之后再绑定其他值时不再为NULL
+	for _, params := range [][]interface{}{{uint32(3), nil, nil}, {uint32(4), "d", uint64(40)}} {
+		if _, err = exe.ExecutePrepared(insert, params); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err = exe.ExecutePrepared(read, []interface{}{nil, uint32(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "[NULL, NULL]\n[d, NULL]\n" {
+		t.Fatal(string(result))
+	}
+	if _, err = exe.ExecutePrepared(read, []interface{}{uint64(0), nil}); errors.Is(err, parser.ErrNullParam) == false {
+		t.Fatal(err)
+	}
+	result, err = exe.ExecutePrepared(read, []interface{}{uint64(1), uint32(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "[NULL, NULL]\n[d, 41]\n" {
+		t.Fatal(string(result))
+	}
+
+	if err = exe.ClosePrepared(read); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = exe.ExecutePrepared(read, []interface{}{uint64(1), uint32(1)}); err == nil {
+		t.Fatal("Error")
+	}
+	if _, err = exe.Prepare([]byte("read * from t where id = ? ?")); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	path := "/tmp/TestExplain"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id uint32, name string, age uint64 (index id name)")
+	testExecute(t, exe, "create table s tid uint32, score uint64")
+	testExecute(t, exe, "insert into t values (1, a, 10), (2, b, 20), (3, c, 30), (4, d, 40), (5, e, 50)")
+	testExecute(t, exe, "insert into s values (1, 90), (3, 80)")
+
+	cases := []struct {
+		sql    string
+		result string
+	}{
+		{"explain read * from t where id = 2 or id > 4",
+			"table: t\naccess: index id\nranges: [2, 2], (4, +inf)\nestimated rows: 2\nactual rows: 2\n"},
+		{"explain read * from t where id >= 2 and id < 4 and age != 20",
+			"table: t\naccess: index id\nranges: [2, 4)\nestimated rows: 2\nactual rows: 1\n"},
+		{"explain read * from t where name <= 'b'",
+			"table: t\naccess: index name\nranges: (-inf, 'b']\nestimated rows: 2\nactual rows: 2\n"},
+		{"explain read * from t where id = 1 and id = 2",
+			"table: t\naccess: index id\nranges: none\nestimated rows: 0\nactual rows: 0\n"},
+		{"explain read * from t where age > 20",
+			"table: t\naccess: scan\nestimated rows: 5\nactual rows: 3\n"},
+		// 按照索引的顺序读取时, actual rows也不受limit的影响
+		{"explain read * from t where id >= 2 order by id limit 1",
+			"table: t\naccess: index id\nranges: [2, +inf)\nestimated rows: 4\nactual rows: 4\n"},
+		{"explain read name, score from t join s on tid = id",
+			"table: t\naccess: scan\nestimated rows: 5\njoin: s on s.tid = t.id (hash)\nactual rows: 2\n"},
+		{"explain read name from s join t on id = tid where score > 85",
+			"table: s\naccess: scan\nestimated rows: 2\njoin: t on t.id = s.tid (index nested loop)\nactual rows: 1\n"},
+		{"explain update t set age = 0 where id <= 3",
+			"table: t\naccess: index id\nranges: (-inf, 3]\nestimated rows: 3\nactual rows: 3\n"},
+		{"explain delete from t where age = 10",
+			"table: t\naccess: scan\nestimated rows: 5\nactual rows: 1\n"},
+	}
+	for _, c := range cases {
+		if result := testExecute(t, exe, c.sql); result != c.result {
+			t.Fatal(c.sql, ": ", result)
+		}
+	}
+
+	// explain不会修改数据
+	if result := testExecute(t, exe, "read age from t where id = 1"); result != "[10]\n" {
+		t.Fatal(result)
+	}
+	testExecuteErr(t, exe, "explain read * from t where height = 1")
+	testExecuteErr(t, exe, "explain delete from nothing where id = 1")
+}
+
+func TestSignedTypes(t *testing.T) {
+	path := "/tmp/TestSignedTypes"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32, amount int64, price float64, ok bool (index id amount price ok)")
+	testExecute(t, exe, "insert into t values (1, -100, -2.5, true), (-2, 50, 0.25, false), (3, -9223372036854775808, 1e3, true), (-4, 0, -0, false)")
+
+	cases := []struct {
+		sql    string
+		result string
+	}{
+		// 索引上的区间, 负数排在前面
+		{"read id from t where id < 0 order by id", "[-4]\n[-2]\n"},
+		{"read id, amount from t where amount >= -100 and amount < 50 order by amount", "[1, -100]\n[-4, 0]\n"},
+		{"read id from t where price > -3 and price <= 0 order by price desc", "[-4]\n[1]\n"},
+		{"read id from t where price = 0", "[-4]\n"},
+		{"read id from t where ok = true order by id", "[1]\n[3]\n"},
+		{"read * from t where id = 3", "[3, -9223372036854775808, 1000, true]\n"},
+		{"read * from t order by price limit 1", "[1, -100, -2.5, true]\n"},
+		// 计算
+		{"read id * -2, price / 2, -price from t where id = 1", "[-2, -1.25, 2.5]\n"},
+		{"read sum(amount), avg(price), min(id), max(ok) from t where id != 3", "[-50, -0.75, -4, true]\n"},
+		{"read ok, sum(price) from t group by ok having sum(price) > 0 order by ok", "[false, 0.25]\n[true, 997.5]\n"},
+	}
+	for _, c := range cases {
+		if result := testExecute(t, exe, c.sql); result != c.result {
+			t.Fatal(c.sql, ": ", result)
+		}
+	}
+
+	testExecute(t, exe, "update t set id = id - 10, price = price * 2 where ok = false")
+	if result := testExecute(t, exe, "read id, price from t where id < -5 order by id"); result != "[-14, 0]\n[-12, 0.5]\n" {
+		t.Fatal(result)
+	}
+	// update留下的旧版本在提交之后已经从索引中被清理, 所以候选的记录数为1
+	if result := testExecute(t, exe, "explain read * from t where amount > -5 and amount <= 10"); result !=
+		"table: t\naccess: index amount\nranges: (-5, 10]\nestimated rows: 1\nactual rows: 1\n" {
+		t.Fatal(result)
+	}
+
+	testExecuteErr(t, exe, "update t set amount = amount - 1 where id = 3")
+	testExecuteErr(t, exe, "update t set id = 2147483647 + 1")
+	testExecuteErr(t, exe, "update t set price = price / 0")
+	testExecuteErr(t, exe, "update t set ok = ok + true")
+	testExecuteErr(t, exe, "insert into t values (1, 1, 1, yes)")
+	testExecuteErr(t, exe, "insert into t values (2147483648, 1, 1, true)")
+	testExecuteErr(t, exe, "read sum(ok) from t")
+	testExecuteErr(t, exe, "read * from t where price = nan")
+
+	// 字面值无法转换为字段的类型时, 返回TBM的错误, 而不是strconv的错误
+	for sql, expected := range map[string]error{
+		"read * from t where id = 1.5":                  tbm.ErrInvalidFieldValue,
+		"read * from t where id > -3000000000":          tbm.ErrOverflow,
+		"read * from t where amount < 1e30":             tbm.ErrInvalidFieldValue,
+		"insert into t values (2147483648, 1, 1, true)": tbm.ErrOverflow,
+		"insert into t values (1, 1, 1, yes)":           tbm.ErrInvalidFieldValue,
+		"update t set amount = 9223372036854775808":     tbm.ErrOverflow,
+		"update t set id = id + 1.5":                    tbm.ErrInvalidFieldValue,
+	} {
+		if _, err := exe.Execute([]byte(sql)); errors.Is(err, expected) == false {
+			t.Fatal(sql, err)
+		}
+	}
+}
+
+func TestNull(t *testing.T) {
+	path := "/tmp/TestNull"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32 not null, name string, score float64, ok bool (index id name score)")
+	testExecute(t, exe, "insert into t values (1, 'a', 90, true), (2, null, null, null), (3, 'c', null, false), (4, 'null', 60, NULL)")
+	testExecute(t, exe, "insert into t (id, name) values (5, NULL)") // 没有给出的字段依然取零值
+	testExecuteErr(t, exe, "insert into t values (null, 'x', 1, true)")
+	testExecuteErr(t, exe, "read * from t where name = null")
+	dm0.Close()
+	tm0.Close()
+
+	// 重新打开, 检查not null和null bitmap都被持久化了
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe = server.NewExecutor(tbm1)
+	testExecuteErr(t, exe, "update t set id = null where id = 1")
+
+	cases := []struct {
+		sql    string
+		result string
+	}{
+		{"read * from t where id = 2", "[2, NULL, NULL, NULL]\n"},
+		{"read id from t where name is null order by id", "[2]\n[5]\n"},
+		{"read id from t where name is not null order by id", "[1]\n[3]\n[4]\n"},
+		{"read id from t where score is null and ok is not null", "[3]\n"},
+		{"read id from t where score < 80 order by id", "[4]\n[5]\n"},
+		{"read id from t where name != 'a' order by id", "[3]\n[4]\n"},
+		{"read id from t where not (name = 'a') order by id", "[3]\n[4]\n"}, // not unknown仍为unknown
+		{"read id from t where not score = 90 order by id", "[4]\n[5]\n"},
+		{"read id from t where not ok = true order by id", "[3]\n[5]\n"},
+		{"read id from t where not (score = 90 or score is null) order by id", "[4]\n[5]\n"},
+		{"read id from t where not (name is not null) order by id", "[2]\n[5]\n"},
+		{"read id from t where not (score > 50 and name = 'null') order by id", "[1]\n[3]\n[5]\n"},
+		{"read id, name from t order by name, id", "[2, NULL]\n[5, NULL]\n[1, a]\n[3, c]\n[4, null]\n"},
+		{"read count(*), count(score), avg(score) from t", "[5, 3, 50]\n"},
+		{"read id, score + 1 from t where id <= 2 order by id", "[1, 91]\n[2, NULL]\n"},
+		{"explain read * from t where name is null", "table: t\naccess: index name\nranges: [NULL, NULL]\nestimated rows: 2\nactual rows: 2\n"},
+		{"explain read * from t where not score = 90", "table: t\naccess: index score\nranges: (-inf, 90), (90, +inf)\nestimated rows: 2\nactual rows: 2\n"},
+		{"explain read * from t where score <= 60", "table: t\naccess: index score\nranges: (-inf, 60]\nestimated rows: 2\nactual rows: 2\n"},
+	}
+	for _, c := range cases {
+		if result := testExecute(t, exe, c.sql); result != c.result {
+			t.Fatal(c.sql, ": ", result)
+		}
+	}
+
+	testExecute(t, exe, "update t set name = null, score = 0 where id = 1")
+	testExecute(t, exe, "update t set name = 'b' where id = 2")
+	if result := testExecute(t, exe, "read id, name, score from t where name is null order by id"); result != "[1, NULL, 0]\n[5, NULL, 0]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe, "delete from t where score is null")
+	if result := testExecute(t, exe, "read id from t order by id"); result != "[1]\n[4]\n[5]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	path := "/tmp/TestDefault"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32 default seq(), name string default 'anonymous', "+
+		"score float64 default null, ok bool not null default true, created int64 default now() (index id)")
+	testExecuteErr(t, exe, "create table s id int32 not null default null")
+	testExecuteErr(t, exe, "create table s id bool default seq()")
+	testExecuteErr(t, exe, "create table s id int32 default now()")
+	testExecuteErr(t, exe, "create table s id int32 default 'abc'")
+	testExecuteErr(t, exe, "create table s id int32 default rand()")
+	if result := testExecute(t, exe, "show"); result != "{t: (id, int32, Default seq(), Index), (name, string, Default anonymous, NoIndex), "+
+		"(score, float64, Default NULL, NoIndex), (ok, bool, NotNull, Default true, NoIndex), (created, int64, Default now(), NoIndex)}\n" {
+		t.Fatal(result)
+	}
+
+	testExecute(t, exe, "insert into t (name) values ('a'), ('b')")
+	testExecute(t, exe, "begin")
+	testExecute(t, exe, "insert into t (score) values (1.5)")
+	testExecute(t, exe, "abort")
+	testExecute(t, exe, "insert into t (id, name, ok) values (100, 'c', false)") // 给出的值不会消耗序列
+	dm0.Close()
+	tm0.Close()
+
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe = server.NewExecutor(tbm1)
+	testExecute(t, exe, "insert into t (score, created) values (2.5, 7)")
+
+	// 回滚的insert分配的值不会被收回
+	if result := testExecute(t, exe, "read id, name, score, ok from t order by id"); result !=
+		"[1, a, NULL, true]\n[2, b, NULL, true]\n[4, anonymous, 2.5, true]\n[100, c, NULL, false]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read count(*) from t where created > 1600000000 or created = 7"); result != "[4]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestUniqueKey(t *testing.T) {
+	path := "/tmp/TestUniqueKey"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32 primary key, email string unique, name string")
+	testExecuteErr(t, exe, "create table s id int32 primary key, uid int32 primary key")
+	if result := testExecute(t, exe, "show"); result !=
+		"{t: (id, int32, PrimaryKey, Index), (email, string, Unique, Index), (name, string, NoIndex)}\n" {
+		t.Fatal(result)
+	}
+
+	testExecute(t, exe, "insert into t values (1, 'a@x', 'a'), (2, null, 'b'), (3, null, 'c')") // 多个NULL不算重复
+	testExecuteErr(t, exe, "insert into t values (1, 'b@x', 'x')")
+	testExecuteErr(t, exe, "insert into t values (4, 'a@x', 'x')")
+	testExecuteErr(t, exe, "insert into t values (null, 'd@x', 'x')")
+	testExecuteErr(t, exe, "insert into t values (4, 'd@x', 'x'), (4, 'e@x', 'x')")
+	testExecute(t, exe, "update t set name = 'aa', id = 1 where id = 1")
+
+	// 失败的update不会留下被删除的记录
+	testExecute(t, exe, "begin")
+	testExecuteErr(t, exe, "update t set email = 'a@x' where id = 2")
+	testExecute(t, exe, "commit")
+	if result := testExecute(t, exe, "read id, email from t order by id"); result != "[1, a@x]\n[2, NULL]\n[3, NULL]\n" {
+		t.Fatal(result)
+	}
+
+	// unique以整条update执行之后的状态为准, 任何一行重复时, 所有的行都不会被修改
+	testExecute(t, exe, "create table u id int32 unique")
+	testExecute(t, exe, "insert into u values (1), (5), (6)")
+	testExecute(t, exe, "begin")
+	testExecuteErr(t, exe, "update u set id = id + 1 where id != 6")
+	testExecuteErr(t, exe, "update u set id = 7")
+	testExecute(t, exe, "commit")
+	if result := testExecute(t, exe, "read id from u order by id"); result != "[1]\n[5]\n[6]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "update u set id = id + 1"); result != "Update 3" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read id from u order by id"); result != "[2]\n[6]\n[7]\n" {
+		t.Fatal(result)
+	}
+
+	// 删除之后可以再插入相同的值
+	testExecute(t, exe, "begin")
+	testExecute(t, exe, "delete from t where id = 3")
+	testExecute(t, exe, "insert into t values (3, 'c@x', 'cc')")
+	testExecute(t, exe, "commit")
+
+	// 其他事务插入的值即使不可见, 也会造成冲突
+	exe2 := server.NewExecutor(tbm0)
+	testExecute(t, exe, "begin isolation level repeatable read")
+	testExecute(t, exe2, "insert into t values (10, 'j@x', 'j')")
+	if result := testExecute(t, exe, "read * from t where id = 10"); result != "" {
+		t.Fatal(result)
+	}
+	testExecuteErr(t, exe, "insert into t values (10, 'k@x', 'k')")
+	testExecute(t, exe, "abort")
+
+	// 并发插入相同的值时, 后插入的事务会等待先插入的事务结束
+	for _, end := range []string{"abort", "commit"} {
+		testExecute(t, exe, "begin")
+		testExecute(t, exe, "insert into t values (20, 't@x', 't')")
+		done := make(chan error)
+		go func() {
+			_, err := exe2.Execute([]byte("insert into t values (20, 'u@x', 'u')"))
+			done <- err
+		}()
+		select {
+		case <-done:
+			t.Fatal("insert should wait")
+		case <-time.After(100 * time.Millisecond):
+		}
+		testExecute(t, exe, end)
+		err := <-done
+		if (end == "abort") != (err == nil) {
+			t.Fatal(end, err)
+		}
+		if end == "abort" {
+			testExecute(t, exe, "delete from t where id = 20")
+		}
+	}
+	if result := testExecute(t, exe, "read id, name from t where id >= 10 order by id"); result != "[10, j]\n[20, t]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestForeignKey(t *testing.T) {
+	path := "/tmp/TestForeignKey"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table p id int32 primary key, name string")
+	testExecute(t, exe, "create table c id int32, pid int32 references p(id) on delete cascade on update cascade")
+	testExecute(t, exe, "create table s id int32, pid int32 references p(id) on delete set null")
+	testExecute(t, exe, "create table r id int32, pid int32 references p(id)")
+	for _, stat := range []string{
+		"create table x pname string references p(name)",
+		"create table x pid int64 references p(id)",
+		"create table x pid int32 not null references p(id) on delete set null",
+		"create table x pid int32 references q(id)",
+		"create table x pid int32 references p(age)",
+	} {
+		testExecuteErr(t, exe, stat)
+	}
+	if result := testExecute(t, exe, "read * from p"); result != "" {
+		t.Fatal(result)
+	}
+
+	testExecute(t, exe, "insert into p values (1, 'a'), (2, 'b'), (3, 'c')")
+	testExecute(t, exe, "insert into c values (10, 1), (11, 2), (12, null)")
+	testExecuteErr(t, exe, "insert into c values (13, 9)")
+	testExecuteErr(t, exe, "update c set pid = 9 where id = 10")
+	testExecute(t, exe, "insert into s values (20, 1)")
+	testExecute(t, exe, "insert into r values (30, 3)")
+
+	testExecuteErr(t, exe, "delete from p where id = 3")
+	testExecuteErr(t, exe, "update p set id = 4 where id = 3")
+	testExecute(t, exe, "update p set name = 'cc' where id = 3") // 被引用的值没有改变
+	testExecute(t, exe, "update p set id = 5 where id = 2")
+	testExecute(t, exe, "delete from p where id = 1")
+	if result := testExecute(t, exe, "read * from c order by id"); result != "[11, 5]\n[12, NULL]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from s"); result != "[20, NULL]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from p order by id"); result != "[3, cc]\n[5, b]\n" {
+		t.Fatal(result)
+	}
+
+	// restrict在删除任何一行之前检查, 失败的delete不会删除任何一行, 也不会cascade
+	testExecute(t, exe, "insert into p values (9, 'z')")
+	testExecute(t, exe, "insert into r values (31, 9)")
+	testExecute(t, exe, "begin")
+	testExecuteErr(t, exe, "delete from p where id >= 5")
+	testExecute(t, exe, "commit")
+	if result := testExecute(t, exe, "read * from p order by id"); result != "[3, cc]\n[5, b]\n[9, z]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read * from c order by id"); result != "[11, 5]\n[12, NULL]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe, "delete from r where id = 31")
+	testExecute(t, exe, "delete from p where id = 9")
+
+	testExecuteErr(t, exe, "drop table p")
+	testExecute(t, exe, "begin")
+	for _, name := range []string{"c", "s", "r", "p"} {
+		testExecute(t, exe, "drop table "+name)
+	}
+	testExecute(t, exe, "abort")
+
+	// 引用自身的表
+	testExecute(t, exe, "create table e id int32 primary key, boss int32 references e(id) on delete cascade")
+	testExecute(t, exe, "insert into e values (1, 1), (2, 1), (3, 2)")
+	testExecuteErr(t, exe, "insert into e values (4, 5)")
+	testExecute(t, exe, "delete from e where id = 1")
+	if result := testExecute(t, exe, "read count(*) from e"); result != "[0]\n" {
+		t.Fatal(result)
+	}
+
+	// 插入引用尚未提交的值的记录时, 会等待插入该值的事务结束
+	exe2 := server.NewExecutor(tbm0)
+	for _, end := range []string{"abort", "commit"} {
+		testExecute(t, exe, "begin")
+		testExecute(t, exe, "insert into p values (7, 'x')")
+		done := make(chan error)
+		go func() {
+			_, err := exe2.Execute([]byte("insert into r values (40, 7)"))
+			done <- err
+		}()
+		select {
+		case <-done:
+			t.Fatal("insert should wait")
+		case <-time.After(100 * time.Millisecond):
+		}
+		testExecute(t, exe, end)
+		err := <-done
+		if (end == "commit") != (err == nil) {
+			t.Fatal(end, err)
+		}
+	}
+	testExecuteErr(t, exe, "delete from p where id = 7")
+}
+
+func TestCheck(t *testing.T) {
+	path := "/tmp/TestCheck"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32, qty int32 check (qty >= 0) check (qty < 100), "+
+		"price float64, constraint cost check (price * 2 <= 100 or name is null), name string")
+	testExecuteErr(t, exe, "create table s id int32 check (name > 0)")
+	testExecuteErr(t, exe, "create table s id int32 check (id > 'a')")
+	testExecuteErr(t, exe, "create table s id int32 check (sum(id) > 0)")
+	testExecuteErr(t, exe, "create table s id int32 constraint c check (id > 0), constraint c check (id < 9)")
+	if result := testExecute(t, exe, "show"); result != "{t: (id, int32, NoIndex), (qty, int32, NoIndex), "+
+		"(price, float64, NoIndex), (name, string, NoIndex), Check t_qty_check (qty >= 0), "+
+		"Check t_qty_check1 (qty < 100), Check cost (price * 2 <= 100 or name is null)}\n" {
+		t.Fatal(result)
+	}
+
+	testExecute(t, exe, "insert into t values (1, 10, 2.5, 'a'), (2, 50, 100, null)")
+	for stat, name := range map[string]string{
+		"insert into t values (3, -1, 0, 'c')":     "t_qty_check",
+		"insert into t values (3, 100, 0, 'c')":    "t_qty_check1",
+		"insert into t values (3, 10, 200, 'c')":   "cost",
+		"update t set qty = qty - 20 where id = 1": "t_qty_check",
+		"update t set name = 'b' where id = 2":     "cost",
+		"update t set price = 60 where id = 1":     "cost",
+	} {
+		_, err := exe.Execute([]byte(stat))
+		var ce *tbm.CheckError
+		if errors.As(err, &ce) == false || ce.Name != name || errors.Is(err, tbm.ErrCheckViolated) == false {
+			t.Fatal(stat, err)
+		}
+	}
+	testExecute(t, exe, "update t set qty = qty + 10 where id = 1")
+	// 结果为unknown的约束被视为满足
+	testExecute(t, exe, "insert into t (id, price, name) values (4, null, 'd')")
+	testExecute(t, exe, "insert into t values (6, null, 1, 'f')")
+	testExecute(t, exe, "create table s id int32, v int32 check (not (v > 10))")
+	testExecute(t, exe, "insert into s values (1, null)")
+	testExecuteErr(t, exe, "insert into s values (2, 11)")
+	dm0.Close()
+	tm0.Close()
+
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe = server.NewExecutor(tbm1)
+	testExecuteErr(t, exe, "insert into t values (5, 99, 60, 'e')")
+	testExecute(t, exe, "insert into t values (5, 99, 10, 'e')")
+	if result := testExecute(t, exe, "read id, qty from t order by id"); result != "[1, 20]\n[2, 50]\n[4, 0]\n[5, 99]\n[6, NULL]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestAlter(t *testing.T) {
+	path := "/tmp/TestAlter"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe1 := server.NewExecutor(tbm0)
+	exe2 := server.NewExecutor(tbm0)
+	testExecute(t, exe1, "create table p id int32 unique")
+	testExecute(t, exe1, "create table t id int32, pid int32 references p(id), qty int32 check (qty >= 0), name string (index id)")
+	testExecute(t, exe1, "insert into p values 1")
+	testExecute(t, exe1, "insert into t values (1, 1, 10, 'a'), (2, null, 20, 'b')")
+
+	testExecuteErr(t, exe1, "alter table p drop id")                     // 被外键引用
+	testExecuteErr(t, exe1, "alter table p add id int32")                // 重名
+	testExecuteErr(t, exe1, "alter table t drop age")                    // 没有该字段
+	testExecuteErr(t, exe1, "alter table t add age int32 unique")        // 需要检查已有的记录
+	testExecuteErr(t, exe1, "alter table t add age int32 not null")      // 已有的记录为NULL
+	testExecuteErr(t, exe1, "alter table t add age int64 default now()") // 只能是字面值
+	testExecuteErr(t, exe1, "alter table s add age int32")
+	testExecute(t, exe1, "create table one id int32")
+	testExecuteErr(t, exe1, "alter table one drop id")
+
+	testExecute(t, exe1, "alter table t add column age int32 not null default 18")
+	testExecute(t, exe1, "alter table t add score float64")
+	testExecute(t, exe1, "insert into t values (3, 1, 30, 'c', 20, 99.5)")
+	testExecute(t, exe1, "alter table t drop column qty")
+	testExecute(t, exe1, "insert into t values (4, null, 'd', 21, 60)")
+	if result := testExecute(t, exe1, "show"); strings.Contains(result, "(id, int32, Index), (pid, int32, References p(id), Index), "+
+		"(name, string, NoIndex), (age, int32, NotNull, Default 18, NoIndex), (score, float64, NoIndex)}") == false ||
+		strings.Contains(result, "Check") {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe1, "read * from t order by id"); result != "[1, 1, a, 18, NULL]\n[2, NULL, b, 18, NULL]\n"+
+		"[3, 1, c, 20, 99.5]\n[4, NULL, d, 21, 60]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe1, "update t set age = age + 1 where id = 1")
+	if result := testExecute(t, exe1, "read id, age from t where age < 20 order by id"); result != "[1, 19]\n[2, 18]\n" {
+		t.Fatal(result)
+	}
+
+	// alter受事务的控制
+	testExecute(t, exe1, "begin")
+	testExecute(t, exe1, "alter table t drop name")
+	testExecute(t, exe1, "insert into t values (5, 1, 22, 70)")
+	if result := testExecute(t, exe2, "read id, name from t where id = 1"); result != "[1, a]\n" {
+		t.Fatal(result)
+	}
+	testExecuteErr(t, exe1, "read name from t")
+	testExecute(t, exe1, "abort")
+	if result := testExecute(t, exe1, "read id, name from t order by id"); result != "[1, a]\n[2, b]\n[3, c]\n[4, d]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe1, "alter table t drop score")
+	testExecute(t, exe1, "insert into t values (6, 1, 'f', 30)")
+
+	// 之前开始的repeatable read的事务不能再访问被修改的表, 需要重新开始
+	testExecute(t, exe2, "begin isolation level repeatable read")
+	testExecute(t, exe2, "read id from t where id = 1")
+	testExecute(t, exe1, "alter table t add score int32")
+	if _, err := exe2.Execute([]byte("read id from t where id = 1")); err != tbm.ErrTableChanged {
+		t.Fatal(err)
+	}
+	if _, err := exe2.Execute([]byte("insert into t values (7, 1, 'g', 30)")); err != tbm.ErrTableChanged {
+		t.Fatal(err)
+	}
+	if result := testExecute(t, exe2, "show"); strings.Contains(result, "score") {
+		t.Fatal(result)
+	}
+	testExecute(t, exe2, "abort")
+	testExecute(t, exe2, "begin isolation level repeatable read")
+	if result := testExecute(t, exe2, "read id, score from t where id = 1"); result != "[1, NULL]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe2, "commit")
+	testExecute(t, exe1, "alter table t drop score")
+	dm0.Close()
+	tm0.Close()
+
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe := server.NewExecutor(tbm1)
+	testExecuteErr(t, exe, "insert into t values (7, 2, 'g', 30)")
+	if result := testExecute(t, exe, "read * from t order by id"); result != "[1, 1, a, 19]\n[2, NULL, b, 18]\n"+
+		"[3, 1, c, 20]\n[4, NULL, d, 21]\n[6, 1, f, 30]\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "read name from t where id = 6"); result != "[f]\n" {
+		t.Fatal(result)
+	}
+}
+
+func TestCreateIndex(t *testing.T) {
+	path := "/tmp/TestCreateIndex"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	exe := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32 unique, name string, age int32")
+	for i := 0; i < 200; i++ {
+		n := strconv.Itoa(i)
+		testExecute(t, exe, "insert into t values ("+n+", 'n"+n+"', "+strconv.Itoa(i%50)+")")
+	}
+	testExecute(t, exe, "update t set age = age + 50 where id < 20")
+	testExecute(t, exe, "delete from t where id >= 190")
+	testExecuteErr(t, exe, "create index on t(id)")
+	testExecuteErr(t, exe, "create index on t(score)")
+	testExecuteErr(t, exe, "create index on s(age)")
+	testExecuteErr(t, exe, "drop index on t(id)")
+	testExecuteErr(t, exe, "drop index on t(age)")
+
+	// 建立索引期间, 其他事务继续插入和更新
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			writer := server.NewExecutor(tbm0)
+			for i := 0; i < 50; i++ {
+				id := strconv.Itoa(1000 + w*100 + i)
+				sqls := []string{
+					"insert into t values (" + id + ", 'w" + id + "', " + strconv.Itoa(i) + ")",
+					"update t set age = age + 1 where id = " + strconv.Itoa(w*40+i%40),
+				}
+				for _, sql := range sqls {
+					if _, err := writer.Execute([]byte(sql)); err != nil {
+						t.Error(sql, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	testExecute(t, exe, "create index on t(age)")
+	wg.Wait()
+
+	scan := testExecute(t, exe, "read id, age from t where id >= 0 order by id")
+	if result := testExecute(t, exe, "read id, age from t where age >= 0 order by id"); result != scan {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "explain read * from t where age = 3"); strings.Contains(result, "access: index age") == false {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe, "show"); result != "{t: (id, int32, Unique, Index), (name, string, NoIndex), (age, int32, Index)}\n" {
+		t.Fatal(result)
+	}
+
+	// 回滚之后索引被移除, 其他事务在此期间插入的记录不受影响
+	exe2 := server.NewExecutor(tbm0)
+	testExecute(t, exe, "begin")
+	testExecute(t, exe, "create index on t(name)")
+	if result := testExecute(t, exe2, "explain read * from t where name = 'n1'"); strings.Contains(result, "access: scan") == false {
+		t.Fatal(result)
+	}
+	testExecute(t, exe2, "insert into t values (2000, 'x', 1)")
+	if result := testExecute(t, exe, "read id from t where name = 'n1'"); result != "[1]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe, "abort")
+	if result := testExecute(t, exe, "explain read * from t where name = 'x'"); strings.Contains(result, "access: scan") == false {
+		t.Fatal(result)
+	}
+
+	// 之前开始的repeatable read的事务不能再通过没有新索引的版本读写该表, 需要重新开始
+	testExecute(t, exe2, "begin isolation level repeatable read")
+	testExecute(t, exe2, "read id from t where id = 1")
+	testExecute(t, exe, "create index on t(name)")
+	if _, err := exe2.Execute([]byte("insert into t values (2001, 'z', 1)")); err != tbm.ErrTableChanged {
+		t.Fatal(err)
+	}
+	if _, err := exe2.Execute([]byte("read id from t where name = 'n1'")); err != tbm.ErrTableChanged {
+		t.Fatal(err)
+	}
+	testExecute(t, exe2, "abort")
+	testExecute(t, exe2, "begin isolation level repeatable read")
+	if result := testExecute(t, exe2, "explain read * from t where name = 'n1'"); strings.Contains(result, "access: index name") == false {
+		t.Fatal(result)
+	}
+	testExecute(t, exe2, "commit")
+	testExecute(t, exe, "drop index on t(name)")
+
+	testExecute(t, exe, "drop index on t(age)")
+	if result := testExecute(t, exe, "explain read * from t where age = 3"); strings.Contains(result, "access: scan") == false {
+		t.Fatal(result)
+	}
+	testExecute(t, exe, "alter table t add score int32 default 60")
+	testExecute(t, exe, "create index on t(name)")
+	testExecute(t, exe, "insert into t values (3000, 'y', 2, 70)")
+	dm0.Close()
+	tm0.Close()
+
+	tm1, dm1, tbm1 := testOpenTBM(path, false)
+	defer tm1.Close()
+	defer dm1.Close()
+	exe = server.NewExecutor(tbm1)
+	if result := testExecute(t, exe, "show"); result != "{t: (id, int32, Unique, Index), (name, string, Index), "+
+		"(age, int32, NoIndex), (score, int32, Default 60, NoIndex)}\n" {
+		t.Fatal(result)
+	}
+	for sql, expected := range map[string]string{
+		"read id, name, score from t where name = 'n7'": "[7, n7, 60]\n",
+		"read id, name, score from t where name = 'x'":  "[2000, x, 60]\n",
+		"read id, name, score from t where name = 'y'":  "[3000, y, 70]\n",
+		"read count(*) from t where name >= ''":         "[392]\n",
+	} {
+		if result := testExecute(t, exe, sql); result != expected {
+			t.Fatal(sql, result)
+		}
+	}
+}
+
+// 删除了原来的表记录之后, 修改表结构失败的事务只能被回滚, 否则提交之后该表就会丢失.
+func TestTableChangeFailed(t *testing.T) {
+	path := "/tmp/TestTableChangeFailed"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+
+	// 找出最长的check, 使得表记录恰好能放入一页, 之后任何新的版本都放不下
+	n := 8192
+	for ; n > 0; n-- {
+		create := "create table t id int32, name string check (name != '" + strings.Repeat("x", n) + "')"
+		if _, err := exe.Execute([]byte(create)); err == nil {
+			break
+		}
+	}
+	testExecute(t, exe, "insert into t values (1, 'a'), (2, 'a')")
+
+	for _, sql := range []string{"create index on t(name)", "alter table t add age int32"} {
+		testExecute(t, exe, "begin")
+		testExecuteErr(t, exe, sql)
+		testExecuteErr(t, exe, "insert into t values (3, 'b')")
+		testExecuteErr(t, exe, "commit")
+		testExecute(t, exe, "abort")
+		if result := testExecute(t, exe, "read * from t order by id"); result != "[1, a]\n[2, a]\n" {
+			t.Fatal(sql, result)
+		}
+	}
+	testExecuteErr(t, exe, "create index on t(name)")
+	if result := testExecute(t, exe, "explain read * from t where name = 'a'"); strings.Contains(result, "access: scan") == false {
+		t.Fatal(result)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	path := "/tmp/TestVacuum"
+	tm0, dm0, tbm0 := testOpenTBM(path, true)
+	defer tm0.Close()
+	defer dm0.Close()
+	exe := server.NewExecutor(tbm0)
+	exe2 := server.NewExecutor(tbm0)
+	testExecute(t, exe, "create table t id int32, v int32, name string (index id v)")
+	for i := 1; i <= 10; i++ {
+		n := strconv.Itoa(i)
+		testExecute(t, exe, "insert into t values ("+n+", "+n+", 'n"+n+"')")
+	}
+	for i := 0; i < 3; i++ {
+		testExecute(t, exe, "update t set v = v + 10 where id <= 5")
+	}
+	testExecute(t, exe, "delete from t where id > 8")
+
+	// 被删除的记录和update之前的版本已经从行目录和索引中清理
+	estimated := func(where string) string {
+		result := testExecute(t, exe, "explain read * from t where "+where)
+		return result[strings.Index(result, "estimated rows: "):]
+	}
+	for where, expected := range map[string]string{
+		"id >= 0":         "estimated rows: 8\nactual rows: 8\n",
+		"v >= 0":          "estimated rows: 8\nactual rows: 8\n",
+		"name >= 'n'":     "estimated rows: 8\nactual rows: 8\n",
+		"v = 31":          "estimated rows: 1\nactual rows: 1\n",
+		"v = 1 or v > 40": "estimated rows: 0\nactual rows: 0\n",
+	} {
+		if result := estimated(where); result != expected {
+			t.Fatal(where, result)
+		}
+	}
+
+	// repeatable read的事务还能看到的版本不会被清理
+	testExecute(t, exe2, "begin isolation level repeatable read")
+	testExecute(t, exe2, "read * from t where id = 1")
+	testExecute(t, exe, "update t set v = 100 where id = 1")
+	testExecute(t, exe, "delete from t where id = 2")
+	if result := estimated("id <= 2"); result != "estimated rows: 3\nactual rows: 1\n" {
+		t.Fatal(result)
+	}
+	if result := testExecute(t, exe2, "read id, v from t where id <= 2"); result != "[1, 31]\n[2, 32]\n" {
+		t.Fatal(result)
+	}
+	testExecute(t, exe2, "commit")
+	if result := estimated("id <= 2"); result != "estimated rows: 1\nactual rows: 1\n" {
+		t.Fatal(result)
+	}
+
+	// 回滚的删除不会被清理
+	testExecute(t, exe, "begin")
+	testExecute(t, exe, "delete from t where id = 3")
+	testExecute(t, exe, "abort")
+	if result := testExecute(t, exe, "read id, v from t where v = 33"); result != "[3, 33]\n" {
+		t.Fatal(result)
+	}
+	if result := estimated("id >= 0"); result != "estimated rows: 7\nactual rows: 7\n" {
+		t.Fatal(result)
+	}
+}
@@ -67,9 +67,7 @@ func (s *server) serve(conn net.Conn) {
 			break
 		}
 
-		sql := pkg.Data()
-		result, sqlErr := exe.Execute(sql)
-		pkg = transporter.NewPackage(result, sqlErr)
+		pkg = execute(exe, pkg)
 
 		netErr = packager.Send(pkg)
 		if netErr != nil {
@@ -77,3 +75,35 @@ func (s *server) serve(conn net.Conn) {
 		}
 	}
 }
+
+// execute 根据包的类型, 让exe执行包中的请求, 并返回作为回复的包.
+func execute(exe Executor, pkg transporter.Package) transporter.Package {
+	var result []byte
+	var err error
+	switch pkg.Type() {
+	case transporter.PKG_DATA:
+		result, err = exe.Execute(pkg.Data())
+	case transporter.PKG_PREPARE:
+		var id uint32
+		id, err = exe.Prepare(pkg.Data())
+		if err == nil {
+			result = transporter.EncodeStmtID(id)
+		}
+	case transporter.PKG_EXECUTE:
+		var id uint32
+		var params []interface{}
+		id, params, err = transporter.DecodeExecute(pkg.Data())
+		if err == nil {
+			result, err = exe.ExecutePrepared(id, params)
+		}
+	case transporter.PKG_CLOSE:
+		var id uint32
+		id, err = transporter.DecodeStmtID(pkg.Data())
+		if err == nil {
+			err = exe.ClosePrepared(id)
+		}
+	default:
+		err = transporter.ErrInvalidPkgData
+	}
+	return transporter.NewPackage(result, err)
+}
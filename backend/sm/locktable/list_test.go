@@ -0,0 +1,24 @@
+package locktable
+
+import (
+	"container/list"
+	"nyadb2/backend/utils"
+	"testing"
+)
+
+func TestRemoveFromList(t *testing.T) {
+	listMap := make(map[utils.UUID]*list.List)
+	putIntoList(listMap, 1, 10)
+	putIntoList(listMap, 1, 11)
+	putIntoList(listMap, 1, 12)
+
+	removeFromList(listMap, 1, 10) // 不在表头的元素
+	if isInList(listMap, 1, 10) || !isInList(listMap, 1, 11) || !isInList(listMap, 1, 12) {
+		t.Fatal("Error")
+	}
+	removeFromList(listMap, 1, 11)
+	removeFromList(listMap, 1, 12)
+	if _, ok := listMap[1]; ok {
+		t.Fatal("Error")
+	}
+}
@@ -128,11 +128,12 @@ func (lt *lockTable) selectNewXID(uid utils.UUID) {
 		if _, ok := lt.waitCh[xid]; ok == false { // 有可能该事务已经被撤销
 			continue
 		} else {
-			lt.u2x[uid] = xid      // 将该uid指向xid
-			ch := lt.waitCh[xid]   // 对xid进行回应
-			delete(lt.waitCh, xid) // 删除该xid的等待通道
-			delete(lt.xwaitu, xid) // 删除xid对uid的等待关系
-			ch <- struct{}{}       // 回应
+			lt.u2x[uid] = xid             // 将该uid指向xid
+			putIntoList(lt.x2u, xid, uid) // 让该xid包含该uid
+			ch := lt.waitCh[xid]          // 对xid进行回应
+			delete(lt.waitCh, xid)        // 删除该xid的等待通道
+			delete(lt.xwaitu, xid)        // 删除xid对uid的等待关系
+			ch <- struct{}{}              // 回应
 			break
 		}
 	}
@@ -192,6 +193,7 @@ func removeFromList(listMap map[utils.UUID]*list.List, uid0, uid1 utils.UUID) {
 			l.Remove(e)
 			break
 		}
+		e = e.Next()
 	}
 	if l.Len() == 0 {
 		delete(listMap, uid0)
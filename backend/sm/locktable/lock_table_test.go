@@ -4,6 +4,7 @@ import (
 	"nyadb2/backend/sm/locktable"
 	"nyadb2/backend/utils"
 	"testing"
+	"time"
 )
 
 func TestLockTableMulti(t *testing.T) {
@@ -71,3 +72,31 @@ func TestLockTable2(t *testing.T) {
 		t.Fatal("Error")
 	}
 }
+
+// 等待之后获得的uid, 同样要在xid被Remove时释放.
+func TestLockTableReleaseWaited(t *testing.T) {
+	lt := locktable.NewLockTable()
+	lt.Add(1, 1)
+	ok, ch := lt.Add(2, 1)
+	if ok == false {
+		t.Fatal("Error")
+	}
+	done := make(chan struct{})
+	go func() {
+		<-ch
+		close(done)
+	}()
+	lt.Remove(1)
+	<-done
+
+	lt.Remove(2)
+	ok, ch = lt.Add(3, 1)
+	if ok == false {
+		t.Fatal("Error")
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("uid 1 is not released")
+	}
+}
@@ -1,7 +1,7 @@
 /*
 	serializability_manager.go 保证了调度的可串行化, 同时实现了MVCC.
 
-	当事务发生ErrCannotSR错误时, SM会对该事务进行自动回滚.
+	当事务发生ErrCannotSR错误, 或者上层通过Fail报告了错误时, SM会对该事务进行自动回滚.
 */
 package sm
 
@@ -25,9 +25,14 @@ type SerializabilityManager interface {
 	Insert(xid tm.XID, data []byte) (utils.UUID, error)
 	Delete(xid tm.XID, uuid utils.UUID) (bool, error)
 
+	ReadLatest(xid tm.XID, uuid utils.UUID) ([]byte, bool, error)
+	LockKey(xid tm.XID, key utils.UUID) error
+	IsObsolete(xmax tm.XID) bool
+
 	Begin(level int) tm.XID
 	Commit(xid tm.XID) error
 	Abort(xid tm.XID)
+	Fail(xid tm.XID, err error)
 }
 
 type serializabilityManager struct {
@@ -155,6 +160,80 @@ func (sm *serializabilityManager) Read(xid tm.XID, uuid utils.UUID) ([]byte, boo
 	}
 }
 
+/*
+	ReadLatest 读取uuid对应记录的最新版本, 不考虑xid的隔离度和快照.
+	只要该记录没有被回滚, 也没有被已提交的事务或xid自己删除, 就返回其内容, 即使它由尚未提交的事务创建.
+	它用于检查唯一约束, 此时需要看到所有可能与xid冲突的记录, 而不只是xid可见的记录.
+*/
+func (sm *serializabilityManager) ReadLatest(xid tm.XID, uuid utils.UUID) ([]byte, bool, error) {
+	sm.lock.Lock()
+	t := sm.tc[xid]
+	sm.lock.Unlock()
+
+	if t.Err != nil {
+		return nil, false, t.Err
+	}
+
+	handle, err := sm.ec.Get(uuid)
+	if err == ErrNilEntry {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	e := handle.(*entry)
+	defer e.Release()
+
+	if IsLatest(sm.TM, t, e) {
+		return e.Data(), true, nil
+	} else {
+		return nil, false, nil
+	}
+}
+
+/*
+	LockKey 让xid获得key的锁, 直到xid提交或者回滚时才释放.
+	key不对应任何记录, 它用于让插入相同唯一值的事务依次执行.
+	key和记录的uuid共用同一张锁表, 所以调用者需要保证key不会与记录的uuid重复.
+
+	和Delete一样, 如果等待该锁会造成死锁, 则自动回滚xid.
+*/
+func (sm *serializabilityManager) LockKey(xid tm.XID, key utils.UUID) error {
+	sm.lock.Lock()
+	t := sm.tc[xid]
+	sm.lock.Unlock()
+
+	if t.Err != nil {
+		return t.Err
+	}
+
+	ok, ch := sm.lt.Add(utils.UUID(xid), key)
+	if ok == false {
+		t.Err = ErrCannotSR
+		sm.abort(xid, true) // 自动撤销
+		t.AutoAbortted = true
+		return t.Err
+	}
+	<-ch
+	return nil
+}
+
+/*
+	IsObsolete 判断被已提交的事务xmax删除的记录, 是否已经对所有活跃的事务都不可见.
+	read committed的事务总是看不到它们; repeatable read的事务只有在xmax在其开始之前就已经提交时才看不到,
+	见visibility.go.
+*/
+func (sm *serializabilityManager) IsObsolete(xmax tm.XID) bool {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	for _, t := range sm.tc {
+		if t.Level != 0 && (xmax > t.XID || t.InSnapShot(xmax)) {
+			return false
+		}
+	}
+	return true
+}
+
 func (sm *serializabilityManager) Begin(level int) tm.XID {
 	sm.lock.Lock()
 	defer sm.lock.Unlock()
@@ -203,6 +282,20 @@ func (sm *serializabilityManager) Abort(xid tm.XID) {
 	sm.abort(xid, false) // 手动撤销
 }
 
+// Fail 让xid发生错误err, 并对其进行自动回滚, 之后xid的操作都会返回err, 只能被撤销.
+func (sm *serializabilityManager) Fail(xid tm.XID, err error) {
+	sm.lock.Lock()
+	t := sm.tc[xid]
+	sm.lock.Unlock()
+
+	if t.Err != nil { // 已经发生过错误
+		return
+	}
+	t.Err = err
+	sm.abort(xid, true) // 自动撤销
+	t.AutoAbortted = true
+}
+
 func (sm *serializabilityManager) ReleaseEntry(e *entry) {
 	sm.ec.Release(e.selfUUID)
 }
@@ -100,3 +100,25 @@ func repeatableRead(tm tm.TransactionManager, t *transaction, e *entry) bool {
 	}
 	return false
 }
+
+// IsLatest 测试e是否是其记录的最新版本, 与t的隔离度和快照无关, 见ReadLatest.
+/*
+Latest:
+    XMIN is not aborted and              // not rolled back and
+    (XMAX == NULL or                     // not deleted now or
+     (XMAX != Ti and                     // deleted by another transaction but
+      XMAX is not commited               // the transaction is not commited now
+    ))
+*/
+func IsLatest(tm tm.TransactionManager, t *transaction, e *entry) bool {
+	xmin := e.XMIN()
+	xmax := e.XMAX()
+
+	if xmin != t.XID && tm.IsAborted(xmin) {
+		return false
+	}
+	if xmax == 0 {
+		return true
+	}
+	return xmax != t.XID && tm.IsCommited(xmax) == false
+}
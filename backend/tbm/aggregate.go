@@ -0,0 +1,515 @@
+/*
+	aggregate.go 实现了read中读取字段的计算, 聚合函数, 以及group by和having.
+
+	read读出的记录会先被分组, 每一组对应输出的一行:
+	如果是聚合查询(有group by或having, 或者读取的字段中出现了聚合函数), 那么group by中的字段值
+	都相同的记录为一组, 没有group by时, 所有的记录为一组; 否则, 每条记录单独为一组.
+
+	在聚合查询中, 除了聚合函数的参数以外, 读取的字段和having中只能引用group by中的字段.
+	聚合函数的参数为普通的值表达式, 不能再包含聚合函数.
+
+	聚合函数的结果类型: count为uint, avg为float, sum, min和max与参数相同. sum和avg的参数只能是数值.
+	聚合函数会忽略为NULL的参数, count(*)则统计所有记录. 如果没有不为NULL的参数, 那么除count外,
+	聚合函数的结果为NULL.
+*/
+package tbm
+
+import (
+	"errors"
+	"nyadb2/backend/parser/statement"
+	"nyadb2/backend/utils"
+	"strings"
+)
+
+var (
+	ErrNotGrouped = errors.New("Field not in group by.")
+)
+
+// readItem 为read输出的一列, 或having中比较的一边. 它对一组记录进行计算.
+type readItem interface {
+	value(g []entry) (interface{}, error)
+}
+
+// scalarItem 为普通的值表达式, 对组中的第一条记录进行计算.
+type scalarItem struct {
+	exp valueExp
+}
+
+type aggItem struct {
+	fn  string
+	arg valueExp // count(*)时为nil
+}
+
+type havingExp interface {
+	eval(g []entry) (bool, error)
+}
+
+type havingLogic struct {
+	op   string
+	exp1 havingExp
+	exp2 havingExp
+}
+
+type havingNot struct {
+	exp havingExp
+}
+
+type havingCmp struct {
+	op    string
+	item1 readItem
+	item2 readItem
+}
+
+// readQuery 为编译后的read语句中, 与分组和输出相关的部分.
+type readQuery struct {
+	scope     scope
+	items     []readItem
+	groupBy   []*field
+	having    havingExp
+	aggregate bool
+}
+
+func isAggFunc(name string) bool {
+	return name == "count" || name == "sum" || name == "min" ||
+		name == "max" || name == "avg"
+}
+
+// hasAggFunc 判断exp中是否出现了聚合函数.
+func hasAggFunc(exp statement.ValueExp) bool {
+	switch e := exp.(type) {
+	case *statement.ArithExp:
+		return hasAggFunc(e.Exp1) || hasAggFunc(e.Exp2)
+	case *statement.FuncExp:
+		if isAggFunc(e.FuncName) {
+			return true
+		}
+		for _, arg := range e.Args {
+			if hasAggFunc(arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileRead 编译read语句中的读取字段, group by和having.
+func (s scope) compileRead(read *statement.Read) (*readQuery, error) {
+	q := &readQuery{scope: s}
+	for _, name := range read.GroupBy {
+		fd, err := s.field(name)
+		if err != nil {
+			return nil, err
+		}
+		q.groupBy = append(q.groupBy, fd)
+	}
+
+	q.aggregate = len(q.groupBy) > 0 || read.Having != nil
+	for _, exp := range read.Fields {
+		if hasAggFunc(exp) {
+			q.aggregate = true
+		}
+	}
+
+	if read.Fields == nil { // *
+		if q.aggregate {
+			return nil, ErrInvalidExp
+		}
+		for _, f := range s.fields() {
+			q.items = append(q.items, &scalarItem{exp: &fieldExp{fd: f}})
+		}
+	}
+	for _, exp := range read.Fields {
+		item, err := q.compileItem(exp, "")
+		if err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, item)
+	}
+
+	if read.Having != nil {
+		var err error
+		q.having, err = q.compileHaving(read.Having.Exp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// inferKind 推断exp的计算类型, 如果无法推断(如字面值), 则返回空串.
+// 占位符的类型为其绑定的值的类型.
+func (s scope) inferKind(exp statement.ValueExp) string {
+	switch e := exp.(type) {
+	case *statement.ParamExp:
+		return e.Kind
+	case *statement.FieldExp:
+		if fd, err := s.field(e.Field); err == nil {
+			return kindOf(fd.FType)
+		}
+	case *statement.ArithExp:
+		if kind := s.inferKind(e.Exp1); kind != "" {
+			return kind
+		}
+		return s.inferKind(e.Exp2)
+	case *statement.FuncExp:
+		switch e.FuncName {
+		case "count":
+			return _KIND_UINT
+		case "avg":
+			return _KIND_FLOAT
+		case "upper", "lower":
+			return _KIND_STRING
+		case "sum", "min", "max":
+			if len(e.Args) == 1 {
+				return s.inferKind(e.Args[0])
+			}
+		}
+	}
+	return ""
+}
+
+// checkGrouped 检查exp中引用的字段是否都在group by中.
+func (q *readQuery) checkGrouped(exp statement.ValueExp) error {
+	switch e := exp.(type) {
+	case *statement.FieldExp:
+		fd, err := q.scope.field(e.Field)
+		if err != nil {
+			return err
+		}
+		if q.isGrouped(fd) == false {
+			return ErrNotGrouped
+		}
+	case *statement.ArithExp:
+		if err := q.checkGrouped(e.Exp1); err != nil {
+			return err
+		}
+		return q.checkGrouped(e.Exp2)
+	case *statement.FuncExp:
+		for _, arg := range e.Args {
+			if err := q.checkGrouped(arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isGrouped 判断fd是否在group by中.
+func (q *readQuery) isGrouped(fd *field) bool {
+	for _, g := range q.groupBy {
+		if g == fd {
+			return true
+		}
+	}
+	return false
+}
+
+// compileItem 编译一个读取字段, 或having中比较的一边.
+// 如果exp的类型无法推断, 则使用hint作为其类型.
+func (q *readQuery) compileItem(exp statement.ValueExp, hint string) (readItem, error) {
+	if fn, ok := exp.(*statement.FuncExp); ok && isAggFunc(fn.FuncName) {
+		return q.scope.compileAggItem(fn)
+	}
+
+	if q.aggregate {
+		if err := q.checkGrouped(exp); err != nil {
+			return nil, err
+		}
+	}
+
+	kind := q.scope.inferKind(exp)
+	if kind == "" {
+		kind = hint
+	}
+	if kind == "" {
+		kind = _KIND_STRING
+	}
+
+	vexp, err := q.scope.compileValueExp(exp, kind)
+	if err != nil {
+		return nil, err
+	}
+	return &scalarItem{exp: vexp}, nil
+}
+
+func (s scope) compileAggItem(fn *statement.FuncExp) (readItem, error) {
+	if len(fn.Args) != 1 {
+		return nil, ErrInvalidExp
+	}
+	if _, ok := fn.Args[0].(*statement.StarExp); ok {
+		if fn.FuncName != "count" {
+			return nil, ErrInvalidExp
+		}
+		return &aggItem{fn: fn.FuncName}, nil
+	}
+
+	kind := s.inferKind(fn.Args[0])
+	if kind == "" {
+		return nil, ErrInvalidExp
+	}
+	if (fn.FuncName == "sum" || fn.FuncName == "avg") && isNumeric(kind) == false {
+		return nil, ErrInvalidExp
+	}
+	arg, err := s.compileValueExp(fn.Args[0], kind)
+	if err != nil {
+		return nil, err
+	}
+	return &aggItem{fn: fn.FuncName, arg: arg}, nil
+}
+
+func (q *readQuery) compileHaving(exp statement.Exp) (havingExp, error) {
+	switch e := exp.(type) {
+	case *statement.LogicExp:
+		if e.LogicOp != "and" && e.LogicOp != "or" {
+			return nil, ErrInvalidLogOP
+		}
+		exp1, err := q.compileHaving(e.Exp1)
+		if err != nil {
+			return nil, err
+		}
+		exp2, err := q.compileHaving(e.Exp2)
+		if err != nil {
+			return nil, err
+		}
+		return &havingLogic{op: e.LogicOp, exp1: exp1, exp2: exp2}, nil
+	case *statement.NotExp:
+		sub, err := q.compileHaving(e.Exp)
+		if err != nil {
+			return nil, err
+		}
+		return &havingNot{exp: sub}, nil
+	case *statement.CmpExp:
+		kind1, kind2 := q.scope.inferKind(e.Exp1), q.scope.inferKind(e.Exp2)
+		if kind1 != "" && kind2 != "" && kind1 != kind2 &&
+			(isNumeric(kind1) == false || isNumeric(kind2) == false) { // 只有数值之间可以互相比较
+			return nil, ErrInvalidExp
+		}
+		item1, err := q.compileItem(e.Exp1, kind2)
+		if err != nil {
+			return nil, err
+		}
+		item2, err := q.compileItem(e.Exp2, kind1)
+		if err != nil {
+			return nil, err
+		}
+		return &havingCmp{op: e.CmpOp, item1: item1, item2: item2}, nil
+	}
+	return nil, ErrInvalidExp
+}
+
+func (s *scalarItem) value(g []entry) (interface{}, error) {
+	var e entry
+	if len(g) > 0 {
+		e = g[0]
+	}
+	return s.exp.eval(e)
+}
+
+func (a *aggItem) value(g []entry) (interface{}, error) {
+	if a.arg == nil { // count(*)
+		return uint64(len(g)), nil
+	}
+
+	var result interface{}
+	var count uint64
+	var fsum float64
+	for _, e := range g {
+		v, err := a.arg.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		count++
+		switch a.fn {
+		case "count":
+			result = count
+		case "sum":
+			if result == nil {
+				result = v
+			} else if result, err = arith("+", result, v); err != nil {
+				return nil, err
+			}
+		case "avg":
+			fsum += toFloat(v)
+			result = fsum / float64(count)
+		case "min":
+			if result == nil || compareValues(v, result) < 0 {
+				result = v
+			}
+		case "max":
+			if result == nil || compareValues(v, result) > 0 {
+				result = v
+			}
+		}
+	}
+	if a.fn == "count" {
+		return count, nil
+	}
+	return result, nil
+}
+
+func (l *havingLogic) eval(g []entry) (bool, error) {
+	ok, err := l.exp1.eval(g)
+	if err != nil {
+		return false, err
+	}
+	if l.op == "and" && ok == false {
+		return false, nil
+	}
+	if l.op == "or" && ok == true {
+		return true, nil
+	}
+	return l.exp2.eval(g)
+}
+
+func (n *havingNot) eval(g []entry) (bool, error) {
+	ok, err := n.exp.eval(g)
+	return !ok, err
+}
+
+// eval 如果任一边为NULL, 则比较的结果为false.
+func (c *havingCmp) eval(g []entry) (bool, error) {
+	v1, err := c.item1.value(g)
+	if err != nil {
+		return false, err
+	}
+	v2, err := c.item2.value(g)
+	if err != nil {
+		return false, err
+	}
+	if v1 == nil || v2 == nil {
+		return false, nil
+	}
+	return matchCmp(c.op, compareValues(v1, v2)), nil
+}
+
+// compareValues 比较两个计算结果, 数值之间可以互相比较.
+func compareValues(v1, v2 interface{}) int {
+	switch a := v1.(type) {
+	case string:
+		return strings.Compare(a, v2.(string))
+	case bool:
+		return compareBool(a, v2.(bool))
+	case uint64:
+		switch b := v2.(type) {
+		case uint64:
+			return compareUint64(a, b)
+		case int64:
+			if b < 0 {
+				return 1
+			}
+			return compareUint64(a, uint64(b))
+		}
+	case int64:
+		switch b := v2.(type) {
+		case int64:
+			return compareInt64(a, b)
+		case uint64:
+			if a < 0 {
+				return -1
+			}
+			return compareUint64(uint64(a), b)
+		}
+	}
+	return compareFloat64(toFloat(v1), toFloat(v2))
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case uint64:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return v.(float64)
+}
+
+func valuePrint(v interface{}) string {
+	switch v := v.(type) {
+	case uint64:
+		return utils.Uint64ToStr(v)
+	case int64:
+		return utils.Int64ToStr(v)
+	case float64:
+		return utils.Float64ToStr(v)
+	case bool:
+		return utils.BoolToStr(v)
+	case string:
+		return v
+	}
+	return "NULL"
+}
+
+// groupEntries 对entries进行分组, 各组的顺序为其第一条记录在entries中出现的顺序.
+func (q *readQuery) groupEntries(entries []entry) [][]entry {
+	if q.aggregate == false {
+		groups := make([][]entry, len(entries))
+		for i, e := range entries {
+			groups[i] = []entry{e}
+		}
+		return groups
+	}
+	if len(q.groupBy) == 0 {
+		return [][]entry{entries}
+	}
+
+	var groups [][]entry
+	index := make(map[string]int)
+	for _, e := range entries {
+		var key []byte
+		for _, fd := range q.groupBy { // NULL编码为0, 其他值编码为1+raw
+			if v := e[fd]; v == nil {
+				key = append(key, 0)
+			} else {
+				key = append(key, 1)
+				key = append(key, fd.ValueToRaw(v)...)
+			}
+		}
+		i, ok := index[string(key)]
+		if ok == false {
+			i = len(groups)
+			index[string(key)] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], e)
+	}
+	return groups
+}
+
+// filter 返回满足having的分组.
+func (q *readQuery) filter(groups [][]entry) ([][]entry, error) {
+	if q.having == nil {
+		return groups, nil
+	}
+	var result [][]entry
+	for _, g := range groups {
+		ok, err := q.having.eval(g)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, g)
+		}
+	}
+	return result, nil
+}
+
+// groupPrint 计算并输出一组记录对应的一行.
+func (q *readQuery) groupPrint(g []entry) (string, error) {
+	str := "["
+	for i, item := range q.items {
+		v, err := item.value(g)
+		if err != nil {
+			return "", err
+		}
+		str += valuePrint(v)
+		if i == len(q.items)-1 {
+			str += "]"
+		} else {
+			str += ", "
+		}
+	}
+	return str, nil
+}
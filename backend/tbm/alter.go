@@ -0,0 +1,273 @@
+/*
+	alter.go 实现了alter table, 以及表结构的版本.
+
+	表的记录通过SM插入, 不能被修改, 所以alter和drop加create一样: 删除原来的表记录, 并插入一条新的表记录.
+	新的表与原来的表共用行目录, 索引和没有改变的字段, 只是字段列表不同. 于是alter同样受事务的控制:
+	提交之前只对alter的事务可见, 回滚之后原来的表记录重新可见. 提交之后, 快照中还是原来的表记录的
+	repeatable read的事务再访问该表时会报ErrTableChanged, 见table_manager.go.
+
+	为了不重写表中已有的记录, 每条记录都带有写入它时的表结构的版本号, 见table.go.
+	一张表的所有版本的字段列表依次记录在schema中, 第i个版本为alter了i次之后的字段列表.
+	同一张表的各个表记录共用内存中的同一个schema, 于是即使一个事务还在使用原来的表,
+	它也能解析其他事务按照新的表结构写入的记录. 解析记录时:
+	1. 记录中有, 但当前版本中已经被drop的字段会被忽略;
+	2. 当前版本中有, 但记录中没有的字段, 即之后被add的字段, 取其add时给出的默认值, 没有则为NULL.
+	所以add column只能给出字面值的默认值, 因为它同时也是已有的记录在该字段上的值.
+
+	被回滚的alter所产生的版本依然会留在内存的schema中, 之后的alter会将其一同持久化.
+	这样版本号就不会被重复使用, 即使被回滚的事务写入的记录之后被读到, 也能正确解析.
+	重启之后, 被回滚的版本中由被回滚的事务创建的字段无法再被读入, 于是这样的版本在schema中为空,
+	但此时按照它写入的记录对任何事务都不可见, 也不会再被解析.
+
+	add column的字段不能有索引, 也不能是unique, 有外键或者check约束的, 因为这些都需要检查已有的记录.
+	drop column会同时删除引用了该字段的check约束, 但不能drop被外键引用的字段, 以及表中最后一个字段.
+	被drop的字段的索引不会被删除, 只是不再被使用.
+*/
+package tbm
+
+import (
+	"errors"
+	"nyadb2/backend/parser/statement"
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+	"sync"
+)
+
+var (
+	ErrInvalidAlter  = errors.New("Invalid alter.")
+	ErrFieldReferred = errors.New("Field is referenced.")
+	ErrTableChanged  = errors.New("Table has been changed.")
+	ErrChangeFailed  = errors.New("Table change failed, transaction must be aborted.")
+)
+
+type schema struct {
+	versions [][]*field // 每个版本的字段, 其中的field只用于解析记录中的值
+	lock     sync.RWMutex
+}
+
+// version 返回第v个版本的字段.
+func (s *schema) version(v uint32) []*field {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.versions[v]
+}
+
+// add 加入一个新的版本, 并返回其版本号.
+func (s *schema) add(fields []*field) uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.versions = append(s.versions, fields)
+	return uint32(len(s.versions) - 1)
+}
+
+// toRaw 返回s中前n个版本的二进制格式, 见table.go.
+func (s *schema) toRaw(n uint32) []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	raw := utils.Uint32ToRaw(n)
+	for _, fields := range s.versions[:n] {
+		raw = append(raw, utils.Uint32ToRaw(uint32(len(fields)))...)
+		for _, f := range fields {
+			raw = append(raw, utils.UUIDToRaw(f.SelfUUID)...)
+		}
+	}
+	return raw
+}
+
+/*
+	Alter 修改一张表的结构.
+	和Drop一样, 原来的表记录会被xid删除, 直到xid提交, 才会被真正的从TBM中移除.
+*/
+func (tbm *tableManager) Alter(xid tm.XID, alter *statement.Alter) ([]byte, error) {
+	tbm.lock.Lock()
+	tb, err := tbm.table(xid, alter.TableName)
+	tbm.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if alter.Drop != "" {
+		children, err := tbm.children(xid, tb)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range children {
+			if f.ref.field == alter.Drop {
+				return nil, ErrFieldReferred
+			}
+		}
+	}
+
+	ntb, err := tb.alter(xid, alter) // 在删除原来的表记录之前检查, 以免失败时丢失该表
+	if err != nil {
+		return nil, err
+	}
+	err = tbm.deleteTable(xid, tb)
+	if err != nil {
+		return nil, err
+	}
+	err = tbm.addVersion(xid, ntb)
+	if err != nil {
+		return nil, tbm.failChange(xid, tb, err)
+	}
+	return []byte("alter " + alter.TableName), nil
+}
+
+/*
+	deleteTable 让xid删除tb的表记录, 以便之后通过addVersion加入其新版本.
+	删除之后, 其他事务对该表结构的修改需要等待xid结束.
+*/
+func (tbm *tableManager) deleteTable(xid tm.XID, tb *table) error {
+	// SM.Delete可能会因为等待锁而阻塞, 所以不能持有tbm.lock.
+	ok, err := tbm.SM.Delete(xid, tb.SelfUUID)
+	if err != nil {
+		return err
+	}
+	if ok == false {
+		return ErrNoThatTable
+	}
+
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+	tbm.xdt[xid] = append(tbm.xdt[xid], tb)
+	changed, err := tbm.isChanged(xid, tb)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return tbm.failChange(xid, tb, ErrTableChanged)
+	}
+	return nil
+}
+
+/*
+	failChange 在xid通过deleteTable删除了tb的表记录之后, 修改表结构失败时调用, 并返回err.
+	此时tb的新版本还没有被加入TBM, 如果xid提交, 该表就会丢失, 所以让SM自动回滚xid,
+	之后xid只能被撤销. 同时移除xid在tb上建立的索引, 以免其他事务继续向其中插入.
+*/
+func (tbm *tableManager) failChange(xid tm.XID, tb *table, err error) error {
+	tbm.SM.Fail(xid, ErrChangeFailed)
+	tb.removeBuilds(xid)
+	return err
+}
+
+// addVersion 将ntb作为schema中的新版本持久化, 并加入TBM.
+func (tbm *tableManager) addVersion(xid tm.XID, ntb *table) error {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+	ntb.version = ntb.schema.add(ntb.fields)
+	err := ntb.persistSelf(xid)
+	if err != nil {
+		return err
+	}
+	link, err := tbm.createLink(ntb.SelfUUID, tbm.firstLinkUUID())
+	if err != nil {
+		return err
+	}
+	ntb.link = link
+	tbm.updateFirstLinkUUID(link)
+	tbm.addTable(ntb)
+	tbm.xtc[xid] = append(tbm.xtc[xid], ntb)
+	return nil
+}
+
+/*
+	isChanged 判断在xid获得tb的锁之前, 同名的表是否已经被其他事务修改.
+	read committed的事务在获得锁之后不会检查版本跳跃, 所以需要检查同名的表是否还有其他的最新版本.
+	调用者需要持有tbm.lock.
+*/
+func (tbm *tableManager) isChanged(xid tm.XID, tb *table) (bool, error) {
+	for _, t := range tbm.tc[tb.Name] {
+		if t == tb {
+			continue
+		}
+		_, ok, err := tbm.SM.ReadLatest(xid, t.SelfUUID)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// copy 返回t的一个新版本, 其字段为t的字段的副本, 与t共用行目录, 索引和序列.
+// 调用者修改其字段之后, 需要通过copyChecks加入t的check约束, 再通过tbm.addVersion加入TBM.
+func (t *table) copy() *table {
+	ntb := &table{
+		TBM:    t.TBM,
+		Name:   t.Name,
+		rows:   t.rows,
+		rowsBt: t.rowsBt,
+		schema: t.schema,
+	}
+	for _, f := range t.fields {
+		nf := *f
+		nf.tb = ntb
+		ntb.fields = append(ntb.fields, &nf)
+	}
+	return ntb
+}
+
+// copyChecks 将t的check约束重新编译到ntb上, 引用了ntb中不存在的字段的约束会被丢弃.
+func (t *table) copyChecks(ntb *table) error {
+	for _, c := range t.checks {
+		nc, err := ntb.loadCheck(c.name, c.text)
+		if err == ErrNoThatField { // 引用了被drop的字段
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ntb.checks = append(ntb.checks, nc)
+	}
+	return nil
+}
+
+// alter 根据alter创建t的新版本.
+func (t *table) alter(xid tm.XID, alter *statement.Alter) (*table, error) {
+	ntb := t.copy()
+	if alter.Drop != "" {
+		var fields []*field
+		for _, f := range ntb.fields {
+			if f.FName != alter.Drop {
+				fields = append(fields, f)
+			}
+		}
+		if len(fields) == len(ntb.fields) {
+			return nil, ErrNoThatField
+		}
+		ntb.fields = fields
+	}
+	if len(ntb.fields) == 0 {
+		return nil, ErrInvalidAlter
+	}
+
+	if add := alter.Add; add != nil {
+		if ntb.field(add.FieldName[0]) != nil {
+			return nil, ErrDuplicatedField
+		}
+		if add.Unique[0] || add.PrimaryKey != "" || add.References[0] != nil || len(add.Checks) > 0 {
+			return nil, ErrInvalidAlter
+		}
+		def := add.Default[0]
+		if def != nil && def.Func != "" {
+			return nil, ErrInvalidDefault
+		}
+		if add.NotNull[0] && (def == nil || def.Value == nil) { // 已有的记录在该字段上为NULL
+			return nil, ErrNullValue
+		}
+		var flags byte
+		if add.NotNull[0] {
+			flags |= _FLAG_NOT_NULL
+		}
+		f, err := CreateField(ntb, xid, add.FieldName[0], add.FieldType[0], flags, def, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		ntb.fields = append(ntb.fields, f)
+	}
+
+	err := t.copyChecks(ntb)
+	if err != nil {
+		return nil, err
+	}
+	return ntb, nil
+}
@@ -0,0 +1,238 @@
+/*
+	check.go 实现了check约束.
+
+	check约束的表达式以原文的形式被持久化在表中(见table.go), 加载表时再通过parser.ParseCheck重新解析并编译.
+	插入和更新记录时, 会对新的记录计算表中所有的check约束, 只要有一个的结果为false, 就返回*CheckError.
+
+	和where一样, check使用三值逻辑, 与NULL的比较结果为unknown, not unknown仍为unknown.
+	但与where不同, 结果为unknown的check约束被视为满足, 所以check (qty >= 0)允许qty为NULL.
+	如果不允许字段为NULL, 需要使用not null.
+
+	没有给出名字的约束会被自动命名, 字段上的约束为<表名>_<字段名>_check, 表级的约束为<表名>_check,
+	重名时在其后加上从1开始的序号.
+*/
+package tbm
+
+import (
+	"errors"
+	"nyadb2/backend/parser"
+	"nyadb2/backend/parser/statement"
+	"strconv"
+)
+
+var (
+	ErrCheckViolated   = errors.New("Check constraint violated.")
+	ErrDuplicatedCheck = errors.New("Duplicated check.")
+)
+
+// CheckError 表示记录不满足名为Name的check约束.
+type CheckError struct {
+	Name string
+}
+
+func (e *CheckError) Error() string {
+	return "Check constraint " + e.Name + " is violated."
+}
+
+func (e *CheckError) Unwrap() error {
+	return ErrCheckViolated
+}
+
+type check struct {
+	name string
+	text string // 表达式的原文
+	exp  checkExp
+}
+
+type checkExp interface {
+	eval(e entry) (truth, error)
+}
+
+type checkLogic struct {
+	op   string
+	exp1 checkExp
+	exp2 checkExp
+}
+
+type checkNot struct {
+	exp checkExp
+}
+
+type checkCmp struct {
+	op   string
+	exp1 valueExp
+	exp2 valueExp
+}
+
+// checkWhere 将whereExp作为checkExp, 用于is null.
+type checkWhere struct {
+	exp whereExp
+}
+
+// createChecks 为create中的check约束命名, 并将其编译后加入t, 调用者需要先创建好t的字段.
+func (t *table) createChecks(create *statement.Create) error {
+	used := make(map[string]bool)
+	for _, c := range create.Checks {
+		if c.Name == "" {
+			continue
+		}
+		if used[c.Name] {
+			return ErrDuplicatedCheck
+		}
+		used[c.Name] = true
+	}
+
+	for _, c := range create.Checks {
+		name := c.Name
+		if name == "" {
+			base := t.Name + "_check"
+			if c.Field != "" {
+				base = t.Name + "_" + c.Field + "_check"
+			}
+			name = base
+			for i := 1; used[name]; i++ {
+				name = base + strconv.Itoa(i)
+			}
+			used[name] = true
+		}
+		exp, err := scope{t}.compileCheck(c.Exp)
+		if err != nil {
+			return err
+		}
+		t.checks = append(t.checks, &check{name: name, text: c.Text, exp: exp})
+	}
+	return nil
+}
+
+// loadCheck 通过check的原文恢复出该约束, 调用者需要先读入t的字段.
+func (t *table) loadCheck(name, text string) (*check, error) {
+	exp, err := parser.ParseCheck([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	cexp, err := scope{t}.compileCheck(exp)
+	if err != nil {
+		return nil, err
+	}
+	return &check{name: name, text: text, exp: cexp}, nil
+}
+
+// verify 检查e是否满足t的所有check约束.
+func (t *table) verify(e entry) error {
+	for _, c := range t.checks {
+		res, err := c.exp.eval(e)
+		if err != nil {
+			return err
+		}
+		if res == _FALSE { // unknown不算违反约束
+			return &CheckError{Name: c.name}
+		}
+	}
+	return nil
+}
+
+func (c *check) Print() string {
+	return "Check " + c.name + " (" + c.text + ")"
+}
+
+// compileCheck 将check中的表达式编译成checkExp, 比较两边的类型规则与having相同.
+func (s scope) compileCheck(exp statement.Exp) (checkExp, error) {
+	switch e := exp.(type) {
+	case *statement.LogicExp:
+		if e.LogicOp != "and" && e.LogicOp != "or" {
+			return nil, ErrInvalidLogOP
+		}
+		exp1, err := s.compileCheck(e.Exp1)
+		if err != nil {
+			return nil, err
+		}
+		exp2, err := s.compileCheck(e.Exp2)
+		if err != nil {
+			return nil, err
+		}
+		return &checkLogic{op: e.LogicOp, exp1: exp1, exp2: exp2}, nil
+	case *statement.NotExp:
+		sub, err := s.compileCheck(e.Exp)
+		if err != nil {
+			return nil, err
+		}
+		return &checkNot{exp: sub}, nil
+	case *statement.IsNullExp:
+		w, err := s.compileExp(e)
+		if err != nil {
+			return nil, err
+		}
+		return &checkWhere{exp: w}, nil
+	case *statement.CmpExp:
+		kind1, kind2 := s.inferKind(e.Exp1), s.inferKind(e.Exp2)
+		if kind1 != "" && kind2 != "" && kind1 != kind2 &&
+			(isNumeric(kind1) == false || isNumeric(kind2) == false) { // 只有数值之间可以互相比较
+			return nil, ErrInvalidExp
+		}
+		if kind1 == "" {
+			kind1 = kind2
+		}
+		if kind1 == "" {
+			kind1 = _KIND_STRING
+		}
+		if kind2 == "" {
+			kind2 = kind1
+		}
+		exp1, err := s.compileValueExp(e.Exp1, kind1)
+		if err != nil {
+			return nil, err
+		}
+		exp2, err := s.compileValueExp(e.Exp2, kind2)
+		if err != nil {
+			return nil, err
+		}
+		return &checkCmp{op: e.CmpOp, exp1: exp1, exp2: exp2}, nil
+	}
+	return nil, ErrInvalidExp
+}
+
+func (l *checkLogic) eval(e entry) (truth, error) {
+	res1, err := l.exp1.eval(e)
+	if err != nil {
+		return _FALSE, err
+	}
+	if l.op == "and" && res1 == _FALSE {
+		return _FALSE, nil
+	}
+	if l.op == "or" && res1 == _TRUE {
+		return _TRUE, nil
+	}
+	res2, err := l.exp2.eval(e)
+	if err != nil {
+		return _FALSE, err
+	}
+	if l.op == "and" {
+		return res1.and(res2), nil
+	}
+	return res1.or(res2), nil
+}
+
+func (n *checkNot) eval(e entry) (truth, error) {
+	res, err := n.exp.eval(e)
+	return res.not(), err
+}
+
+// eval 如果任一边为NULL, 则比较的结果为unknown.
+func (c *checkCmp) eval(e entry) (truth, error) {
+	v1, err := c.exp1.eval(e)
+	if err != nil {
+		return _FALSE, err
+	}
+	v2, err := c.exp2.eval(e)
+	if err != nil {
+		return _FALSE, err
+	}
+	if v1 == nil || v2 == nil {
+		return _UNKNOWN, nil
+	}
+	return toTruth(matchCmp(c.op, compareValues(v1, v2))), nil
+}
+
+func (w *checkWhere) eval(e entry) (truth, error) {
+	return w.exp.eval(e)
+}
@@ -0,0 +1,218 @@
+/*
+	explain.go 实现了explain, 用于查看read, update和delete的执行计划.
+
+	执行计划包括:
+	access: 查找t的方式, 为"index <字段名>"(在该字段的索引上查找)或"scan"(扫描行目录).
+	ranges: 在索引上查找的key区间, 只在通过索引查找时出现.
+	estimated rows: 查找得到的候选记录数, 其中可能包含不可见的旧版本.
+	join: 每个join的连接条件, 以及连接方式(index nested loop或hash).
+	actual rows: 实际满足where的记录数. read时为join之后的记录数, 不包括group by, having和limit的影响.
+
+	为了得到实际的记录数, explain会真正的执行查找, 但不会修改任何数据.
+*/
+package tbm
+
+import (
+	"bytes"
+	"math"
+	"nyadb2/backend/parser/statement"
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+	"strconv"
+)
+
+type plan struct {
+	tb         *table
+	fd         *field // 为nil表示扫描整张表
+	ivs        []interval
+	candidates int
+	joins      []joinPlan
+	rows       int
+}
+
+type joinPlan struct {
+	tb    *table
+	inner *field
+	outer *field
+}
+
+func (tbm *tableManager) Explain(xid tm.XID, explain *statement.Explain) ([]byte, error) {
+	var p *plan
+	var err error
+	switch st := explain.Stat.(type) {
+	case *statement.Read:
+		p, err = tbm.explainRead(xid, st)
+	case *statement.Update:
+		p, err = tbm.explainWhere(xid, st.TableName, st.Where)
+	case *statement.Delete:
+		p, err = tbm.explainWhere(xid, st.TableName, st.Where)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(p.Print()), nil
+}
+
+func (tbm *tableManager) explainRead(xid tm.XID, read *statement.Read) (*plan, error) {
+	tb, joins, err := tbm.readTables(xid, read)
+	if err != nil {
+		return nil, err
+	}
+	p := new(plan)
+	_, err = tb.Read(xid, read, joins, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (tbm *tableManager) explainWhere(xid tm.XID, name string, where *statement.Where) (*plan, error) {
+	tbm.lock.Lock()
+	tb, err := tbm.table(xid, name)
+	tbm.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return tb.explainWhere(xid, where)
+}
+
+// explainWhere 返回update或delete按照where查找记录的执行计划.
+func (t *table) explainWhere(xid tm.XID, where *statement.Where) (*plan, error) {
+	var exp whereExp
+	if where != nil {
+		var err error
+		exp, err = scope{t}.compileExp(where.Exp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fd, ivs := t.choose(exp, nil)
+	uuids, err := t.search(fd, ivs)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &plan{tb: t, fd: fd, ivs: ivs, candidates: len(uuids)}
+	for _, uuid := range uuids {
+		raw, ok, err := t.TBM.SM.Read(xid, uuid)
+		if err != nil {
+			return nil, err
+		}
+		if ok == false {
+			continue
+		}
+		matched, err := match(exp, t.parseEntry(raw))
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			p.rows++
+		}
+	}
+	return p, nil
+}
+
+func (p *plan) Print() string {
+	str := "table: " + p.tb.Name + "\n"
+	if p.fd == nil {
+		str += "access: scan\n"
+	} else {
+		str += "access: index " + p.fd.FName + "\n"
+		str += "ranges: " + p.fd.rangesPrint(p.ivs) + "\n"
+	}
+	str += "estimated rows: " + strconv.Itoa(p.candidates) + "\n"
+	for _, j := range p.joins {
+		method := "hash"
+		if j.inner.IsIndexed() {
+			method = "index nested loop"
+		}
+		str += "join: " + j.tb.Name + " on " + j.inner.fullName() + " = " + j.outer.fullName() +
+			" (" + method + ")\n"
+	}
+	str += "actual rows: " + strconv.Itoa(p.rows) + "\n"
+	return str
+}
+
+func (f *field) fullName() string {
+	return f.tb.Name + "." + f.FName
+}
+
+// rangesPrint 将key区间转换为可读的形式, 如[1, 5), (10, +inf), [NULL, NULL], 如果ivs为空, 则返回none.
+// -inf不区分是否包含NULL.
+func (f *field) rangesPrint(ivs []interval) string {
+	if len(ivs) == 0 {
+		return "none"
+	}
+	str := ""
+	for i, iv := range ivs {
+		if i > 0 {
+			str += ", "
+		}
+		if len(iv.left) == 0 || bytes.Equal(iv.left, nonNullKey) {
+			str += "(-inf"
+		} else if bytes.Equal(iv.left, nullKey) {
+			str += "[NULL"
+		} else if v, succ := f.keyPrint(iv.left); succ {
+			str += "(" + v
+		} else {
+			str += "[" + v
+		}
+		str += ", "
+		if iv.right == nil {
+			str += "+inf)"
+		} else if bytes.Equal(iv.right, nonNullKey) {
+			str += "NULL]"
+		} else if v, succ := f.keyPrint(iv.right); succ {
+			str += v + "]"
+		} else {
+			str += v + ")"
+		}
+	}
+	return str
+}
+
+// keyPrint 返回非NULL的key所对应的值. 如果key是某个值的key的SuccKey, 则返回该值, 且succ为true.
+// 对于string, 以0x00结尾的key都被当做SuccKey.
+func (f *field) keyPrint(key []byte) (str string, succ bool) {
+	key = key[len(nonNullKey):]
+	size := len(key)
+	switch f.FType {
+	case "uint32", "int32":
+		size = 4
+	case "uint64", "int64", "float64":
+		size = 8
+	case "bool":
+		size = 1
+	case "string":
+		if size > 0 && key[size-1] == 0 {
+			size--
+		}
+	}
+	succ = len(key) > size
+	key = key[:size]
+
+	var n uint64 // 整数, 浮点数和布尔值的key都是大端序的整数
+	for _, b := range key {
+		n = n<<8 | uint64(b)
+	}
+	switch f.FType {
+	case "uint32", "uint64":
+		str = utils.Uint64ToStr(n)
+	case "int32":
+		str = utils.Int32ToStr(int32(uint32(n) ^ (1 << 31)))
+	case "int64":
+		str = utils.Int64ToStr(int64(n ^ (1 << 63)))
+	case "float64":
+		if n&(1<<63) != 0 {
+			n &^= 1 << 63
+		} else {
+			n = ^n
+		}
+		str = utils.Float64ToStr(math.Float64frombits(n))
+	case "bool":
+		str = utils.BoolToStr(n != 0)
+	case "string":
+		str = "'" + string(key) + "'"
+	}
+	return str, succ
+}
@@ -4,51 +4,110 @@
 	一个field的二进制格式为
 	[Field Name]   string
 	[Type Name]    string
+	[Default]
 	[Index UUID]   UUID
+	[Origin UUID]  UUID
+	[Flags]        1byte, 字段的约束, 见下面的_FLAG_*
+	[Reference]    该字段的外键, 见foreign_key.go
 
 	如果该field没有索引, 那么[Index UUID]为NilUUID.
+	create index和drop index会为字段创建一个新的记录(见index.go), [Origin UUID]为该字段最初的记录的uuid,
+	如果该记录就是最初的记录, 则为NilUUID.
+
+	[Default]为insert时没有给出该字段的值时所取的默认值, 格式为
+	[Default Type] 1byte, 见下面的_DEFAULT_*
+	[Value]        只在Default Type为_DEFAULT_VALUE时出现, 为该字段类型的值
+	[Seq UUID]     UUID, 只在Default Type为_DEFAULT_SEQ时出现, 为该字段的序列, 见sequence.go
+	没有默认值的字段取该类型的零值.
+
+	unique的字段和有外键的字段一定有索引. 插入或更新unique字段时, 会先通过SM.LockKey获得该值的锁,
+	再在索引中查找是否已经有值相同的最新版本的记录(见SM.ReadLatest), 包括未提交的事务插入的记录.
+	于是并发的插入相同值的事务会依次执行, 后执行的事务会因为值重复而失败. 多个NULL不被认为是重复的.
+
+	索引中的key的第一个字节表示该值是否为NULL: NULL的key为0x00,
+	其他值的key为0x01加上该值的保序编码. 于是NULL也会被加入索引, 且比任何值都小.
 */
 package tbm
 
 import (
 	"errors"
+	"hash/fnv"
+	"math"
 	"nyadb2/backend/im"
 	"nyadb2/backend/parser/statement"
 	"nyadb2/backend/tm"
 	"nyadb2/backend/utils"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	ErrInvalidFieldType  = errors.New("Invalid field type.")
 	ErrInvalidFieldValue = errors.New("Invalid field value.")
+	ErrNullValue         = errors.New("Null value in not null field.")
+	ErrInvalidDefault    = errors.New("Invalid default value.")
+)
+
+const (
+	_FLAG_NOT_NULL = byte(1)
+	_FLAG_UNIQUE   = byte(2)
+	_FLAG_PRIMARY  = byte(4) // 主键, 一定同时是not null和unique的
+)
+
+const (
+	_DEFAULT_NONE  = byte(0)
+	_DEFAULT_NULL  = byte(1)
+	_DEFAULT_VALUE = byte(2)
+	_DEFAULT_NOW   = byte(3) // 当前时间, 整数为unix时间戳(秒), 字符串为"2006-01-02 15:04:05"的格式
+	_DEFAULT_SEQ   = byte(4) // 序列的下一个值, 只能用于整数
+)
+
+var (
+	nullKey    = []byte{0}
+	nonNullKey = []byte{1} // 所有非NULL的key都以此开头
 )
 
 type field struct {
 	SelfUUID utils.UUID
 	tb       *table
 
-	FName string
-	FType string
-	index utils.UUID
-	bt    im.BPlusTree
+	FName   string
+	FType   string
+	NotNull bool
+	Unique  bool
+	Primary bool
+	index   utils.UUID
+	bt      im.BPlusTree
+	origin  utils.UUID
+	ref     *reference // 该字段的外键, 没有则为nil
+
+	defType  byte
+	defValue interface{} // defType为_DEFAULT_VALUE时的默认值
+	seqUUID  utils.UUID
+	seq      *sequence
 }
 
 /*
 	LoadField 从DB中读入field的内容.
 	panic的原因和LoadTable类似.
+
+	如果该field由被回滚的事务创建, 则返回false, 这只会出现在表被回滚的版本中(见alter.go).
 */
-func LoadField(tb *table, uuid utils.UUID) *field {
+func LoadField(tb *table, uuid utils.UUID) (*field, bool) {
 	raw, ok, err := tb.TBM.SM.Read(tm.SUPER_XID, uuid)
-	utils.Assert(ok)
 	if err != nil {
 		panic(err)
 	}
+	if ok == false {
+		return nil, false
+	}
 	f := &field{
 		SelfUUID: uuid,
 		tb:       tb,
 	}
 	f.parseSelf(raw)
-	return f
+	return f, true
 }
 
 func (f *field) parseSelf(raw []byte) {
@@ -57,7 +116,28 @@ func (f *field) parseSelf(raw []byte) {
 	pos += shift
 	f.FType, shift = utils.ParseVarStr(raw[pos:])
 	pos += shift
+	f.defType = raw[pos]
+	pos++
+	switch f.defType {
+	case _DEFAULT_VALUE:
+		f.defValue, shift = f.ParseValue(raw[pos:])
+		pos += shift
+	case _DEFAULT_SEQ:
+		f.seqUUID = utils.ParseUUID(raw[pos:])
+		pos += utils.LEN_UUID
+		var err error
+		f.seq, err = loadSequence(f.seqUUID, f.tb.TBM.DM)
+		if err != nil {
+			panic(err)
+		}
+	}
 	f.index = utils.ParseUUID(raw[pos:])
+	pos += utils.LEN_UUID
+	f.origin = utils.ParseUUID(raw[pos:])
+	pos += utils.LEN_UUID
+	f.setFlags(raw[pos])
+	pos++
+	f.ref = parseReference(raw[pos:])
 	if f.index != utils.NilUUID {
 		var err error
 		f.bt, err = im.Load(f.index, f.tb.TBM.DM)
@@ -67,17 +147,30 @@ func (f *field) parseSelf(raw []byte) {
 	}
 }
 
-func CreateField(tb *table, xid tm.XID, fname, ftype string, indexed bool) (*field, error) {
+// CreateField 创建一个字段, flags为_FLAG_*的组合, ref为该字段的外键, 调用者需要先检查其合法性.
+// unique的字段和有外键的字段总会被建立索引.
+func CreateField(tb *table, xid tm.XID, fname, ftype string, flags byte, def *statement.Default,
+	ref *reference, indexed bool) (*field, error) {
 	err := typeCheck(ftype)
 	if err != nil {
 		return nil, err
 	}
 
 	f := &field{
-		tb:    tb,
-		FName: fname,
-		FType: ftype,
-		index: utils.NilUUID,
+		tb:     tb,
+		FName:  fname,
+		FType:  ftype,
+		index:  utils.NilUUID,
+		origin: utils.NilUUID,
+		ref:    ref,
+	}
+	f.setFlags(flags)
+	indexed = indexed || f.Unique || f.ref != nil
+	if def != nil {
+		err = f.setDefault(def)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if indexed {
@@ -101,11 +194,61 @@ func CreateField(tb *table, xid tm.XID, fname, ftype string, indexed bool) (*fie
 	return f, nil
 }
 
+// setDefault 检查并设置f的默认值, 如果默认值为seq(), 则为其创建序列.
+func (f *field) setDefault(def *statement.Default) error {
+	switch def.Func {
+	case "":
+		if def.Value == nil {
+			if f.NotNull {
+				return ErrNullValue
+			}
+			f.defType = _DEFAULT_NULL
+			return nil
+		}
+		v, err := f.StrToValue(*def.Value)
+		if err != nil {
+			return err
+		}
+		f.defType, f.defValue = _DEFAULT_VALUE, v
+	case "now":
+		if f.FType != "int64" && f.FType != "uint64" && f.FType != "string" {
+			return ErrInvalidDefault
+		}
+		f.defType = _DEFAULT_NOW
+	case "seq":
+		if kind := kindOf(f.FType); kind != _KIND_UINT && kind != _KIND_INT {
+			return ErrInvalidDefault
+		}
+		seqUUID, err := createSequence(f.tb.TBM.DM)
+		if err != nil {
+			return err
+		}
+		f.seq, err = loadSequence(seqUUID, f.tb.TBM.DM)
+		if err != nil {
+			return err
+		}
+		f.defType, f.seqUUID = _DEFAULT_SEQ, seqUUID
+	default:
+		return ErrNoThatFunc
+	}
+	return nil
+}
+
 // persist 将该field持久化
 func (f *field) persistSelf(xid tm.XID) error {
 	raw := utils.VarStrToRaw(f.FName)
 	raw = append(raw, utils.VarStrToRaw(f.FType)...)
+	raw = append(raw, f.defType)
+	switch f.defType {
+	case _DEFAULT_VALUE:
+		raw = append(raw, f.ValueToRaw(f.defValue)...)
+	case _DEFAULT_SEQ:
+		raw = append(raw, utils.UUIDToRaw(f.seqUUID)...)
+	}
 	raw = append(raw, utils.UUIDToRaw(f.index)...)
+	raw = append(raw, utils.UUIDToRaw(f.origin)...)
+	raw = append(raw, f.flags())
+	raw = append(raw, f.ref.toRaw()...)
 	self, err := f.tb.TBM.SM.Insert(xid, raw)
 	if err != nil {
 		return err
@@ -114,17 +257,61 @@ func (f *field) persistSelf(xid tm.XID) error {
 	return nil
 }
 
+func (f *field) setFlags(flags byte) {
+	f.Primary = flags&_FLAG_PRIMARY != 0
+	f.NotNull = flags&_FLAG_NOT_NULL != 0 || f.Primary
+	f.Unique = flags&_FLAG_UNIQUE != 0 || f.Primary
+}
+
+func (f *field) flags() byte {
+	var flags byte
+	if f.NotNull {
+		flags |= _FLAG_NOT_NULL
+	}
+	if f.Unique {
+		flags |= _FLAG_UNIQUE
+	}
+	if f.Primary {
+		flags |= _FLAG_PRIMARY
+	}
+	return flags
+}
+
 func typeCheck(ftype string) error {
-	if ftype != "uint32" && ftype != "uint64" && ftype != "string" {
-		return ErrInvalidFieldType
+	switch ftype {
+	case "uint32", "uint64", "int32", "int64", "float64", "bool", "string":
+		return nil
 	}
-	return nil
+	return ErrInvalidFieldType
 }
 
 func (f *field) Print() string {
 	str := "("
 	str += f.FName
 	str += ", " + f.FType
+	if f.Primary {
+		str += ", PrimaryKey"
+	} else {
+		if f.NotNull {
+			str += ", NotNull"
+		}
+		if f.Unique {
+			str += ", Unique"
+		}
+	}
+	switch f.defType {
+	case _DEFAULT_NULL:
+		str += ", Default NULL"
+	case _DEFAULT_VALUE:
+		str += ", Default " + f.ValuePrint(f.defValue)
+	case _DEFAULT_NOW:
+		str += ", Default now()"
+	case _DEFAULT_SEQ:
+		str += ", Default seq()"
+	}
+	if f.ref != nil {
+		str += ", " + f.ref.Print()
+	}
 	if f.index != utils.NilUUID {
 		str += ", Index"
 	} else {
@@ -138,16 +325,75 @@ func (f *field) IsIndexed() bool {
 	return f.index != utils.NilUUID
 }
 
-// Insert 将(key, uuid)这键值对插入到该field的索引中
+// id 返回该字段在表的各个版本中共同的标识, 即其最初的记录的uuid.
+func (f *field) id() utils.UUID {
+	if f.origin != utils.NilUUID {
+		return f.origin
+	}
+	return f.SelfUUID
+}
+
+// Insert 将(key, uuid)这键值对插入到该field的索引中, key为nil表示NULL.
 func (f *field) Insert(key interface{}, uuid utils.UUID) error {
-	ukey := f.ValueToUUID(key)
-	return f.bt.Insert(ukey, uuid)
+	return f.bt.Insert(f.ValueToKey(key), uuid)
+}
+
+// Delete 将(key, uuid)这键值对从该field的索引中删除, key为nil表示NULL.
+func (f *field) Delete(key interface{}, uuid utils.UUID) error {
+	_, err := f.bt.Delete(f.ValueToKey(key), uuid)
+	return err
+}
+
+// keyLock 返回该字段上key的锁, 见SM.LockKey.
+// 记录的uuid的最高位总是0, 所以将锁的最高位置为1, 以免二者重复.
+func (f *field) keyLock(key []byte) utils.UUID {
+	h := fnv.New64a()
+	h.Write(utils.UUIDToRaw(f.id()))
+	h.Write(key)
+	return utils.UUID(h.Sum64() | 1<<63)
+}
+
+// lockValue 让xid获得该字段上值v的锁, 直到xid结束才释放.
+func (f *field) lockValue(xid tm.XID, v interface{}) error {
+	return f.tb.TBM.SM.LockKey(xid, f.keyLock(f.ValueToKey(v)))
+}
+
+// latest 通过索引查找该字段的值为v的最新版本的记录(见SM.ReadLatest), 返回它们的uuid和entry, self除外.
+func (f *field) latest(xid tm.XID, v interface{}, self utils.UUID) ([]utils.UUID, []entry, error) {
+	key := f.ValueToKey(v)
+	uuids, err := f.Search(key, utils.SuccKey(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var results []utils.UUID
+	var entries []entry
+	for _, uuid := range uuids {
+		if uuid == self {
+			continue
+		}
+		raw, ok, err := f.tb.TBM.SM.ReadLatest(xid, uuid)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok == false {
+			continue
+		}
+		e := f.tb.parseEntry(raw)
+		if e[f] != nil && f.Compare(e[f], v) == 0 { // 索引中的key可能被截断
+			results = append(results, uuid)
+			entries = append(entries, e)
+		}
+	}
+	return results, entries, nil
 }
 
-func (f *field) Search(left, right utils.UUID) ([]utils.UUID, error) {
+// Search 在该field的索引中查找key属于[left, right)的uuid, right为nil表示正无穷.
+func (f *field) Search(left, right []byte) ([]utils.UUID, error) {
 	return f.bt.SearchRange(left, right)
 }
 
+// StrToValue 将字面值valStr转换为该字段类型的值, 失败时返回ErrOverflow或ErrInvalidFieldValue.
 func (f *field) StrToValue(valStr string) (interface{}, error) {
 	var v interface{}
 	var err error
@@ -156,15 +402,82 @@ func (f *field) StrToValue(valStr string) (interface{}, error) {
 		v, err = utils.StrToUint32(valStr)
 	case "uint64":
 		v, err = utils.StrToUint64(valStr)
+	case "int32":
+		v, err = utils.StrToInt32(valStr)
+	case "int64":
+		v, err = utils.StrToInt64(valStr)
+	case "float64":
+		v, err = utils.StrToFloat64(valStr)
+	case "bool":
+		v, err = utils.StrToBool(valStr)
 	case "string":
 		v = valStr
 	}
 	if err != nil {
-		return nil, err
+		return nil, literalError(err)
 	}
 	return v, nil
 }
 
+// literalError 将字面值转换失败时的错误转换为TBM的错误: 超出类型的范围为ErrOverflow, 其他为ErrInvalidFieldValue.
+func literalError(err error) error {
+	if errors.Is(err, strconv.ErrRange) {
+		return ErrOverflow
+	}
+	return ErrInvalidFieldValue
+}
+
+// DefaultValue 返回insert时没有给出值的字段的值, 返回nil表示NULL.
+func (f *field) DefaultValue() (interface{}, error) {
+	switch f.defType {
+	case _DEFAULT_NULL:
+		return nil, nil
+	case _DEFAULT_VALUE:
+		return f.defValue, nil
+	case _DEFAULT_NOW:
+		now := time.Now()
+		switch f.FType {
+		case "int64":
+			return now.Unix(), nil
+		case "uint64":
+			return uint64(now.Unix()), nil
+		}
+		return now.Format("2006-01-02 15:04:05"), nil
+	case _DEFAULT_SEQ:
+		n := f.seq.next()
+		if kindOf(f.FType) == _KIND_INT {
+			if n > math.MaxInt64 {
+				return nil, ErrOverflow
+			}
+			return f.toFieldValue(int64(n))
+		}
+		return f.toFieldValue(n)
+	}
+	return f.ZeroValue(), nil
+}
+
+// ZeroValue 返回该字段类型的零值, 作为没有默认值的字段的默认值.
+func (f *field) ZeroValue() interface{} {
+	var v interface{}
+	switch f.FType {
+	case "uint32":
+		v = uint32(0)
+	case "uint64":
+		v = uint64(0)
+	case "int32":
+		v = int32(0)
+	case "int64":
+		v = int64(0)
+	case "float64":
+		v = float64(0)
+	case "bool":
+		v = false
+	case "string":
+		v = ""
+	}
+	return v
+}
+
 func (f *field) ValueToRaw(v interface{}) []byte {
 	var raw []byte
 	switch f.FType {
@@ -172,6 +485,14 @@ func (f *field) ValueToRaw(v interface{}) []byte {
 		raw = utils.Uint32ToRaw(v.(uint32))
 	case "uint64":
 		raw = utils.Uint64ToRaw(v.(uint64))
+	case "int32":
+		raw = utils.Int32ToRaw(v.(int32))
+	case "int64":
+		raw = utils.Int64ToRaw(v.(int64))
+	case "float64":
+		raw = utils.Float64ToRaw(v.(float64))
+	case "bool":
+		raw = utils.BoolToRaw(v.(bool))
 	case "string": // 转换为VarStr
 		raw = utils.VarStrToRaw(v.(string))
 	}
@@ -188,23 +509,47 @@ func (f *field) ParseValue(raw []byte) (interface{}, int) {
 	case "uint64":
 		v = utils.ParseUint64(raw)
 		shift = 8
+	case "int32":
+		v = utils.ParseInt32(raw)
+		shift = 4
+	case "int64":
+		v = utils.ParseInt64(raw)
+		shift = 8
+	case "float64":
+		v = utils.ParseFloat64(raw)
+		shift = 8
+	case "bool":
+		v = utils.ParseBool(raw)
+		shift = 1
 	case "string": // 解析出VarStr
 		v, shift = utils.ParseVarStr(raw)
 	}
 	return v, shift
 }
 
-func (f *field) ValueToUUID(v interface{}) utils.UUID {
-	var uuid utils.UUID
+// ValueToKey 将v转换为保序的索引key, v为nil表示NULL.
+func (f *field) ValueToKey(v interface{}) []byte {
+	if v == nil {
+		return nullKey
+	}
+	key := append([]byte{}, nonNullKey...)
 	switch f.FType {
 	case "uint32":
-		uuid = utils.UUID(v.(uint32))
+		key = append(key, utils.Uint32ToKey(v.(uint32))...)
 	case "uint64":
-		uuid = utils.UUID(v.(uint64))
-	case "string": // 解析出VarStr
-		uuid = utils.StrToUUID(v.(string))
+		key = append(key, utils.Uint64ToKey(v.(uint64))...)
+	case "int32":
+		key = append(key, utils.Int32ToKey(v.(int32))...)
+	case "int64":
+		key = append(key, utils.Int64ToKey(v.(int64))...)
+	case "float64":
+		key = append(key, utils.Float64ToKey(v.(float64))...)
+	case "bool":
+		key = append(key, utils.BoolToKey(v.(bool))...)
+	case "string":
+		key = append(key, utils.StrToKey(v.(string))...)
 	}
-	return uuid
+	return key
 }
 
 func (f *field) ValuePrint(v interface{}) string {
@@ -214,41 +559,109 @@ func (f *field) ValuePrint(v interface{}) string {
 		str = utils.Uint32ToStr(v.(uint32))
 	case "uint64":
 		str = utils.Uint64ToStr(v.(uint64))
+	case "int32":
+		str = utils.Int32ToStr(v.(int32))
+	case "int64":
+		str = utils.Int64ToStr(v.(int64))
+	case "float64":
+		str = utils.Float64ToStr(v.(float64))
+	case "bool":
+		str = utils.BoolToStr(v.(bool))
 	case "string": // 解析出VarStr
 		str = v.(string)
 	}
 	return str
 }
 
+// Compare 比较同为该field类型的两个值, v1 < v2返回-1, v1 == v2返回0, v1 > v2返回1.
+func (f *field) Compare(v1, v2 interface{}) int {
+	switch f.FType {
+	case "uint32":
+		return compareUint64(uint64(v1.(uint32)), uint64(v2.(uint32)))
+	case "uint64":
+		return compareUint64(v1.(uint64), v2.(uint64))
+	case "int32":
+		return compareInt64(int64(v1.(int32)), int64(v2.(int32)))
+	case "int64":
+		return compareInt64(v1.(int64), v2.(int64))
+	case "float64":
+		return compareFloat64(v1.(float64), v2.(float64))
+	case "bool":
+		return compareBool(v1.(bool), v2.(bool))
+	case "string":
+		return strings.Compare(v1.(string), v2.(string))
+	}
+	return 0
+}
+
+func compareUint64(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareBool false < true
+func compareBool(a, b bool) int {
+	if a == b {
+		return 0
+	} else if b {
+		return -1
+	}
+	return 1
+}
+
 /*
-	TODO: right为0和left为INF会有问题
+	CalExp 计算"该字段 op v"所表示的key的区间.
+	如果这些区间恰好就是该比较的结果, 则exact为true; 如果只是其超集, 则exact为false.
+
+	由于key是保序的, 所以所有的比较都能够转化为区间. 与NULL的比较结果为unknown, 不会成立, 所以区间中不包含NULL.
+	但超过im.MAX_KEY_LEN的key会在B+树中被截断, 所以长度达到im.MAX_KEY_LEN的key对应的区间只是超集.
 */
-func (f *field) CalExp(exp *statement.SingleExp) (left, right utils.UUID, err error) {
-	var v interface{}
-	if exp.CmpOp == "<" {
-		left = 0
-		v, err = f.StrToValue(exp.Value)
-		if err != nil {
-			return
-		}
-		right = f.ValueToUUID(v)
-		if right > 0 {
-			right--
-		}
-	} else if exp.CmpOp == "=" {
-		v, err = f.StrToValue(exp.Value)
-		if err != nil {
-			return
-		}
-		left = f.ValueToUUID(v)
-		right = left
-	} else if exp.CmpOp == ">" {
-		right = utils.INF
-		v, err = f.StrToValue(exp.Value)
-		if err != nil {
-			return
-		}
-		left = f.ValueToUUID(v) + 1
+func (f *field) CalExp(op string, v interface{}) (ivs []interval, exact bool) {
+	key := f.ValueToKey(v)
+	succ := utils.SuccKey(key)
+	switch op {
+	case "=":
+		ivs = []interval{{key, succ}}
+	case "!=":
+		ivs = intersectRanges(complementRanges([]interval{{key, succ}}), nullRanges(true))
+	case "<":
+		ivs = []interval{{nonNullKey, key}}
+	case "<=":
+		ivs = []interval{{nonNullKey, succ}}
+	case ">":
+		ivs = []interval{{succ, nil}}
+	case ">=":
+		ivs = []interval{{key, nil}}
+	}
+	return ivs, len(key) < im.MAX_KEY_LEN
+}
+
+// nullRanges 返回NULL在索引上的区间, not为true时返回所有非NULL值的区间.
+func nullRanges(not bool) []interval {
+	if not {
+		return []interval{{nonNullKey, nil}}
 	}
-	return
+	return []interval{{nullKey, nonNullKey}}
 }
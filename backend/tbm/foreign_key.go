@@ -0,0 +1,308 @@
+/*
+	foreign_key.go 实现了外键.
+
+	字段的外键表示该字段的非NULL值必须是另一张表(父表)中某个字段的值, 被引用的字段必须是unique的,
+	且类型与该字段相同. 外键的二进制格式为
+	[Table Name]   string, 为空表示该字段没有外键, 此时没有后面的部分
+	[Field Name]   string
+	[On Delete]    1byte, 见下面的_ACTION_*
+	[On Update]    1byte
+
+	外键通过表名引用父表. 被其他表引用的表不能被删除, 所以对任一事务, 外键最多指向一张可见的表.
+
+	外键的检查和unique使用同一把锁, 即父表中被引用的值的锁(见field.keyLock):
+	1. 插入子表的记录, 或者update修改了子表记录的外键时, 先获得父表中该值的锁, 再通过父表的索引
+	   查找该值最新版本的记录(见SM.ReadLatest), 如果没有则报错.
+	2. 删除父表的记录, 或者update修改了父表记录中被引用的值时, 先获得原来的值的锁, 再通过子表的索引
+	   查找引用该值的最新版本的记录, 并根据on delete或on update进行处理:
+	   restrict: 报错;
+	   cascade:  删除子表中的记录, 或将其外键更新为新的值;
+	   set null: 将子表中的记录的外键更新为NULL.
+	   子表中的记录被删除或更新时, 同样会处理引用它的记录, 于是cascade会一直传递下去.
+	插入父表记录的事务会一直持有该值的锁(见checkUnique), 所以检查时, 修改该值的其他事务要么已经结束,
+	要么需要等待当前事务结束. 于是不会出现一个事务插入引用某个值的记录, 另一个事务同时删除该值的情况.
+
+	update和delete会在修改父表的任何一条记录之前, 先对所有的记录检查一次restrict, 于是在一般情况下,
+	失败的语句不会留下被删除的记录. 修改之后会再处理一次, 以处理引用自身的记录.
+	cascade中途失败时, 已经做出的修改无法撤销, 这时Update和Delete会让SM自动回滚事务.
+*/
+package tbm
+
+import (
+	"errors"
+	"nyadb2/backend/parser/statement"
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+)
+
+var (
+	ErrInvalidReference = errors.New("Invalid reference.")
+	ErrNoReferencedKey  = errors.New("No referenced key.")
+	ErrKeyReferenced    = errors.New("Key is referenced.")
+	ErrTableReferenced  = errors.New("Table is referenced.")
+)
+
+const (
+	_ACTION_RESTRICT = byte(0)
+	_ACTION_CASCADE  = byte(1)
+	_ACTION_SET_NULL = byte(2)
+)
+
+type reference struct {
+	table    string
+	field    string
+	onDelete byte
+	onUpdate byte
+}
+
+func toAction(action string) byte {
+	switch action {
+	case "cascade":
+		return _ACTION_CASCADE
+	case "set null":
+		return _ACTION_SET_NULL
+	}
+	return _ACTION_RESTRICT
+}
+
+func actionPrint(action byte) string {
+	switch action {
+	case _ACTION_CASCADE:
+		return "Cascade"
+	case _ACTION_SET_NULL:
+		return "SetNull"
+	}
+	return "Restrict"
+}
+
+// toRaw 将r转换为二进制格式, r可以为nil.
+func (r *reference) toRaw() []byte {
+	if r == nil {
+		return utils.VarStrToRaw("")
+	}
+	raw := utils.VarStrToRaw(r.table)
+	raw = append(raw, utils.VarStrToRaw(r.field)...)
+	return append(raw, r.onDelete, r.onUpdate)
+}
+
+func parseReference(raw []byte) *reference {
+	table, pos := utils.ParseVarStr(raw)
+	if table == "" {
+		return nil
+	}
+	field, shift := utils.ParseVarStr(raw[pos:])
+	pos += shift
+	return &reference{
+		table:    table,
+		field:    field,
+		onDelete: raw[pos],
+		onUpdate: raw[pos+1],
+	}
+}
+
+func (r *reference) Print() string {
+	str := "References " + r.table + "(" + r.field + ")"
+	if r.onDelete != _ACTION_RESTRICT {
+		str += ", OnDelete " + actionPrint(r.onDelete)
+	}
+	if r.onUpdate != _ACTION_RESTRICT {
+		str += ", OnUpdate " + actionPrint(r.onUpdate)
+	}
+	return str
+}
+
+/*
+	references 检查create中各个字段的外键, 并返回其依次对应的reference, 没有外键的字段为nil.
+	外键可以引用正在被创建的表自己的字段.
+	调用者需要持有tbm.lock.
+*/
+func (tbm *tableManager) references(xid tm.XID, create *statement.Create) ([]*reference, error) {
+	refs := make([]*reference, len(create.FieldName))
+	for i, r := range create.References {
+		if r == nil {
+			continue
+		}
+
+		var ftype string
+		var unique bool
+		if r.TableName == create.TableName {
+			j := 0
+			for j < len(create.FieldName) && create.FieldName[j] != r.FieldName {
+				j++
+			}
+			if j == len(create.FieldName) {
+				return nil, ErrNoThatField
+			}
+			ftype, unique = create.FieldType[j], create.Unique[j] || create.PrimaryKey == r.FieldName
+		} else {
+			tb, err := tbm.table(xid, r.TableName)
+			if err != nil {
+				return nil, err
+			}
+			pf := tb.field(r.FieldName)
+			if pf == nil {
+				return nil, ErrNoThatField
+			}
+			ftype, unique = pf.FType, pf.Unique
+		}
+		if unique == false || ftype != create.FieldType[i] {
+			return nil, ErrInvalidReference
+		}
+
+		ref := &reference{
+			table:    r.TableName,
+			field:    r.FieldName,
+			onDelete: toAction(r.OnDelete),
+			onUpdate: toAction(r.OnUpdate),
+		}
+		notNull := create.NotNull[i] || create.PrimaryKey == create.FieldName[i]
+		if notNull && (ref.onDelete == _ACTION_SET_NULL || ref.onUpdate == _ACTION_SET_NULL) {
+			return nil, ErrInvalidReference
+		}
+		refs[i] = ref
+	}
+	return refs, nil
+}
+
+// parentField 返回ref所引用的, 对xid可见的字段.
+func (tbm *tableManager) parentField(xid tm.XID, ref *reference) (*field, error) {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+	tb, err := tbm.table(xid, ref.table)
+	if err != nil {
+		return nil, err
+	}
+	f := tb.field(ref.field)
+	if f == nil {
+		return nil, ErrNoThatField
+	}
+	return f, nil
+}
+
+// children 返回对xid可见的表中, 所有外键引用了tb的字段.
+func (tbm *tableManager) children(xid tm.XID, tb *table) ([]*field, error) {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+	var fds []*field
+	for name := range tbm.tc {
+		t, err := tbm.visibleTable(xid, name)
+		if err == ErrNoThatTable {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range t.fields {
+			if f.ref != nil && f.ref.table == tb.Name {
+				fds = append(fds, f)
+			}
+		}
+	}
+	return fds, nil
+}
+
+// checkReferences 检查e在每个有外键的字段上的值是否存在于父表中.
+// old为update时e原来的版本, 值没有被修改的字段不再检查.
+func (t *table) checkReferences(xid tm.XID, e, old entry) error {
+	for _, f := range t.fields {
+		v := e[f]
+		if f.ref == nil || v == nil || (old[f] != nil && f.Compare(old[f], v) == 0) {
+			continue
+		}
+		pf, err := t.TBM.parentField(xid, f.ref)
+		if err != nil {
+			return err
+		}
+		if pf.tb == t && e[pf] != nil && pf.Compare(e[pf], v) == 0 { // 引用e自己
+			continue
+		}
+		err = pf.lockValue(xid, v)
+		if err != nil {
+			return err
+		}
+		uuids, _, err := pf.latest(xid, v, utils.NilUUID)
+		if err != nil {
+			return err
+		}
+		if len(uuids) == 0 {
+			return ErrNoReferencedKey
+		}
+	}
+	return nil
+}
+
+/*
+	fixChildren 处理子表中引用了t的记录old的记录, children为引用t的字段, 见children.
+	e为old被update之后的版本, 为nil表示old被删除. self为old的uuid.
+
+	如果restrictOnly为true, 则只检查restrict的外键, 且不考虑self, 用于在修改old之前进行检查.
+*/
+func (t *table) fixChildren(xid tm.XID, children []*field, old, e entry, self utils.UUID, restrictOnly bool) error {
+	for _, cf := range children {
+		pf := t.field(cf.ref.field)
+		v := old[pf]
+		if v == nil {
+			continue
+		}
+		action := cf.ref.onDelete
+		var nv interface{}
+		if e != nil {
+			nv = e[pf]
+			if nv != nil && pf.Compare(nv, v) == 0 {
+				continue
+			}
+			action = cf.ref.onUpdate
+		}
+		if restrictOnly && action != _ACTION_RESTRICT {
+			continue
+		}
+
+		err := pf.lockValue(xid, v)
+		if err != nil {
+			return err
+		}
+		skip := utils.NilUUID
+		if restrictOnly {
+			skip = self
+		}
+		uuids, entries, err := cf.latest(xid, v, skip)
+		if err != nil {
+			return err
+		}
+		if len(uuids) == 0 {
+			continue
+		}
+		if action == _ACTION_RESTRICT {
+			return ErrKeyReferenced
+		}
+		grandChildren, err := t.TBM.children(xid, cf.tb) // 对子表中所有记录的修改共用
+		if err != nil {
+			return err
+		}
+		for i, uuid := range uuids {
+
+			var ok bool
+			if e == nil && action == _ACTION_CASCADE {
+				ok, err = cf.tb.deleteEntry(xid, uuid, entries[i], grandChildren)
+			} else {
+				ne := entry{}
+				for f, v := range entries[i] {
+					ne[f] = v
+				}
+				if action == _ACTION_CASCADE && nv != nil {
+					ne[cf] = nv
+				} else {
+					delete(ne, cf)
+				}
+				ok, err = cf.tb.updateEntry(xid, uuid, entries[i], ne, grandChildren)
+			}
+			if err != nil {
+				return err
+			}
+			if ok == false { // 该记录对xid不可见, 无法修改
+				return ErrKeyReferenced
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+/*
+	index.go 实现了create index和drop index.
+
+	字段的记录和表的记录一样不能被修改, 所以和alter一样(见alter.go), create index和drop index会删除原来的表记录,
+	并插入一条新的表记录, 其中该字段被替换为一条新的字段记录, 二者只有[Index UUID]不同.
+	新的字段记录的[Origin UUID]指向最初的字段记录(见field.go), 于是按照之前的版本写入的记录依然能对应到该字段上.
+
+	create index在建立索引期间不会阻塞对该表的读写:
+	1. 先通过deleteTable获得表记录的锁, 于是同一张表上的create index, drop index和alter依次执行.
+	2. 在原来的表上挂上一个indexBuild, 之后通过原来的表插入的记录(包括update产生的新版本)都会同时被加入新的索引.
+	3. 遍历行目录, 将其中的记录加入新的索引. 行目录中的记录先于其索引被插入(见insertEntry),
+	   所以任一记录要么在遍历时已经在行目录中, 要么在插入时能看到indexBuild.
+	   回填期间被加入索引的uuid会被记录下来, 以免同一条记录被回填和插入者各加入一次.
+	4. 回填结束之后, 将新的表版本加入TBM. 直到xid提交之前, 其他事务依然通过原来的表插入记录,
+	   indexBuild会一直将这些记录加入新的索引; 如果xid回滚, indexBuild会被移除.
+	第1步之后的任何失败都会让xid被自动回滚(见alter.go中的failChange), 以免xid提交之后该表丢失.
+	xid提交之后, 之前开始的repeatable read的事务不能再通过原来的表读写, 否则写入的记录不会被加入新的索引,
+	这些事务再访问该表时会报ErrTableChanged, 见table_manager.go.
+
+	回填会跳过被回滚的记录, 以及已经被已提交的事务删除, 且对xid不可见的记录. 这些记录对于之后能看到新索引的事务都是不可见的.
+
+	drop index只是将该字段替换为没有索引的记录, 原来的索引不会被删除, 只是不再被使用.
+	unique的字段和有外键的字段一定有索引, 所以不能drop它们的索引.
+*/
+package tbm
+
+import (
+	"errors"
+	"nyadb2/backend/im"
+	"nyadb2/backend/parser/statement"
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+	"sync"
+)
+
+var (
+	ErrDuplicatedIndex = errors.New("Duplicated index.")
+	ErrNoThatIndex     = errors.New("No that index.")
+	ErrIndexRequired   = errors.New("Index is required.")
+)
+
+// indexBuild 为正在被xid建立的索引, 见index.go.
+type indexBuild struct {
+	xid      tm.XID
+	f        *field // 原来的表中被建立索引的字段
+	bt       im.BPlusTree
+	inserted map[utils.UUID]bool // 回填期间已经被加入bt的记录, 回填结束之后为nil
+	lock     sync.Mutex
+}
+
+// insert 将记录e加入b的索引, 回填期间已经被加入过的记录会被忽略.
+func (b *indexBuild) insert(e entry, uuid utils.UUID) error {
+	b.lock.Lock()
+	if b.inserted != nil {
+		if b.inserted[uuid] {
+			b.lock.Unlock()
+			return nil
+		}
+		b.inserted[uuid] = true
+	}
+	b.lock.Unlock()
+	return b.bt.Insert(b.f.ValueToKey(e[b.f]), uuid)
+}
+
+func (b *indexBuild) finish() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.inserted = nil
+}
+
+// CreateIndex 为一个已有的字段建立索引, 建立期间其他事务可以继续读写该表.
+func (tbm *tableManager) CreateIndex(xid tm.XID, create *statement.CreateIndex) ([]byte, error) {
+	tbm.lock.Lock()
+	tb, err := tbm.table(xid, create.TableName)
+	tbm.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	f := tb.field(create.FieldName)
+	if f == nil {
+		return nil, ErrNoThatField
+	}
+	if f.IsIndexed() {
+		return nil, ErrDuplicatedIndex
+	}
+
+	index, err := im.Create(tbm.DM)
+	if err != nil {
+		return nil, err
+	}
+	bt, err := im.Load(index, tbm.DM)
+	if err != nil {
+		return nil, err
+	}
+	err = tbm.deleteTable(xid, tb)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &indexBuild{
+		xid:      xid,
+		f:        f,
+		bt:       bt,
+		inserted: make(map[utils.UUID]bool),
+	}
+	tb.addBuild(b)
+	err = tb.backfill(xid, b)
+	if err != nil {
+		return nil, tbm.failChange(xid, tb, err)
+	}
+
+	ntb, err := tb.replaceIndex(xid, f, index, bt)
+	if err != nil {
+		return nil, tbm.failChange(xid, tb, err)
+	}
+	err = tbm.addVersion(xid, ntb)
+	if err != nil {
+		return nil, tbm.failChange(xid, tb, err)
+	}
+	return []byte("create index on " + create.TableName + "(" + create.FieldName + ")"), nil
+}
+
+// DropIndex 删除一个字段的索引.
+func (tbm *tableManager) DropIndex(xid tm.XID, drop *statement.DropIndex) ([]byte, error) {
+	tbm.lock.Lock()
+	tb, err := tbm.table(xid, drop.TableName)
+	tbm.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	f := tb.field(drop.FieldName)
+	if f == nil {
+		return nil, ErrNoThatField
+	}
+	if f.IsIndexed() == false {
+		return nil, ErrNoThatIndex
+	}
+	if f.Unique || f.ref != nil {
+		return nil, ErrIndexRequired
+	}
+
+	ntb, err := tb.replaceIndex(xid, f, utils.NilUUID, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = tbm.deleteTable(xid, tb)
+	if err != nil {
+		return nil, err
+	}
+	err = tbm.addVersion(xid, ntb)
+	if err != nil {
+		return nil, tbm.failChange(xid, tb, err)
+	}
+	return []byte("drop index on " + drop.TableName + "(" + drop.FieldName + ")"), nil
+}
+
+// replaceIndex 返回t的新版本, 其中f被替换为索引为index的新的字段记录, index为NilUUID表示没有索引.
+func (t *table) replaceIndex(xid tm.XID, f *field, index utils.UUID, bt im.BPlusTree) (*table, error) {
+	ntb := t.copy()
+	for _, nf := range ntb.fields {
+		if nf.id() != f.id() {
+			continue
+		}
+		nf.origin = f.id()
+		nf.index, nf.bt = index, bt
+		err := nf.persistSelf(xid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := t.copyChecks(ntb)
+	if err != nil {
+		return nil, err
+	}
+	return ntb, nil
+}
+
+// backfill 将行目录中的记录加入b的索引, 调用者需要先通过addBuild让之后插入的记录也被加入b.
+func (t *table) backfill(xid tm.XID, b *indexBuild) error {
+	uuids, err := t.rowsBt.SearchRange(nil, nil)
+	if err != nil {
+		return err
+	}
+	for _, uuid := range uuids {
+		raw, ok, err := t.TBM.SM.ReadLatest(xid, uuid)
+		if err != nil {
+			return err
+		}
+		if ok == false { // 已经被删除, 但依然在xid的快照中的记录
+			raw, ok, err = t.TBM.SM.Read(xid, uuid)
+			if err != nil {
+				return err
+			}
+			if ok == false {
+				continue
+			}
+		}
+		err = b.insert(t.parseEntry(raw), uuid)
+		if err != nil {
+			return err
+		}
+	}
+	b.finish()
+	return nil
+}
+
+func (t *table) addBuild(b *indexBuild) {
+	t.buildLock.Lock()
+	defer t.buildLock.Unlock()
+	t.builds = append(t.builds, b)
+}
+
+// removeBuilds 移除xid在t上建立的索引, 用于xid回滚时.
+func (t *table) removeBuilds(xid tm.XID) {
+	t.buildLock.Lock()
+	defer t.buildLock.Unlock()
+	var builds []*indexBuild
+	for _, b := range t.builds {
+		if b.xid != xid {
+			builds = append(builds, b)
+		}
+	}
+	t.builds = builds
+}
+
+// insertBuilds 将新插入的记录e加入正在t上建立的索引.
+func (t *table) insertBuilds(e entry, uuid utils.UUID) error {
+	t.buildLock.Lock()
+	builds := t.builds
+	t.buildLock.Unlock()
+	for _, b := range builds {
+		err := b.insert(e, uuid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
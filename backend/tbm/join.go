@@ -0,0 +1,213 @@
+/*
+	join.go 实现了表之间的join.
+
+	join按照语句中的顺序依次进行, 每次将已经得到的记录(外表)与下一张表(内表)进行连接.
+	on的两边, 一边为内表的字段, 另一边为外表的字段, 只支持等值连接.
+
+	如果内表的连接字段上有索引, 则对每一条外表记录, 在该索引上查找对应的内表记录(index nested loop join);
+	否则, 扫描一遍内表, 以连接字段的值建立哈希表, 再用外表记录进行查找(hash join).
+	两种方式都会保持外表记录原来的顺序.
+
+	对于left join, 没有匹配的外表记录也会被保留, 此时内表的字段都为NULL, 即在entry中不存在.
+*/
+package tbm
+
+import (
+	"errors"
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+	"strings"
+)
+
+var (
+	ErrAmbiguousField = errors.New("Ambiguous field.")
+	ErrInvalidJoin    = errors.New("Invalid join.")
+)
+
+// scope 为表达式中可以引用的表. 单表操作时只有一张表, join时为参与join的所有表.
+type scope []*table
+
+// field 返回名为name的字段, name可以是"表名.字段名"的形式.
+// 如果不存在, 则返回ErrNoThatField; 如果多张表中都有该字段, 则返回ErrAmbiguousField.
+func (s scope) field(name string) (*field, error) {
+	tableName := ""
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		tableName, name = name[:i], name[i+1:]
+	}
+
+	var result *field
+	for _, t := range s {
+		if tableName != "" && t.Name != tableName {
+			continue
+		}
+		if fd := t.field(name); fd != nil {
+			if result != nil {
+				return nil, ErrAmbiguousField
+			}
+			result = fd
+		}
+	}
+	if result == nil {
+		return nil, ErrNoThatField
+	}
+	return result, nil
+}
+
+// fields 依次返回所有表的所有字段.
+func (s scope) fields() []*field {
+	var fds []*field
+	for _, t := range s {
+		fds = append(fds, t.fields...)
+	}
+	return fds
+}
+
+// joinFields 返回join中内表t和外表各自的连接字段. s为t以及在它之前的所有表, 即外表加上t.
+func (s scope) joinFields(t *table, name1, name2 string) (*field, *field, error) {
+	fd1, err := s.field(name1)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd2, err := s.field(name2)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fd2.tb == t {
+		fd1, fd2 = fd2, fd1
+	}
+	if fd1.tb != t || fd2.tb == t || kindOf(fd1.FType) != kindOf(fd2.FType) {
+		return nil, nil, ErrInvalidJoin
+	}
+	return fd1, fd2, nil
+}
+
+// join 将外表记录entries与表t进行连接, 连接条件为inner = outer, 其中inner为t的字段.
+func (t *table) join(xid tm.XID, entries []entry, inner, outer *field, left bool) ([]entry, error) {
+	var match func(v interface{}) ([]entry, error)
+	if inner.IsIndexed() {
+		match = func(v interface{}) ([]entry, error) {
+			return t.indexMatch(xid, inner, v)
+		}
+	} else {
+		table, err := t.hashTable(xid, inner)
+		if err != nil {
+			return nil, err
+		}
+		match = func(v interface{}) ([]entry, error) {
+			return table[string(joinKey(v))], nil
+		}
+	}
+
+	var result []entry
+	for _, e := range entries {
+		var matched []entry
+		if v := e[outer]; v != nil {
+			var err error
+			matched, err = match(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, m := range matched {
+			result = append(result, mergeEntry(e, m))
+		}
+		if len(matched) == 0 && left {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+// indexMatch 在inner的索引上查找值等于v的记录.
+func (t *table) indexMatch(xid tm.XID, inner *field, v interface{}) ([]entry, error) {
+	iv, err := inner.toFieldValue(normalize(v))
+	if err == ErrOverflow { // 超出了inner的取值范围, 不可能有匹配的记录
+		return nil, nil
+	}
+	key := inner.ValueToKey(iv)
+	uuids, err := inner.Search(key, utils.SuccKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []entry
+	for _, uuid := range uuids {
+		raw, ok, err := t.TBM.SM.Read(xid, uuid)
+		if err != nil {
+			return nil, err
+		}
+		if ok == false {
+			continue
+		}
+		m := t.parseEntry(raw)
+		if compareValues(normalize(m[inner]), normalize(v)) == 0 { // 索引中的key可能被截断
+			matched = append(matched, m)
+		}
+	}
+	return matched, nil
+}
+
+// hashTable 扫描表t, 以inner的值为key建立哈希表.
+func (t *table) hashTable(xid tm.XID, inner *field) (map[string][]entry, error) {
+	uuids, err := t.rowsBt.SearchRange(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	table := make(map[string][]entry)
+	for _, uuid := range uuids {
+		raw, ok, err := t.TBM.SM.Read(xid, uuid)
+		if err != nil {
+			return nil, err
+		}
+		if ok == false {
+			continue
+		}
+		m := t.parseEntry(raw)
+		if m[inner] == nil { // NULL不与任何值相等
+			continue
+		}
+		key := string(joinKey(m[inner]))
+		table[key] = append(table[key], m)
+	}
+	return table, nil
+}
+
+// normalize 将字段的值转换为值表达式的计算类型, 即uint32转换为uint64, int32转换为int64.
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case uint32:
+		return uint64(n)
+	case int32:
+		return int64(n)
+	}
+	return v
+}
+
+// joinKey 将字段的值转换为哈希表的key, 同一计算类型的字段, 值相等则key相等.
+func joinKey(v interface{}) []byte {
+	switch v := normalize(v).(type) {
+	case uint64:
+		return utils.Uint64ToKey(v)
+	case int64:
+		return utils.Int64ToKey(v)
+	case float64:
+		return utils.Float64ToKey(v)
+	case bool:
+		return utils.BoolToKey(v)
+	case string:
+		return utils.StrToKey(v)
+	}
+	return nil
+}
+
+func mergeEntry(e1, e2 entry) entry {
+	e := make(entry, len(e1)+len(e2))
+	for fd, v := range e1 {
+		e[fd] = v
+	}
+	for fd, v := range e2 {
+		e[fd] = v
+	}
+	return e
+}
@@ -0,0 +1,92 @@
+/*
+	link.go 维护了TBM中用于串联所有表的链表节点.
+
+	一个link的二进制结构为:
+	[Table UUID]   UUID
+	[Next Link]    UUID
+
+	表自身的记录是通过SM插入的, 受MVCC的管理, 插入之后便不能再被修改.
+	但是Drop一张表时, 需要修改它前一个节点的Next, 所以TBM将表之间的链接关系单独存放在link中.
+
+	和B+树的节点一样, link直接建立在DM上, 以SUPER_XID进行读写, 与事务无关.
+	对link的修改只有一种: 将某个link的[Next Link]更新为另一个link, 由DM保证其原子性.
+	如果链表的第一个节点被移除, 则直接更新Booter.
+*/
+package tbm
+
+import (
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+)
+
+const (
+	_LINK_OF_TABLE = 0
+	_LINK_OF_NEXT  = _LINK_OF_TABLE + utils.LEN_UUID
+	_LINK_SIZE     = _LINK_OF_NEXT + utils.LEN_UUID
+)
+
+// createLink 创建一个指向table的link, 其下一个节点为next.
+func (tbm *tableManager) createLink(table, next utils.UUID) (utils.UUID, error) {
+	raw := make([]byte, _LINK_SIZE)
+	utils.PutUUID(raw[_LINK_OF_TABLE:], table)
+	utils.PutUUID(raw[_LINK_OF_NEXT:], next)
+	return tbm.DM.Insert(tm.SUPER_XID, raw)
+}
+
+// readLink 读取uuid对应的link, 返回其指向的table, 以及下一个link.
+func (tbm *tableManager) readLink(uuid utils.UUID) (table, next utils.UUID, err error) {
+	di, ok, err := tbm.DM.Read(uuid)
+	if err != nil {
+		return
+	}
+	utils.Assert(ok)
+	defer di.Release()
+
+	di.RLock()
+	defer di.RUnlock()
+	table = utils.ParseUUID(di.Data()[_LINK_OF_TABLE:])
+	next = utils.ParseUUID(di.Data()[_LINK_OF_NEXT:])
+	return
+}
+
+// setLinkNext 将uuid对应link的下一个节点更新为next.
+func (tbm *tableManager) setLinkNext(uuid, next utils.UUID) error {
+	di, ok, err := tbm.DM.Read(uuid)
+	if err != nil {
+		return err
+	}
+	utils.Assert(ok)
+	defer di.Release()
+
+	di.Before()
+	utils.PutUUID(di.Data()[_LINK_OF_NEXT:], next)
+	di.After(tm.SUPER_XID)
+	return nil
+}
+
+// removeLink 将prev之后的那个link移除, 使prev直接指向next.
+// 如果prev为NilUUID, 表示被移除的是第一个link.
+func (tbm *tableManager) removeLink(prev, next utils.UUID) error {
+	if prev == utils.NilUUID {
+		tbm.updateFirstLinkUUID(next)
+		return nil
+	}
+	return tbm.setLinkNext(prev, next)
+}
+
+// unlink 将uuid对应的link从链表中移除, 如果链表中没有该link, 则什么也不做.
+func (tbm *tableManager) unlink(uuid utils.UUID) error {
+	prev := utils.NilUUID
+	cur := tbm.firstLinkUUID()
+	for cur != utils.NilUUID {
+		_, next, err := tbm.readLink(cur)
+		if err != nil {
+			return err
+		}
+		if cur == uuid {
+			return tbm.removeLink(prev, next)
+		}
+		prev, cur = cur, next
+	}
+	return nil
+}
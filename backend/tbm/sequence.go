@@ -0,0 +1,46 @@
+/*
+	sequence.go 实现了序列, 为default seq()的字段产生值.
+
+	序列存放在DM的一个dataitem中, 内容为一个uint64, 表示下一个将被分配的值, 从1开始.
+	和B+树的boot一样, 序列直接通过DM修改, 不受事务的控制: 分配出去的值即使事务回滚也不会被收回,
+	所以序列只保证产生的值递增且不重复, 不保证连续.
+*/
+package tbm
+
+import (
+	"nyadb2/backend/dm"
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+	"sync"
+)
+
+type sequence struct {
+	dataitem dm.Dataitem
+	lock     sync.Mutex
+}
+
+// createSequence 创建一个序列, 并返回其uuid.
+func createSequence(dm dm.DataManager) (utils.UUID, error) {
+	return dm.Insert(tm.SUPER_XID, utils.Uint64ToRaw(1))
+}
+
+func loadSequence(uuid utils.UUID, dm dm.DataManager) (*sequence, error) {
+	dataitem, ok, err := dm.Read(uuid)
+	if err != nil {
+		return nil, err
+	}
+	utils.Assert(ok == true)
+	return &sequence{dataitem: dataitem}, nil
+}
+
+// next 分配序列的下一个值.
+func (s *sequence) next() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v := utils.ParseUint64(s.dataitem.Data())
+	s.dataitem.Before()
+	copy(s.dataitem.Data(), utils.Uint64ToRaw(v+1))
+	s.dataitem.After(tm.SUPER_XID)
+	return v
+}
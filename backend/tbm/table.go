@@ -2,36 +2,71 @@
    table.go 维护了表的结构.
    表的二进制结构如下:
    	[Table Name]      string
-   	[Next Table]      UUID
-   	[Field1 UUID, Field2 UUID, ..., FieldN UUID]
+   	[Rows UUID]       UUID
+   	[Check Number]    uint32
+   	[Check1, Check2, ..., CheckM]
+   	[Version Number]  uint32
+   	[Version0, Version1, ..., VersionK]
+
+   每个check约束的格式为[Check Name] string, [Check Text] string, 见check.go.
+   每个版本为该版本的表结构中的字段, 格式为[Field Number] uint32, [Field1 UUID, Field2 UUID, ..., FieldN UUID].
+   表的当前版本为最后一个版本, 之前的版本用于解析按照旧的表结构写入的记录, 见alter.go.
+
+   表与表之间的链接关系不存放在表中, 而是存放在link中, 见link.go.
+
+   表中一条记录的二进制结构如下:
+   	[Version]         uint32, 写入该记录时表结构的版本
+   	[Null Bitmap]     (字段数+7)/8 bytes, 第i个bit为1表示第i个字段为NULL
+   	[Value1, Value2, ..., ValueN] 依次为该版本中每个非NULL字段的值
+
+   [Rows UUID]为该表行目录的bootUUID. 行目录是一棵以记录的uuid为key的B+树,
+   表中每条记录(包括每次update产生的新版本)都会被加入行目录, 于是即使表没有任何索引,
+   也能够通过行目录扫描到表中所有的记录.
+   和索引一样, 行目录中也会留下被删除和被回滚的记录, SM会保证它们不可见.
 */
 package tbm
 
 import (
 	"errors"
+	"nyadb2/backend/im"
 	"nyadb2/backend/parser/statement"
 	"nyadb2/backend/tm"
 	"nyadb2/backend/utils"
+	"sort"
+	"sync"
 )
 
 var (
 	ErrInvalidValues   = errors.New("Invalid values.")
 	ErrInvalidLogOP    = errors.New("Invalid logic operation.")
 	ErrNoThatField     = errors.New("No that field.")
-	ErrFieldHasNoField = errors.New("Field has no index.")
+	ErrDuplicatedField = errors.New("Duplicated field.")
+	ErrDuplicatedKey   = errors.New("Duplicated key.")
+	ErrInsertFailed    = errors.New("Insert failed, transaction must be aborted.")
+	ErrUpdateFailed    = errors.New("Update failed, transaction must be aborted.")
+	ErrDeleteFailed    = errors.New("Delete failed, transaction must be aborted.")
 )
 
-// map[Field]Value
-type entry map[string]interface{}
+// map[Field]Value, 值为NULL的字段不在map中.
+type entry map[*field]interface{}
 
 type table struct {
 	TBM      *tableManager
 	SelfUUID utils.UUID
 
 	Name   string
-	status byte
-	Next   utils.UUID
+	link   utils.UUID // 指向该表的link
+	rows   utils.UUID // 行目录的bootUUID
+	rowsBt im.BPlusTree
 	fields []*field
+	checks []*check
+
+	schema  *schema // 该表所有版本的字段, 与同一张表的其他表记录共用
+	version uint32  // 该表的版本, 即fields在schema中的版本号
+	removed bool    // 删除该表的事务是否已经提交, 见table_manager.go
+
+	builds    []*indexBuild // 正在该表上建立的索引, 见index.go
+	buildLock sync.Mutex
 }
 
 /*
@@ -39,13 +74,17 @@ type table struct {
 	该函数只会在TM启动时被调用.
 	因为该函数被调用时, 为单线程, 所以不会有ErrCacheFull之类的错误, 因此一旦遇到错误, 那一定
 	是不可恢复的错误, 应该直接panic.
+
+	如果该表已经被删除, 则返回false.
 */
-func LoadTable(tbm *tableManager, uuid utils.UUID) *table {
+func LoadTable(tbm *tableManager, uuid utils.UUID) (*table, bool) {
 	raw, ok, err := tbm.SM.Read(tm.SUPER_XID, uuid)
-	utils.Assert(ok)
 	if err != nil {
 		panic(err)
 	}
+	if ok == false {
+		return nil, false
+	}
 
 	tb := &table{
 		TBM:      tbm,
@@ -53,7 +92,7 @@ func LoadTable(tbm *tableManager, uuid utils.UUID) *table {
 	}
 
 	tb.parseSelf(raw)
-	return tb
+	return tb, true
 }
 
 // parseSelf 通过raw解析出table自己的信息.
@@ -61,23 +100,83 @@ func (t *table) parseSelf(raw []byte) {
 	var pos, shift int
 	t.Name, shift = utils.ParseVarStr(raw[pos:])
 	pos += shift
-	t.Next = utils.ParseUUID(raw[pos:])
+	t.rows = utils.ParseUUID(raw[pos:])
 	pos += utils.LEN_UUID
 
-	for pos < len(raw) {
-		uuid := utils.ParseUUID(raw[pos:])
-		pos += utils.LEN_UUID
-		f := LoadField(t, uuid)
-		t.fields = append(t.fields, f)
+	var err error
+	t.rowsBt, err = im.Load(t.rows, t.TBM.DM)
+	if err != nil {
+		panic(err)
+	}
+
+	n := int(utils.ParseUint32(raw[pos:]))
+	pos += 4
+	names, texts := make([]string, n), make([]string, n)
+	for i := 0; i < n; i++ {
+		names[i], shift = utils.ParseVarStr(raw[pos:])
+		pos += shift
+		texts[i], shift = utils.ParseVarStr(raw[pos:])
+		pos += shift
+	}
+
+	t.schema = new(schema)
+	loaded := make(map[utils.UUID]*field) // 被回滚的字段为nil
+	versions := utils.ParseUint32(raw[pos:])
+	pos += 4
+	for v := uint32(0); v < versions; v++ {
+		fn := int(utils.ParseUint32(raw[pos:]))
+		pos += 4
+		fields := make([]*field, fn)
+		aborted := false
+		for i := range fields {
+			uuid := utils.ParseUUID(raw[pos:])
+			pos += utils.LEN_UUID
+			f, ok := loaded[uuid]
+			if ok == false {
+				f, _ = LoadField(t, uuid)
+				loaded[uuid] = f
+			}
+			fields[i] = f
+			aborted = aborted || f == nil
+		}
+		if aborted { // 被回滚的版本, 按照它写入的记录不会再被读到
+			fields = nil
+		}
+		t.version = t.schema.add(fields)
+		t.fields = fields
+	}
+
+	for i := 0; i < n; i++ { // check引用了字段, 所以最后再编译
+		c, err := t.loadCheck(names[i], texts[i])
+		if err != nil {
+			panic(err)
+		}
+		t.checks = append(t.checks, c)
 	}
 }
 
 // CreateTable 创建一张表, 并返回其指针.
-func CreateTable(tbm *tableManager, next utils.UUID, xid tm.XID, create *statement.Create) (*table, error) {
+// 调用者需要持有tbm.lock.
+func CreateTable(tbm *tableManager, xid tm.XID, create *statement.Create) (*table, error) {
+	refs, err := tbm.references(xid, create)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := im.Create(tbm.DM)
+	if err != nil {
+		return nil, err
+	}
+	rowsBt, err := im.Load(rows, tbm.DM)
+	if err != nil {
+		return nil, err
+	}
+
 	tb := &table{
-		TBM:  tbm,
-		Name: create.TableName,
-		Next: next,
+		TBM:    tbm,
+		Name:   create.TableName,
+		rows:   rows,
+		rowsBt: rowsBt,
+		schema: new(schema),
 	}
 
 	for i := 0; i < len(create.FieldName); i++ {
@@ -90,14 +189,29 @@ func CreateTable(tbm *tableManager, next utils.UUID, xid tm.XID, create *stateme
 				break
 			}
 		}
-		field, err := CreateField(tb, xid, fname, ftype, indexed)
+		var flags byte
+		if create.NotNull[i] {
+			flags |= _FLAG_NOT_NULL
+		}
+		if create.Unique[i] {
+			flags |= _FLAG_UNIQUE
+		}
+		if create.PrimaryKey == fname {
+			flags |= _FLAG_PRIMARY
+		}
+		field, err := CreateField(tb, xid, fname, ftype, flags, create.Default[i], refs[i], indexed)
 		if err != nil {
 			return nil, err
 		}
 		tb.fields = append(tb.fields, field)
 	}
+	err = tb.createChecks(create)
+	if err != nil {
+		return nil, err
+	}
+	tb.version = tb.schema.add(tb.fields)
 
-	err := tb.persistSelf(xid)
+	err = tb.persistSelf(xid)
 	if err != nil {
 		return nil, err
 	}
@@ -105,13 +219,16 @@ func CreateTable(tbm *tableManager, next utils.UUID, xid tm.XID, create *stateme
 	return tb, nil
 }
 
-// persist 将t自身持久化到磁盘上, 该函数只会在CreateTable的时候被调用
+// persist 将t自身持久化到磁盘上, 该函数只会在CreateTable和alter的时候被调用
 func (t *table) persistSelf(xid tm.XID) error {
 	raw := utils.VarStrToRaw(t.Name)
-	raw = append(raw, utils.UUIDToRaw(t.Next)...)
-	for _, f := range t.fields {
-		raw = append(raw, utils.UUIDToRaw(f.SelfUUID)...)
+	raw = append(raw, utils.UUIDToRaw(t.rows)...)
+	raw = append(raw, utils.Uint32ToRaw(uint32(len(t.checks)))...)
+	for _, c := range t.checks {
+		raw = append(raw, utils.VarStrToRaw(c.name)...)
+		raw = append(raw, utils.VarStrToRaw(c.text)...)
 	}
+	raw = append(raw, t.schema.toRaw(t.version+1)...)
 
 	self, err := t.TBM.SM.Insert(xid, raw)
 	if err != nil {
@@ -122,62 +239,126 @@ func (t *table) persistSelf(xid tm.XID) error {
 	return nil
 }
 
+// field 返回名为name的字段, 如果不存在, 则返回nil.
+func (t *table) field(name string) *field {
+	for _, f := range t.fields {
+		if f.FName == name {
+			return f
+		}
+	}
+	return nil
+}
+
 func (t *table) Print() string {
 	str := "{"
 	str += t.Name + ": "
 	for i := 0; i < len(t.fields); i++ {
 		str += t.fields[i].Print()
-		if i == len(t.fields)-1 {
-			str += "}"
-		} else {
+		if i < len(t.fields)-1 {
 			str += ", "
 		}
 	}
+	for _, c := range t.checks {
+		str += ", " + c.Print()
+	}
+	str += "}"
 	return str
 }
 
+/*
+	Delete 对该表执行delete语句, 返回删除的行数.
+	和Update一样, 所有满足where的行都会先检查子表中restrict的外键, 全部通过后才开始删除.
+	删除开始之后如果出错(如cascade失败), 会让SM自动回滚xid.
+*/
 func (t *table) Delete(xid tm.XID, delete *statement.Delete) (int, error) {
-	uuids, err := t.parseWhere(delete.Where)
+	uuids, _, exp, err := t.parseWhere(delete.Where, nil)
+	if err != nil {
+		return 0, err
+	}
+	children, err := t.TBM.children(xid, t)
 	if err != nil {
 		return 0, err
 	}
 
-	count := 0
+	var selfs []utils.UUID
+	var entries []entry
 	for _, uuid := range uuids {
-		ok, err := t.TBM.SM.Delete(xid, uuid)
+		raw, ok, err := t.TBM.SM.Read(xid, uuid)
 		if err != nil {
 			return 0, err
 		}
+		if ok == false {
+			continue
+		}
+		e := t.parseEntry(raw)
+		matched, err := match(exp, e) // 再次检查是否满足where
+		if err != nil {
+			return 0, err
+		}
+		if matched == false {
+			continue
+		}
+
+		err = t.fixChildren(xid, children, e, nil, uuid, true)
+		if err != nil {
+			return 0, err
+		}
+		selfs = append(selfs, uuid)
+		entries = append(entries, e)
+	}
+
+	count := 0
+	for i, uuid := range selfs {
+		ok, err := t.removeEntry(xid, uuid, entries[i], children)
+		if err != nil {
+			t.TBM.SM.Fail(xid, ErrDeleteFailed)
+			return 0, err
+		}
 		if ok {
 			count++
 		}
 	}
-
 	return count, nil
 }
 
+/*
+	Update 对该表执行update语句, 返回更新的行数.
+	所有满足where的行都会先计算出新的版本, 并检查所有的约束, 全部通过后才开始修改.
+	unique以整条语句执行之后的状态为准, 见checkUpdateUnique, 所以set id = id + 1这样的语句,
+	即使中途会与其他被更新的行暂时重复, 也可以执行.
+	修改开始之后, 已经被删除的旧版本无法恢复, 所以如果某一行失败(如cascade失败),
+	会让SM自动回滚xid, 以免xid提交时只留下一部分修改.
+*/
 func (t *table) Update(xid tm.XID, update *statement.Update) (int, error) {
-	uuids, err := t.parseWhere(update.Where)
+	uuids, _, exp, err := t.parseWhere(update.Where, nil)
 	if err != nil {
 		return 0, err
 	}
 
-	var fd *field
-	for _, f := range t.fields {
-		if f.FName == update.FieldName {
-			fd = f
-			break
+	fds := make([]*field, len(update.Sets))
+	exps := make([]valueExp, len(update.Sets))
+	for i, set := range update.Sets {
+		fds[i] = t.field(set.FieldName)
+		if fds[i] == nil {
+			return 0, ErrNoThatField
+		}
+		for j := 0; j < i; j++ {
+			if fds[j] == fds[i] {
+				return 0, ErrDuplicatedField
+			}
+		}
+		exps[i], err = scope{t}.compileValueExp(set.Value, kindOf(fds[i].FType))
+		if err != nil {
+			return 0, err
 		}
 	}
-	if fd == nil {
-		return 0, ErrNoThatField
-	}
-	v, err := fd.StrToValue(update.Value)
+	children, err := t.TBM.children(xid, t)
 	if err != nil {
 		return 0, err
 	}
 
-	count := 0
+	var selfs []utils.UUID
+	var olds, entries []entry
 	for _, uuid := range uuids {
 		raw, ok, err := t.TBM.SM.Read(xid, uuid)
 		if err != nil {
@@ -187,42 +368,207 @@ func (t *table) Update(xid tm.XID, update *statement.Update) (int, error) {
 			continue
 		}
 
-		_, err = t.TBM.SM.Delete(xid, uuid) // 删除原来的entry
+		old := t.parseEntry(raw) // 读取并解析entry
+		matched, err := match(exp, old)
+		if err != nil {
+			return 0, err
+		}
+		if matched == false {
+			continue
+		}
+
+		// 所有的set都基于原来的entry计算
+		values := make([]interface{}, len(exps))
+		for i, exp := range exps {
+			v, err := exp.eval(old)
+			if err != nil {
+				return 0, err
+			}
+			values[i], err = fds[i].toFieldValue(v)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		e := entry{}
+		for f, v := range old {
+			e[f] = v
+		}
+		for i, fd := range fds { // 更新entry
+			if values[i] == nil {
+				delete(e, fd)
+			} else {
+				e[fd] = values[i]
+			}
+		}
+		err = t.checkUpdate(xid, uuid, old, e, children)
 		if err != nil {
 			return 0, err
 		}
+		selfs = append(selfs, uuid)
+		olds = append(olds, old)
+		entries = append(entries, e)
+	}
+	err = t.checkUpdateUnique(xid, selfs, entries)
+	if err != nil {
+		return 0, err
+	}
 
-		e := t.parseEntry(raw) // 读取并解析entry
-		e[fd.FName] = v        // 更新entry
-		raw = t.entryToRaw(e)  // 将新entry存储进DB
-		uuid, err = t.TBM.SM.Insert(xid, raw)
+	count := 0
+	for i, uuid := range selfs {
+		ok, err := t.replaceEntry(xid, uuid, olds[i], entries[i], children)
 		if err != nil {
+			t.TBM.SM.Fail(xid, ErrUpdateFailed)
 			return 0, err
 		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
 
-		count++
+// deleteEntry 删除uuid对应的记录e, 并处理子表中引用它的记录, children为引用t的字段, 见foreign_key.go.
+// 如果该记录对xid不可见, 或者已经被xid删除, 则返回false.
+func (t *table) deleteEntry(xid tm.XID, uuid utils.UUID, e entry, children []*field) (bool, error) {
+	err := t.fixChildren(xid, children, e, nil, uuid, true)
+	if err != nil {
+		return false, err
+	}
+	return t.removeEntry(xid, uuid, e, children)
+}
 
-		for _, f := range t.fields { // 更新对应的索引
-			if f.IsIndexed() {
-				err := f.Insert(e[f.FName], uuid)
-				if err != nil {
-					return 0, err
-				}
-			}
+// removeEntry 删除uuid对应的记录e, 并处理子表中引用它的记录, 调用者需要已经检查过restrict的外键.
+func (t *table) removeEntry(xid tm.XID, uuid utils.UUID, e entry, children []*field) (bool, error) {
+	ok, err := t.TBM.SM.Delete(xid, uuid)
+	if err != nil || ok == false {
+		return false, err
+	}
+	t.TBM.addDead(xid, t, uuid, e)
+	return true, t.fixChildren(xid, children, e, nil, uuid, false)
+}
+
+// updateEntry 将uuid对应的记录old更新为e, 即删除old, 并将e作为新的记录插入.
+// 所有的约束都在删除old之前检查, 以免失败时丢失该记录. children和返回值的含义同deleteEntry.
+func (t *table) updateEntry(xid tm.XID, uuid utils.UUID, old, e entry, children []*field) (bool, error) {
+	err := t.checkUpdate(xid, uuid, old, e, children)
+	if err != nil {
+		return false, err
+	}
+	err = t.checkUnique(xid, e, uuid)
+	if err != nil {
+		return false, err
+	}
+	return t.replaceEntry(xid, uuid, old, e, children)
+}
+
+// checkUpdate 检查将uuid对应的记录old更新为e时, 除unique之外的约束, 包括子表中restrict的外键.
+func (t *table) checkUpdate(xid tm.XID, uuid utils.UUID, old, e entry, children []*field) error {
+	for _, f := range t.fields {
+		if f.NotNull && e[f] == nil {
+			return ErrNullValue
 		}
 	}
+	err := t.verify(e)
+	if err != nil {
+		return err
+	}
+	err = t.checkReferences(xid, e, old)
+	if err != nil {
+		return err
+	}
+	return t.fixChildren(xid, children, old, e, uuid, true)
+}
 
-	return count, nil
+// replaceEntry 删除old, 并将e作为新的记录插入, 调用者需要已经检查过所有的约束.
+// 如果删除old之后出错, old不会被恢复, 调用者需要让xid回滚.
+func (t *table) replaceEntry(xid tm.XID, uuid utils.UUID, old, e entry, children []*field) (bool, error) {
+	ok, err := t.TBM.SM.Delete(xid, uuid) // 删除原来的entry
+	if err != nil || ok == false {
+		return false, err
+	}
+	t.TBM.addDead(xid, t, uuid, old)
+	_, err = t.insertEntry(xid, e) // 将新entry存储进DB
+	if err != nil {
+		return false, err
+	}
+	return true, t.fixChildren(xid, children, old, e, uuid, false)
 }
 
-func (t *table) Read(xid tm.XID, read *statement.Read) (string, error) {
-	uuids, err := t.parseWhere(read.Where)
+/*
+	Read 对该表执行read语句.
+
+	如果有order by, 且parseWhere恰好选择了第一个排序字段的索引进行查找, 那么读出的记录已经按照该字段
+	升序排列, 不需要再排序(被截断的长string除外, 见sortEntries).
+	如果读出的记录本身就是有序的, 且不是聚合查询, 那么在读到offset+limit条之后, 就可以停止.
+
+	记录在排序之后才被分组, 所以聚合查询中, 各组也是有序的. 为此, 聚合查询中只能按照group by中的字段排序.
+
+	joins为read.Joins中依次对应的表. 有join时, t的记录先按照where在t上的区间读出, 与各表join之后,
+	再计算完整的where表达式. 因为join会保持外表记录的顺序, 所以通过索引读出的记录依然是有序的.
+
+	如果p不为nil, 那么只执行到得出满足where的记录为止, 并将执行计划记录在p中, 见explain.go.
+*/
+func (t *table) Read(xid tm.XID, read *statement.Read, joins []*table, p *plan) (string, error) {
+	s := append(scope{t}, joins...)
+	q, err := s.compileRead(read)
+	if err != nil {
+		return "", err
+	}
+	orders, err := s.orderFields(read.OrderBy)
 	if err != nil {
 		return "", err
 	}
+	if q.aggregate {
+		for _, o := range orders {
+			if q.isGrouped(o.fd) == false {
+				return "", ErrNotGrouped
+			}
+		}
+	}
 
-	result := ""
+	inners := make([]*field, len(joins))
+	outers := make([]*field, len(joins))
+	for i, join := range read.Joins {
+		inners[i], outers[i], err = s[:i+2].joinFields(joins[i], join.Field1, join.Field2)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var exp whereExp
+	if read.Where != nil {
+		exp, err = s.compileExp(read.Where.Exp)
+		if err != nil {
+			return "", err
+		}
+	}
+	var prefer *field
+	if len(orders) > 0 && orders[0].fd.tb == t {
+		prefer = orders[0].fd
+	}
+
+	fd, ivs := t.choose(exp, prefer)
+	uuids, err := t.search(fd, ivs)
+	if err != nil {
+		return "", err
+	}
+	if p != nil {
+		p.tb, p.fd, p.ivs, p.candidates = t, fd, ivs, len(uuids)
+		for i := range joins {
+			p.joins = append(p.joins, joinPlan{joins[i], inners[i], outers[i]})
+		}
+	}
+	indexOrdered := len(orders) == 1 && fd == orders[0].fd
+	// explain时需要得到不考虑limit的实际记录数, 所以不能提前结束
+	canStop := p == nil && q.aggregate == false && len(joins) == 0 && (len(orders) == 0 ||
+		(indexOrdered && orders[0].desc == false && fd.FType != "string"))
+
+	var entries []entry
 	for _, uuid := range uuids {
+		if canStop && read.Limit >= 0 && len(entries) >= read.Offset+read.Limit {
+			break
+		}
 		raw, ok, err := t.TBM.SM.Read(xid, uuid)
 		if err != nil {
 			return "", err
@@ -231,165 +577,498 @@ func (t *table) Read(xid tm.XID, read *statement.Read) (string, error) {
 			continue
 		}
 		e := t.parseEntry(raw)
-		result += t.entryPrint(e) + "\n"
+		if len(joins) == 0 {
+			matched, err := match(exp, e)
+			if err != nil {
+				return "", err
+			}
+			if matched == false {
+				continue
+			}
+		}
+		entries = append(entries, e)
 	}
 
+	if len(joins) > 0 {
+		for i, join := range read.Joins {
+			entries, err = joins[i].join(xid, entries, inners[i], outers[i], join.Left)
+			if err != nil {
+				return "", err
+			}
+		}
+		if exp != nil {
+			var tmp []entry
+			for _, e := range entries {
+				matched, err := match(exp, e)
+				if err != nil {
+					return "", err
+				}
+				if matched {
+					tmp = append(tmp, e)
+				}
+			}
+			entries = tmp
+		}
+	}
+	if p != nil {
+		p.rows = len(entries)
+		return "", nil
+	}
+
+	if len(orders) > 0 {
+		sortEntries(entries, orders, indexOrdered)
+	}
+
+	groups, err := q.filter(q.groupEntries(entries))
+	if err != nil {
+		return "", err
+	}
+
+	if read.Offset >= len(groups) {
+		groups = nil
+	} else {
+		groups = groups[read.Offset:]
+	}
+	if read.Limit >= 0 && read.Limit < len(groups) {
+		groups = groups[:read.Limit]
+	}
+
+	result := ""
+	for _, g := range groups {
+		str, err := q.groupPrint(g)
+		if err != nil {
+			return "", err
+		}
+		result += str + "\n"
+	}
 	return result, nil
 }
 
-// parseWhere 对where语句进行解析, 返回field, 该where对应区间内的uuid
-func (t *table) parseWhere(where *statement.Where) ([]utils.UUID, error) {
-	var l0, r0, l1, r1 utils.UUID
-	single := false
-	var err error
-	var fd *field
+type order struct {
+	fd   *field
+	desc bool
+}
 
-	if where == nil {
-		for _, f := range t.fields {
-			if f.IsIndexed() {
-				fd = f
-				break
+// orderFields 将order by语句转换为对应的字段.
+func (s scope) orderFields(orderBy []*statement.OrderBy) ([]order, error) {
+	orders := make([]order, len(orderBy))
+	for i, ob := range orderBy {
+		fd, err := s.field(ob.Field)
+		if err != nil {
+			return nil, err
+		}
+		orders[i].fd = fd
+		orders[i].desc = ob.Desc
+	}
+	return orders, nil
+}
+
+// sortEntries 将entries按照orders排序.
+// 如果indexOrdered为true, 表示entries已经按照orders[0]升序排列, 只需要检查一遍, 必要时翻转即可.
+// 由于索引中过长的string会被截断, 所以检查不通过时, 依然需要排序.
+// NULL被视为比任何值都小.
+func sortEntries(entries []entry, orders []order, indexOrdered bool) {
+	less := func(i, j int) bool {
+		for _, o := range orders {
+			v1, v2 := entries[i][o.fd], entries[j][o.fd]
+			var cmp int
+			switch {
+			case v1 == nil && v2 == nil:
+				cmp = 0
+			case v1 == nil:
+				cmp = -1
+			case v2 == nil:
+				cmp = 1
+			default:
+				cmp = o.fd.Compare(v1, v2)
+			}
+			if cmp != 0 {
+				return (cmp < 0) != o.desc
 			}
 		}
-		l0, r0 = 0, utils.INF
-		single = true
-	} else if where != nil {
-		for _, f := range t.fields {
-			if f.FName == where.SingleExp1.Field {
-				if f.IsIndexed() == false {
-					return nil, ErrFieldHasNoField
-				}
-				fd = f
-				break
+		return false
+	}
+
+	if indexOrdered {
+		if orders[0].desc {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
 			}
 		}
-		if fd == nil {
-			return nil, ErrNoThatField
+		if sort.SliceIsSorted(entries, less) {
+			return
 		}
+	}
+	sort.SliceStable(entries, less)
+}
 
-		l0, r0, l1, r1, single, err = t.calWhere(fd, where)
+// parseWhere 编译where语句, 并通过search查找, 返回查找到的uuid, 选中的索引字段, 以及编译后的where表达式.
+// 如果where为nil, 则返回的表达式也为nil.
+func (t *table) parseWhere(where *statement.Where, prefer *field) ([]utils.UUID, *field, whereExp, error) {
+	var exp whereExp
+	if where != nil {
+		var err error
+		exp, err = scope{t}.compileExp(where.Exp)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
 	}
-
-	uuids, err := fd.Search(l0, r0)
+	fd, ivs := t.choose(exp, prefer)
+	uuids, err := t.search(fd, ivs)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
+	}
+	return uuids, fd, exp, nil
+}
+
+/*
+	choose 根据where表达式exp, 选出一个索引, 并返回该索引字段, 以及需要在其上查找的区间.
+	exp可以为nil, 也可以引用其他表的字段, 此时只根据其在t的字段上的区间进行选择.
+	如果prefer不为nil, 那么在代价相同时, 优先选择prefer的索引, 即使它需要扫描整个索引.
+	如果没有索引能够缩小查找的范围, 则应该通过行目录扫描整张表, 此时返回的字段为nil.
+*/
+func (t *table) choose(exp whereExp, prefer *field) (*field, []interval) {
+	var fd *field
+	var ivs []interval
+	exact := true
+	for _, f := range t.fields {
+		if f.IsIndexed() == false {
+			continue
+		}
+		fivs, fexact := fullRange(), true
+		if exp != nil {
+			fivs, fexact = exp.ranges(f)
+		}
+		cost, minCost := rangesCost(fivs, fexact), rangesCost(ivs, exact)
+		if fd == nil || cost < minCost || (cost == minCost && f == prefer) {
+			fd, ivs, exact = f, fivs, fexact
+		}
 	}
-	if single == false {
-		tmp, err := fd.Search(l1, r1)
+	if fd == nil || (isFullRange(ivs) && fd != prefer) { // 扫描整张表
+		return nil, nil
+	}
+	return fd, ivs
+}
+
+/*
+	search 在fd的索引上查找ivs中的uuid, 如果fd为nil, 则通过行目录返回整张表的uuid.
+
+	返回的uuid只是候选, 调用者必须对每条读出的记录再计算一次where表达式, 只有满足的记录才能被
+	返回, 更新或者删除. 因为索引中过长的key会被截断, 且索引中还留有旧版本的key, 即使区间是精确的,
+	也不能完全相信索引.
+
+	通过索引查找时, 返回的uuid按照该字段的key升序排列.
+*/
+func (t *table) search(fd *field, ivs []interval) ([]utils.UUID, error) {
+	if fd == nil {
+		return t.rowsBt.SearchRange(nil, nil)
+	}
+
+	var uuids []utils.UUID
+	for _, iv := range ivs {
+		tmp, err := fd.Search(iv.left, iv.right)
 		if err != nil {
 			return nil, err
 		}
 		uuids = append(uuids, tmp...)
 	}
-
 	return uuids, nil
 }
 
-// calWhere 计算该where语句所表示的key的区间.
-// 由于where或许有or, 所以区间可能为2个.
-func (t *table) calWhere(fd *field, where *statement.Where) (l0, r0, l1, r1 utils.UUID, single bool, err error) {
-	if where.LogicOp == "" { // single
-		single = true
-		l0, r0, err = fd.CalExp(where.SingleExp1)
-	} else if where.LogicOp == "or" {
-		single = false
-		l0, r0, err = fd.CalExp(where.SingleExp1)
+/*
+	Insert 对该表执行insert语句, 返回插入的行数.
+	所有的行都会先被转换为entry并通过verify, 全部成功后才开始插入.
+	unique和外键约束需要依次检查, 以发现同一条语句中重复的值. 如果某一行插入失败,
+	已经插入的行会被xid删除, 于是该语句要么插入所有的行, 要么不插入任何行.
+*/
+func (t *table) Insert(xid tm.XID, insert *statement.Insert) (int, error) {
+	fds, err := t.insertFields(insert.Fields)
+	if err != nil {
+		return 0, err
+	}
+
+	entries := make([]entry, len(insert.Values))
+	for i, values := range insert.Values { // 将insert的values转换为entry
+		var kinds []string
+		if insert.Kinds != nil {
+			kinds = insert.Kinds[i]
+		}
+		entries[i], err = t.strToEntry(fds, values, kinds)
 		if err != nil {
-			return
+			return 0, err
 		}
-		l1, r1, err = fd.CalExp(where.SingleExp2)
-	} else if where.LogicOp == "and" {
-		single = true
-		l0, r0, err = fd.CalExp(where.SingleExp1)
+		err = t.verify(entries[i])
 		if err != nil {
-			return
+			return 0, err
 		}
-		l1, r1, err = fd.CalExp(where.SingleExp2)
-		// 合并[l0, r0], [l1, r1]两个区间
-		if l1 > l0 {
-			l0 = l1
+	}
+
+	uuids := make([]utils.UUID, 0, len(entries))
+	for _, e := range entries {
+		uuid, err := t.insertChecked(xid, e)
+		if uuid != utils.NilUUID {
+			uuids = append(uuids, uuid)
 		}
-		if r1 < r0 {
-			r0 = r1
+		if err != nil {
+			t.undoInsert(xid, uuids, entries)
+			return 0, err
 		}
-		return
-	} else {
-		err = ErrInvalidLogOP
 	}
-	return
+	return len(entries), nil
 }
 
-// Insert 对该表执行insert语句.
-func (t *table) Insert(xid tm.XID, insert *statement.Insert) error {
-	e, err := t.strToEntry(insert.Values) // 将insert的values转换为entry
+// insertChecked 检查e的unique和外键约束, 并将其插入. 只要e已经被插入到DB中, 就返回其uuid.
+func (t *table) insertChecked(xid tm.XID, e entry) (utils.UUID, error) {
+	err := t.checkUnique(xid, e, utils.NilUUID)
 	if err != nil {
-		return err
+		return utils.NilUUID, err
 	}
+	err = t.checkReferences(xid, e, nil)
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	return t.insertEntry(xid, e)
+}
 
+/*
+	undoInsert 删除xid在一条insert语句中已经插入的记录uuids, entries[i]为uuids[i]的内容.
+	如果删除失败, 则让SM自动回滚xid, 以免xid提交时只留下一部分记录.
+*/
+func (t *table) undoInsert(xid tm.XID, uuids []utils.UUID, entries []entry) {
+	for i, uuid := range uuids {
+		ok, err := t.TBM.SM.Delete(xid, uuid)
+		if err != nil || ok == false {
+			t.TBM.SM.Fail(xid, ErrInsertFailed)
+			return
+		}
+		t.TBM.addDead(xid, t, uuid, entries[i])
+	}
+}
+
+// insertFields 返回insert语句中的值依次对应的字段, 如果names为空, 则返回表的所有字段.
+func (t *table) insertFields(names []string) ([]*field, error) {
+	if len(names) == 0 {
+		return t.fields, nil
+	}
+
+	fds := make([]*field, len(names))
+	for i, name := range names {
+		fds[i] = t.field(name)
+		if fds[i] == nil {
+			return nil, ErrNoThatField
+		}
+		for j := 0; j < i; j++ {
+			if fds[j] == fds[i] {
+				return nil, ErrDuplicatedField
+			}
+		}
+	}
+	return fds, nil
+}
+
+// insertEntry 将e插入到DB中, 并更新行目录和索引, 调用者需要先通过verify, checkUnique和checkReferences检查约束.
+// 只要e已经被插入到DB中, 即使之后更新行目录或索引失败, 也会返回其uuid.
+func (t *table) insertEntry(xid tm.XID, e entry) (utils.UUID, error) {
 	raw := t.entryToRaw(e) // 将该entry插入到DB
 	uuid, err := t.TBM.SM.Insert(xid, raw)
 	if err != nil {
-		return err
+		return utils.NilUUID, err
+	}
+	err = t.rowsBt.Insert(utils.UUIDToKey(uuid), uuid) // 加入行目录
+	if err != nil {
+		return uuid, err
 	}
 
 	for _, f := range t.fields { // 更新对应的索引
 		if f.IsIndexed() {
-			err := f.Insert(e[f.FName], uuid)
+			err := f.Insert(e[f], uuid)
+			if err != nil {
+				return uuid, err
+			}
+		}
+	}
+	return uuid, t.insertBuilds(e, uuid)
+}
+
+// checkUnique 检查e在每个unique字段上的值是否与其他记录重复, 见field.go.
+// 检查之前会获得这些值的锁, 直到事务结束才释放. self为update时e原来的版本, 不与之比较.
+func (t *table) checkUnique(xid tm.XID, e entry, self utils.UUID) error {
+	for _, f := range t.fields {
+		v := e[f]
+		if f.Unique == false || v == nil {
+			continue
+		}
+		err := f.lockValue(xid, v)
+		if err != nil {
+			return err
+		}
+		uuids, _, err := f.latest(xid, v, self)
+		if err != nil {
+			return err
+		}
+		if len(uuids) > 0 {
+			return ErrDuplicatedKey
+		}
+	}
+	return nil
+}
+
+/*
+	checkUpdateUnique 检查一条update语句执行之后, 各unique字段上的值是否重复, selfs[i]将被更新为entries[i].
+	新的值之间不能重复, 也不能与不被该语句更新的记录重复; 与被更新的记录原来的值相同则没有关系,
+	因为那些值在语句执行之后就不存在了. 和checkUnique一样, 检查之前会获得新的值的锁.
+*/
+func (t *table) checkUpdateUnique(xid tm.XID, selfs []utils.UUID, entries []entry) error {
+	updated := make(map[utils.UUID]bool, len(selfs))
+	for _, uuid := range selfs {
+		updated[uuid] = true
+	}
+	for _, f := range t.fields {
+		if f.Unique == false {
+			continue
+		}
+		values := make(map[string]bool)
+		for _, e := range entries {
+			v := e[f]
+			if v == nil {
+				continue
+			}
+			key := string(f.ValueToKey(v))
+			if values[key] {
+				return ErrDuplicatedKey
+			}
+			values[key] = true
+
+			err := f.lockValue(xid, v)
 			if err != nil {
 				return err
 			}
+			uuids, _, err := f.latest(xid, v, utils.NilUUID)
+			if err != nil {
+				return err
+			}
+			for _, uuid := range uuids {
+				if updated[uuid] == false {
+					return ErrDuplicatedKey
+				}
+			}
 		}
 	}
 	return nil
 }
 
-func (t *table) strToEntry(values []string) (entry, error) {
-	if len(values) != len(t.fields) {
+// strToEntry 将values依次作为fds的值, 转换为entry, 没有给出值的字段取其默认值, 值为nil表示NULL.
+// kinds不为nil时, kinds[i]为values[i]作为占位符时绑定的值的类型.
+func (t *table) strToEntry(fds []*field, values []*string, kinds []string) (entry, error) {
+	if len(values) != len(fds) {
 		return nil, ErrInvalidValues
 	}
 
 	e := entry{}
-	for i, f := range t.fields {
-		v, err := f.StrToValue(values[i])
+	given := make(map[*field]bool, len(fds))
+	for i, f := range fds {
+		given[f] = true
+		if values[i] == nil {
+			if f.NotNull {
+				return nil, ErrNullValue
+			}
+			continue
+		}
+		if kinds != nil {
+			if err := checkParam(kindOf(f.FType), kinds[i]); err != nil {
+				return nil, err
+			}
+		}
+		v, err := f.StrToValue(*values[i])
 		if err != nil {
 			return nil, err
 		}
-		e[f.FName] = v
+		e[f] = v
+	}
+	for _, f := range t.fields {
+		if given[f] {
+			continue
+		}
+		v, err := f.DefaultValue()
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			e[f] = v
+		}
 	}
 
 	return e, nil
 }
 
 func (t *table) entryToRaw(e entry) []byte {
-	var raw []byte
-	for _, f := range t.fields {
-		raw = append(raw, f.ValueToRaw(e[f.FName])...)
+	bitmap := make([]byte, (len(t.fields)+7)/8)
+	var values []byte
+	for i, f := range t.fields {
+		if e[f] == nil {
+			bitmap[i/8] |= 1 << (i % 8)
+			continue
+		}
+		values = append(values, f.ValueToRaw(e[f])...)
 	}
-	return raw
+	raw := utils.Uint32ToRaw(t.version)
+	raw = append(raw, bitmap...)
+	return append(raw, values...)
 }
 
+// parseEntry 解析一条记录, 该记录可能是按照t的其他版本写入的, 见alter.go.
 func (t *table) parseEntry(raw []byte) entry {
-	var pos, shift int
+	version := utils.ParseUint32(raw)
+	raw = raw[4:]
+	fields := t.fields
+	if version != t.version {
+		fields = t.schema.version(version)
+	}
+
+	pos := (len(fields) + 7) / 8
+	var v interface{}
+	var shift int
 	e := entry{}
-	for _, f := range t.fields {
-		e[f.FName], shift = f.ParseValue(raw[pos:])
+	for i, f := range fields {
+		if raw[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+		v, shift = f.ParseValue(raw[pos:])
 		pos += shift
+		if version == t.version {
+			e[f] = v
+		} else if cf := t.fieldByID(f.id()); cf != nil { // 忽略已经被drop的字段
+			e[cf] = v
+		}
+	}
+	if version == t.version {
+		return e
+	}
+
+	for _, f := range t.fields { // 该记录写入之后被add的字段
+		if f.defType != _DEFAULT_VALUE || e[f] != nil {
+			continue
+		}
+		added := true
+		for _, old := range fields {
+			if old.id() == f.id() {
+				added = false
+				break
+			}
+		}
+		if added {
+			e[f] = f.defValue
+		}
 	}
 	return e
 }
 
-func (t *table) entryPrint(e entry) string {
-	str := "["
-	for i, f := range t.fields {
-		str += f.ValuePrint(e[f.FName])
-		if i == len(t.fields)-1 {
-			str += "]"
-		} else {
-			str += ", "
+// fieldByID 返回标识为id的字段, 见field.id.
+func (t *table) fieldByID(id utils.UUID) *field {
+	for _, f := range t.fields {
+		if f.id() == id {
+			return f
 		}
 	}
-	return str
+	return nil
 }
@@ -4,12 +4,39 @@
 	TBM会依赖IM进行索引, 依赖SM进行表单数据查找.
 
 	TBM本身的模型如下:
-	[TBM] -> [Booter] -> [Table1] -> [Table2] -> [Table3] ...
+	[TBM] -> [Booter] -> [Link1] -> [Link2] -> [Link3] ...
+	                       |          |          |
+	                       v          v          v
+	                    [Table1]   [Table2]   [Table3]
 	TBM将它管理的所有的表, 以链表的结构组织起来.
-	并利用Booter, 存储了第一张表的UUID.
-
-	TBM目前没有实现表的可见性管理, 也没有实现Drop语句.
-	这样的目的是为了简洁代码.
+	并利用Booter, 存储了第一个link的UUID.
+
+	Booter的二进制结构如下:
+		[Format Version]  uint32
+		[First Link UUID] UUID
+	[Format Version]为表, 字段, link和记录的二进制格式的版本. 这些格式与之前的版本不兼容,
+	而之前的版本的Booter中只有[First Link UUID], 所以Open时如果版本不符, 会报ErrIncompatibleFormat,
+	此时需要重新create数据库并导入数据.
+
+	表的可见性:
+	表的记录是以创建它的事务的身份, 通过SM插入的, 所以和普通的记录一样, 具有XMIN和XMAX.
+	TBM利用SM.Read来判断某张表对某个事务是否可见, 可见性的规则与普通记录完全相同.
+	于是, 未提交的Create只对创建它的事务可见; 未提交的Drop只对删除它的事务生效.
+
+	由于同一事务可以先Drop再Create同名的表, 所以表缓存中, 同名的表可能同时存在多个版本.
+	同一时刻, 对任一事务, 同名的表最多只有一个版本可见.
+
+	Create时, 如果存在同名的, 且不是被该事务自己删除的表(无论对该事务是否可见), 则报错.
+	这样做, 是为了避免两个并发的事务创建同名的表.
+
+	事务撤销时, TBM会将其创建的表从链表和缓存中移除. 事务提交时, 其删除的表(包括被alter, create index
+	和drop index替换掉的原来的版本)却不能被立即移除, 因为之前开始的repeatable read的事务按照快照依然能看到它.
+	这些表会被标记为removed, 并和被删除的记录一样加入清理队列, 直到所有事务都看不到它们时才被移除, 见vacuum.go.
+	如果在移除之前发生了崩溃, 那么在下一次启动时, 这些表的记录将对SUPER_XID不可见,
+	loadTables会将其从链表中移除.
+
+	被标记为removed的表不会再被读写: 如果对某个事务可见的版本已经被删除或替换, 则访问该表时会报ErrTableChanged.
+	此时该事务快照中的表结构已经过时, 只能撤销该事务, 然后重新开始. show和外键的检查依然使用该版本.
 */
 package tbm
 
@@ -27,20 +54,30 @@ import (
 var (
 	ErrDuplicatedTable = errors.New("Duplicated table.")
 	ErrNoThatTable     = errors.New("No that table.")
+
+	ErrIncompatibleFormat = errors.New("Incompatible data format, the database needs to be recreated.")
 )
 
+// _FORMAT_VERSION 为当前的数据格式的版本, 格式发生不兼容的变化时需要增加该值.
+const _FORMAT_VERSION uint32 = 1
+
 type TableManager interface {
 	Begin(begin *statement.Begin) (tm.XID, []byte)
 	Commit(xid tm.XID) ([]byte, error)
 	Abort(xid tm.XID) []byte
 
-	Show(xid tm.XID) []byte
+	Show(xid tm.XID) ([]byte, error)
 	Create(xid tm.XID, create *statement.Create) ([]byte, error)
+	Drop(xid tm.XID, drop *statement.Drop) ([]byte, error)
+	Alter(xid tm.XID, alter *statement.Alter) ([]byte, error)
+	CreateIndex(xid tm.XID, create *statement.CreateIndex) ([]byte, error)
+	DropIndex(xid tm.XID, drop *statement.DropIndex) ([]byte, error)
 
 	Insert(xid tm.XID, insert *statement.Insert) ([]byte, error)
 	Read(xid tm.XID, read *statement.Read) ([]byte, error)
 	Update(xid tm.XID, update *statement.Update) ([]byte, error)
 	Delete(xid tm.XID, delete *statement.Delete) ([]byte, error)
+	Explain(xid tm.XID, explain *statement.Explain) ([]byte, error)
 }
 
 type tableManager struct {
@@ -49,9 +86,14 @@ type tableManager struct {
 
 	booter booter.Booter
 
-	tc   map[string]*table   // 表缓存
-	xtc  map[tm.XID][]*table // xid 创建了哪些表
+	tc   map[string][]*table  // 表缓存, 同名表的多个版本中, 新版本在前
+	xtc  map[tm.XID][]*table  // xid 创建了哪些表
+	xdt  map[tm.XID][]*table  // xid 删除了哪些表
+	xdr  map[tm.XID][]deadRow // xid 删除了哪些记录, 见vacuum.go
 	lock sync.Mutex
+
+	vacuumQueue []*deadRows
+	vacuumLock  sync.Mutex
 }
 
 func newTableManager(sm sm.SerializabilityManager, dm dm.DataManager, booter booter.Booter) *tableManager {
@@ -59,8 +101,10 @@ func newTableManager(sm sm.SerializabilityManager, dm dm.DataManager, booter boo
 		DM:     dm,
 		SM:     sm,
 		booter: booter,
-		tc:     make(map[string]*table),
+		tc:     make(map[string][]*table),
 		xtc:    make(map[tm.XID][]*table),
+		xdt:    make(map[tm.XID][]*table),
+		xdr:    make(map[tm.XID][]deadRow),
 	}
 
 	tbm.loadTables()
@@ -69,44 +113,153 @@ func newTableManager(sm sm.SerializabilityManager, dm dm.DataManager, booter boo
 
 func Create(path string, sm sm.SerializabilityManager, dm dm.DataManager) *tableManager {
 	booter := booter.Create(path)
-	booter.Update(utils.UUIDToRaw(utils.NilUUID))
+	booter.Update(bootRaw(utils.NilUUID))
 	return newTableManager(sm, dm, booter)
 }
 
+// Open 打开path下的TBM, 如果其数据格式与当前版本不符, 则panic(ErrIncompatibleFormat).
 func Open(path string, sm sm.SerializabilityManager, dm dm.DataManager) *tableManager {
 	booter := booter.Open(path)
+	raw := booter.Load()
+	if len(raw) != 4+utils.LEN_UUID || utils.ParseUint32(raw) != _FORMAT_VERSION {
+		panic(ErrIncompatibleFormat)
+	}
 	return newTableManager(sm, dm, booter)
 }
 
-// loadTables 将所有的table读入内存.
+// bootRaw 返回第一个link为uuid时Booter的内容.
+func bootRaw(uuid utils.UUID) []byte {
+	return append(utils.Uint32ToRaw(_FORMAT_VERSION), utils.UUIDToRaw(uuid)...)
+}
+
+// loadTables 将所有的table读入内存, 并将已经被删除的表从链表中移除.
 func (tbm *tableManager) loadTables() {
-	uuid := tbm.firstTableUUID()
+	prev := utils.NilUUID
+	uuid := tbm.firstLinkUUID()
 	for uuid != utils.NilUUID {
-		tb := LoadTable(tbm, uuid)
-		uuid = tb.Next
-		tbm.tc[tb.Name] = tb
+		tableUUID, next, err := tbm.readLink(uuid)
+		if err != nil {
+			panic(err)
+		}
+
+		tb, ok := LoadTable(tbm, tableUUID)
+		if ok {
+			tb.link = uuid
+			tbm.tc[tb.Name] = append(tbm.tc[tb.Name], tb)
+			prev = uuid
+		} else {
+			err = tbm.removeLink(prev, next)
+			if err != nil {
+				panic(err)
+			}
+		}
+		uuid = next
 	}
 }
 
-func (tbm *tableManager) firstTableUUID() utils.UUID {
+func (tbm *tableManager) firstLinkUUID() utils.UUID {
 	raw := tbm.booter.Load()
-	return utils.ParseUUID(raw)
+	return utils.ParseUUID(raw[4:])
 }
 
-func (tbm *tableManager) updateFirstTableUUID(uuid utils.UUID) {
-	raw := utils.UUIDToRaw(uuid)
-	tbm.booter.Update(raw)
+func (tbm *tableManager) updateFirstLinkUUID(uuid utils.UUID) {
+	tbm.booter.Update(bootRaw(uuid))
 }
 
-func (tbm *tableManager) Read(xid tm.XID, read *statement.Read) ([]byte, error) {
+// table 返回对xid可见的, 名为name的表. 如果该版本已经被删除或替换, 则返回ErrTableChanged.
+// 调用者需要持有tbm.lock.
+func (tbm *tableManager) table(xid tm.XID, name string) (*table, error) {
+	tb, err := tbm.visibleTable(xid, name)
+	if err != nil {
+		return nil, err
+	}
+	if tb.removed {
+		return nil, ErrTableChanged
+	}
+	return tb, nil
+}
+
+// visibleTable 返回对xid可见的, 名为name的表, 包括已经被标记为removed的版本.
+// 调用者需要持有tbm.lock.
+func (tbm *tableManager) visibleTable(xid tm.XID, name string) (*table, error) {
+	for _, tb := range tbm.tc[name] {
+		ok, err := tbm.isVisible(xid, tb)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return tb, nil
+		}
+	}
+	return nil, ErrNoThatTable
+}
+
+// isVisible 判断tb是否对xid可见.
+func (tbm *tableManager) isVisible(xid tm.XID, tb *table) (bool, error) {
+	_, ok, err := tbm.SM.Read(xid, tb.SelfUUID)
+	return ok, err
+}
+
+func (tbm *tableManager) addTable(tb *table) {
+	tbm.tc[tb.Name] = append([]*table{tb}, tbm.tc[tb.Name]...)
+}
+
+// removeTable 将tb从表缓存和链表中移除.
+func (tbm *tableManager) removeTable(tb *table) {
+	var tbs []*table
+	for _, t := range tbm.tc[tb.Name] {
+		if t != tb {
+			tbs = append(tbs, t)
+		}
+	}
+	if len(tbs) == 0 {
+		delete(tbm.tc, tb.Name)
+	} else {
+		tbm.tc[tb.Name] = tbs
+	}
+
+	// 即使移除失败也没关系, 该表的记录已经不可见, 下一次启动时会被移除.
+	err := tbm.unlink(tb.link)
+	if err != nil {
+		utils.Warn("Unlink table ", tb.Name, ": ", err)
+	}
+}
+
+// isDropped 判断tb是否已经被xid删除.
+func (tbm *tableManager) isDropped(xid tm.XID, tb *table) bool {
+	for _, t := range tbm.xdt[xid] {
+		if t == tb {
+			return true
+		}
+	}
+	return false
+}
+
+// readTables 返回read中读取的表, 以及与之join的各张表.
+func (tbm *tableManager) readTables(xid tm.XID, read *statement.Read) (*table, []*table, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tc[read.TableName]
-	tbm.lock.Unlock()
-	if ok == false {
-		return nil, ErrNoThatTable
+	defer tbm.lock.Unlock()
+	tb, err := tbm.table(xid, read.TableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	joins := make([]*table, len(read.Joins))
+	for i, join := range read.Joins {
+		joins[i], err = tbm.table(xid, join.TableName)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
+	return tb, joins, nil
+}
 
-	result, err := tb.Read(xid, read)
+func (tbm *tableManager) Read(xid tm.XID, read *statement.Read) ([]byte, error) {
+	tb, joins, err := tbm.readTables(xid, read)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tb.Read(xid, read, joins, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -115,10 +268,10 @@ func (tbm *tableManager) Read(xid tm.XID, read *statement.Read) ([]byte, error)
 
 func (tbm *tableManager) Update(xid tm.XID, update *statement.Update) ([]byte, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tc[update.TableName]
+	tb, err := tbm.table(xid, update.TableName)
 	tbm.lock.Unlock()
-	if ok == false {
-		return nil, ErrNoThatTable
+	if err != nil {
+		return nil, err
 	}
 
 	count, err := tb.Update(xid, update)
@@ -130,10 +283,10 @@ func (tbm *tableManager) Update(xid tm.XID, update *statement.Update) ([]byte, e
 
 func (tbm *tableManager) Delete(xid tm.XID, delete *statement.Delete) ([]byte, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tc[delete.TableName]
+	tb, err := tbm.table(xid, delete.TableName)
 	tbm.lock.Unlock()
-	if ok == false {
-		return nil, ErrNoThatTable
+	if err != nil {
+		return nil, err
 	}
 
 	count, err := tb.Delete(xid, delete)
@@ -145,60 +298,104 @@ func (tbm *tableManager) Delete(xid tm.XID, delete *statement.Delete) ([]byte, e
 
 func (tbm *tableManager) Insert(xid tm.XID, insert *statement.Insert) ([]byte, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tc[insert.TableName]
+	tb, err := tbm.table(xid, insert.TableName)
 	tbm.lock.Unlock()
-	if ok == false {
-		return nil, ErrNoThatTable
+	if err != nil {
+		return nil, err
 	}
 
-	err := tb.Insert(xid, insert)
+	count, err := tb.Insert(xid, insert)
 	if err != nil {
 		return nil, err
 	}
-	return []byte("Insert"), nil
+	return []byte("Insert " + utils.Uint32ToStr(uint32(count))), nil
 }
 
 func (tbm *tableManager) Create(xid tm.XID, create *statement.Create) ([]byte, error) {
 	tbm.lock.Lock()
 	defer tbm.lock.Unlock()
 
-	_, ok := tbm.tc[create.TableName]
-	if ok == true { // 已经存在
-		return nil, ErrDuplicatedTable
+	for _, tb := range tbm.tc[create.TableName] {
+		if tb.removed == false && tbm.isDropped(xid, tb) == false { // 已经存在
+			return nil, ErrDuplicatedTable
+		}
 	}
 
 	// 直接创建新表
-	tb, err := CreateTable(tbm, tbm.firstTableUUID(), xid, create)
+	tb, err := CreateTable(tbm, xid, create)
 	if err != nil {
 		return nil, err
-	} else { // 创建成功
-		tbm.updateFirstTableUUID(tb.SelfUUID)
-		tbm.tc[create.TableName] = tb
-		tbm.xtc[xid] = append(tbm.xtc[xid], tb)
-		return []byte("create " + create.TableName), nil
 	}
+	link, err := tbm.createLink(tb.SelfUUID, tbm.firstLinkUUID())
+	if err != nil {
+		return nil, err
+	}
+
+	// 创建成功
+	tb.link = link
+	tbm.updateFirstLinkUUID(link)
+	tbm.addTable(tb)
+	tbm.xtc[xid] = append(tbm.xtc[xid], tb)
+	return []byte("create " + create.TableName), nil
 }
 
 /*
-	Show 返回所有的表名.
+	Drop 删除一张表.
+	该表会立即对xid不可见, 但直到xid提交, 才会对其他事务不可见, 并被真正的从TBM中移除.
+	被其他表的外键引用的表不能被删除, 需要先删除引用它的表.
 */
-func (tbm *tableManager) Show(xid tm.XID) []byte {
+func (tbm *tableManager) Drop(xid tm.XID, drop *statement.Drop) ([]byte, error) {
 	tbm.lock.Lock()
-	defer tbm.lock.Unlock()
-	var results []byte
-	for _, t := range tbm.tc { // 打印已经提交的表
-		tPrint := t.Print()
-		results = append(results, tPrint...)
-		results = append(results, '\n')
+	tb, err := tbm.table(xid, drop.TableName)
+	tbm.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	children, err := tbm.children(xid, tb)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range children {
+		if f.tb != tb { // 被其他表引用的表不能被删除
+			return nil, ErrTableReferenced
+		}
 	}
 
-	for _, t := range tbm.xtc[xid] { // 打印它自己创建的表
+	// SM.Delete可能会因为等待锁而阻塞, 所以不能持有tbm.lock.
+	ok, err := tbm.SM.Delete(xid, tb.SelfUUID)
+	if err != nil {
+		return nil, err
+	}
+	if ok == false {
+		return nil, ErrNoThatTable
+	}
+
+	tbm.lock.Lock()
+	tbm.xdt[xid] = append(tbm.xdt[xid], tb)
+	tbm.lock.Unlock()
+	return []byte("drop " + drop.TableName), nil
+}
+
+/*
+	Show 返回所有对xid可见的表.
+*/
+func (tbm *tableManager) Show(xid tm.XID) ([]byte, error) {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+	var results []byte
+	for name := range tbm.tc {
+		t, err := tbm.visibleTable(xid, name)
+		if err == ErrNoThatTable {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
 		tPrint := t.Print()
 		results = append(results, tPrint...)
 		results = append(results, '\n')
 	}
-
-	return results
+	return results, nil
 }
 
 func (tbm *tableManager) Begin(begin *statement.Begin) (tm.XID, []byte) {
@@ -215,10 +412,35 @@ func (tbm *tableManager) Commit(xid tm.XID) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	tbm.lock.Lock()
+	for _, tb := range tbm.xdt[xid] { // xid删除的表会和记录一起被清理
+		tb.removed = true
+	}
+	tbm.queueDead(xid)
+	delete(tbm.xtc, xid)
+	delete(tbm.xdt, xid)
+	tbm.lock.Unlock()
+
+	tbm.vacuum()
 	return []byte("commit"), nil
 }
 
 func (tbm *tableManager) Abort(xid tm.XID) []byte {
 	tbm.SM.Abort(xid)
+
+	tbm.lock.Lock()
+	for _, tb := range tbm.xtc[xid] { // 将xid创建的表移除
+		tbm.removeTable(tb)
+	}
+	for _, tb := range tbm.xdt[xid] { // 移除xid在其上建立的索引
+		tb.removeBuilds(xid)
+	}
+	delete(tbm.xtc, xid)
+	delete(tbm.xdt, xid)
+	delete(tbm.xdr, xid)
+	tbm.lock.Unlock()
+
+	tbm.vacuum() // xid的快照可能阻止了之前的清理
 	return []byte("abort")
 }
@@ -0,0 +1,120 @@
+/*
+	vacuum.go 清理行目录和索引中已经失效的条目.
+
+	记录被删除(包括update时被删除的原来的版本)之后, 它在行目录和索引中的条目依然存在,
+	每次查询都需要通过SM.Read确认其不可见. 当删除它的事务已经提交, 且所有活跃的事务都看不到它时
+	(见SM.IsObsolete), 这些条目不会再被任何事务用到, 于是可以从B+树中删除.
+
+	事务删除的记录先被记在该事务名下. 事务提交之后, 这些记录被加入清理队列; 事务回滚时则被丢弃.
+	每当有事务结束, 队列中已经对所有事务都不可见的记录就会被清理.
+
+	清理时, 会从共用同一个schema的所有表版本(见alter.go)的索引中删除该记录, 其在各个索引中的key
+	由该版本重新解析该记录得到. 被清理的只是B+树中的条目, 记录本身依然留在DM中.
+
+	被已提交的事务删除的表(见table_manager.go)也随着该事务删除的记录一起被加入清理队列,
+	当所有事务都看不到它时, 才从链表和缓存中移除.
+
+	记录的清理只是一种优化: 遗漏的条目, 如重启时还在队列中的记录, 或者正在建立的索引中的条目(见index.go),
+	只会让查询多读到一些不可见的记录, 不影响正确性.
+*/
+package tbm
+
+import (
+	"nyadb2/backend/tm"
+	"nyadb2/backend/utils"
+)
+
+// deadRow 为被删除的记录, e为其被删除时的值.
+type deadRow struct {
+	tb   *table
+	uuid utils.UUID
+	e    entry
+}
+
+// deadRows 为被已提交的事务xid删除的记录和表.
+type deadRows struct {
+	xid    tm.XID
+	rows   []deadRow
+	tables []*table
+}
+
+// addDead 记录xid删除了tb中的记录uuid.
+func (tbm *tableManager) addDead(xid tm.XID, tb *table, uuid utils.UUID, e entry) {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+	tbm.xdr[xid] = append(tbm.xdr[xid], deadRow{tb: tb, uuid: uuid, e: e})
+}
+
+// queueDead 在xid提交之后, 将其删除的记录和表加入清理队列. 调用者需要持有tbm.lock.
+func (tbm *tableManager) queueDead(xid tm.XID) {
+	rows, tables := tbm.xdr[xid], tbm.xdt[xid]
+	delete(tbm.xdr, xid)
+	if len(rows) == 0 && len(tables) == 0 {
+		return
+	}
+	tbm.vacuumLock.Lock()
+	defer tbm.vacuumLock.Unlock()
+	tbm.vacuumQueue = append(tbm.vacuumQueue, &deadRows{xid: xid, rows: rows, tables: tables})
+}
+
+// vacuum 清理队列中已经对所有事务都不可见的记录和表.
+func (tbm *tableManager) vacuum() {
+	tbm.vacuumLock.Lock()
+	var ready, rest []*deadRows
+	for _, d := range tbm.vacuumQueue {
+		if tbm.SM.IsObsolete(d.xid) {
+			ready = append(ready, d)
+		} else {
+			rest = append(rest, d)
+		}
+	}
+	tbm.vacuumQueue = rest
+	tbm.vacuumLock.Unlock()
+
+	for _, d := range ready {
+		for _, row := range d.rows {
+			err := tbm.removeRow(row)
+			if err != nil {
+				utils.Warn("Vacuum ", row.tb.Name, ": ", err)
+			}
+		}
+		tbm.lock.Lock()
+		for _, tb := range d.tables {
+			tbm.removeTable(tb)
+		}
+		tbm.lock.Unlock()
+	}
+}
+
+// removeRow 从行目录和各个表版本的索引中删除row的条目.
+func (tbm *tableManager) removeRow(row deadRow) error {
+	versions := []*table{row.tb}
+	tbm.lock.Lock()
+	for _, t := range tbm.tc[row.tb.Name] {
+		if t != row.tb && t.schema == row.tb.schema {
+			versions = append(versions, t)
+		}
+	}
+	tbm.lock.Unlock()
+
+	_, err := row.tb.rowsBt.Delete(utils.UUIDToKey(row.uuid), row.uuid)
+	if err != nil {
+		return err
+	}
+	raw := row.tb.entryToRaw(row.e)
+	removed := make(map[utils.UUID]bool) // 各个版本共用的索引只需删除一次
+	for _, t := range versions {
+		e := t.parseEntry(raw)
+		for _, f := range t.fields {
+			if f.IsIndexed() == false || removed[f.index] {
+				continue
+			}
+			removed[f.index] = true
+			err = f.Delete(e[f], row.uuid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+/*
+	value_exp.go 实现了对值表达式的计算, 如update中set的右值.
+
+	值表达式在编译时, 会根据其期望的类型进行类型检查, 字面值也会在此时被转换为对应的类型.
+	计算时, 无符号整数以uint64表示, 有符号整数以int64表示, 浮点数以float64表示, 布尔值以bool表示,
+	字符串以string表示, 最后再由toFieldValue转换为字段的类型.
+	NULL以nil表示, 任何运算只要有一边为NULL, 结果就为NULL.
+
+	支持的运算如下:
+	数值: + - * /, 溢出或除零会报错. 数值前可以带有负号, 如-x等价于0 - x.
+	字符串: + 表示拼接, upper(s)和lower(s)进行大小写转换.
+	布尔值: 不支持任何运算.
+*/
+package tbm
+
+import (
+	"errors"
+	"math"
+	"nyadb2/backend/parser/statement"
+	"nyadb2/backend/utils"
+	"strings"
+)
+
+var (
+	ErrInvalidExp   = errors.New("Invalid expression.")
+	ErrNoThatFunc   = errors.New("No that function.")
+	ErrOverflow     = errors.New("Value overflow.")
+	ErrDivideByZero = errors.New("Divide by zero.")
+	ErrParamType    = errors.New("Parameter type mismatch.")
+)
+
+// 计算类型, 同时也是预编译语句中占位符绑定的值的类型.
+const (
+	_KIND_UINT   = statement.KindUint
+	_KIND_INT    = statement.KindInt
+	_KIND_FLOAT  = statement.KindFloat
+	_KIND_BOOL   = statement.KindBool
+	_KIND_STRING = statement.KindString
+)
+
+type valueExp interface {
+	// eval 对记录e计算该表达式.
+	eval(e entry) (interface{}, error)
+}
+
+type literalExp struct {
+	value interface{}
+}
+
+type fieldExp struct {
+	fd *field
+}
+
+type arithExp struct {
+	op   string
+	exp1 valueExp
+	exp2 valueExp
+}
+
+type funcExp struct {
+	fn  string
+	arg valueExp
+}
+
+// kindOf 返回字段类型对应的计算类型.
+func kindOf(ftype string) string {
+	switch ftype {
+	case "uint32", "uint64":
+		return _KIND_UINT
+	case "int32", "int64":
+		return _KIND_INT
+	case "float64":
+		return _KIND_FLOAT
+	case "bool":
+		return _KIND_BOOL
+	}
+	return _KIND_STRING
+}
+
+func isNumeric(kind string) bool {
+	return kind == _KIND_UINT || kind == _KIND_INT || kind == _KIND_FLOAT
+}
+
+/*
+	checkParam 检查占位符绑定的值的类型pkind能否作为kind类型的值, pkind为空表示没有指定类型.
+	整数之间可以互相转换(超出范围时仍会报错), 整数也可以作为浮点数, 其他的类型必须相同,
+	如字符串"12"不能作为整数, 整数1也不能作为字符串或浮点数之外的类型.
+*/
+func checkParam(kind, pkind string) error {
+	if pkind == "" || pkind == kind {
+		return nil
+	}
+	if (kind == _KIND_UINT || kind == _KIND_INT || kind == _KIND_FLOAT) &&
+		(pkind == _KIND_UINT || pkind == _KIND_INT) {
+		return nil
+	}
+	return ErrParamType
+}
+
+// kindValue 将字面值str转换为kind类型的值, 失败时的错误同StrToValue.
+func kindValue(kind, str string) (interface{}, error) {
+	var v interface{}
+	var err error
+	switch kind {
+	case _KIND_UINT:
+		v, err = utils.StrToUint64(str)
+	case _KIND_INT:
+		v, err = utils.StrToInt64(str)
+	case _KIND_FLOAT:
+		v, err = utils.StrToFloat64(str)
+	case _KIND_BOOL:
+		v, err = utils.StrToBool(str)
+	default:
+		v = str
+	}
+	if err != nil {
+		return nil, literalError(err)
+	}
+	return v, nil
+}
+
+// compileValueExp 将语句中的值表达式编译成valueExp, 其计算结果的类型为kind.
+func (s scope) compileValueExp(exp statement.ValueExp, kind string) (valueExp, error) {
+	switch e := exp.(type) {
+	case *statement.LiteralExp:
+		v, err := kindValue(kind, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExp{value: v}, nil
+	case *statement.ParamExp:
+		if e.Value == nil {
+			return &literalExp{value: nil}, nil
+		}
+		if err := checkParam(kind, e.Kind); err != nil {
+			return nil, err
+		}
+		v, err := kindValue(kind, *e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExp{value: v}, nil
+	case *statement.NullExp:
+		return &literalExp{value: nil}, nil
+	case *statement.FieldExp:
+		fd, err := s.field(e.Field)
+		if err != nil {
+			return nil, err
+		}
+		if kindOf(fd.FType) != kind {
+			return nil, ErrInvalidExp
+		}
+		return &fieldExp{fd: fd}, nil
+	case *statement.ArithExp:
+		if kind == _KIND_BOOL || (kind == _KIND_STRING && e.ArithOp != "+") {
+			return nil, ErrInvalidExp
+		}
+		exp1, err := s.compileValueExp(e.Exp1, kind)
+		if err != nil {
+			return nil, err
+		}
+		exp2, err := s.compileValueExp(e.Exp2, kind)
+		if err != nil {
+			return nil, err
+		}
+		return &arithExp{op: e.ArithOp, exp1: exp1, exp2: exp2}, nil
+	case *statement.FuncExp:
+		if e.FuncName != "upper" && e.FuncName != "lower" {
+			return nil, ErrNoThatFunc
+		}
+		if kind != _KIND_STRING || len(e.Args) != 1 {
+			return nil, ErrInvalidExp
+		}
+		arg, err := s.compileValueExp(e.Args[0], _KIND_STRING)
+		if err != nil {
+			return nil, err
+		}
+		return &funcExp{fn: e.FuncName, arg: arg}, nil
+	}
+	return nil, ErrInvalidExp
+}
+
+func (l *literalExp) eval(e entry) (interface{}, error) {
+	return l.value, nil
+}
+
+func (f *fieldExp) eval(e entry) (interface{}, error) {
+	return normalize(e[f.fd]), nil
+}
+
+func (a *arithExp) eval(e entry) (interface{}, error) {
+	v1, err := a.exp1.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	v2, err := a.exp2.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if v1 == nil || v2 == nil {
+		return nil, nil
+	}
+	return arith(a.op, v1, v2)
+}
+
+// arith 计算v1 op v2, v1和v2的类型相同, 为uint64, int64, float64或string.
+func arith(op string, v1, v2 interface{}) (interface{}, error) {
+	switch n1 := v1.(type) {
+	case string:
+		return n1 + v2.(string), nil
+	case uint64:
+		return arithUint(op, n1, v2.(uint64))
+	case int64:
+		return arithInt(op, n1, v2.(int64))
+	case float64:
+		return arithFloat(op, n1, v2.(float64))
+	}
+	return nil, ErrInvalidExp
+}
+
+func arithUint(op string, n1, n2 uint64) (interface{}, error) {
+	switch op {
+	case "+":
+		if n1 > math.MaxUint64-n2 {
+			return nil, ErrOverflow
+		}
+		return n1 + n2, nil
+	case "-":
+		if n1 < n2 {
+			return nil, ErrOverflow
+		}
+		return n1 - n2, nil
+	case "*":
+		if n1 != 0 && n2 > math.MaxUint64/n1 {
+			return nil, ErrOverflow
+		}
+		return n1 * n2, nil
+	case "/":
+		if n2 == 0 {
+			return nil, ErrDivideByZero
+		}
+		return n1 / n2, nil
+	}
+	return nil, ErrInvalidExp
+}
+
+func arithInt(op string, n1, n2 int64) (interface{}, error) {
+	switch op {
+	case "+":
+		if (n2 > 0 && n1 > math.MaxInt64-n2) || (n2 < 0 && n1 < math.MinInt64-n2) {
+			return nil, ErrOverflow
+		}
+		return n1 + n2, nil
+	case "-":
+		if (n2 < 0 && n1 > math.MaxInt64+n2) || (n2 > 0 && n1 < math.MinInt64+n2) {
+			return nil, ErrOverflow
+		}
+		return n1 - n2, nil
+	case "*":
+		if n1 == 0 || n2 == 0 {
+			return int64(0), nil
+		}
+		r := n1 * n2
+		if r/n2 != n1 || (n1 == -1 && n2 == math.MinInt64) || (n2 == -1 && n1 == math.MinInt64) {
+			return nil, ErrOverflow
+		}
+		return r, nil
+	case "/":
+		if n2 == 0 {
+			return nil, ErrDivideByZero
+		}
+		if n1 == math.MinInt64 && n2 == -1 {
+			return nil, ErrOverflow
+		}
+		return n1 / n2, nil
+	}
+	return nil, ErrInvalidExp
+}
+
+func arithFloat(op string, n1, n2 float64) (interface{}, error) {
+	var r float64
+	switch op {
+	case "+":
+		r = n1 + n2
+	case "-":
+		r = n1 - n2
+	case "*":
+		r = n1 * n2
+	case "/":
+		if n2 == 0 {
+			return nil, ErrDivideByZero
+		}
+		r = n1 / n2
+	default:
+		return nil, ErrInvalidExp
+	}
+	if math.IsInf(r, 0) {
+		return nil, ErrOverflow
+	}
+	if r == 0 { // -0
+		r = 0
+	}
+	return r, nil
+}
+
+func (f *funcExp) eval(e entry) (interface{}, error) {
+	v, err := f.arg.eval(e)
+	if err != nil || v == nil {
+		return v, err
+	}
+	if f.fn == "upper" {
+		return strings.ToUpper(v.(string)), nil
+	}
+	return strings.ToLower(v.(string)), nil
+}
+
+// toFieldValue 将值表达式的计算结果v转换为该字段类型的值.
+func (f *field) toFieldValue(v interface{}) (interface{}, error) {
+	switch n := v.(type) {
+	case uint64:
+		if f.FType == "uint32" {
+			if n > math.MaxUint32 {
+				return nil, ErrOverflow
+			}
+			return uint32(n), nil
+		}
+	case int64:
+		if f.FType == "int32" {
+			if n > math.MaxInt32 || n < math.MinInt32 {
+				return nil, ErrOverflow
+			}
+			return int32(n), nil
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,381 @@
+/*
+	where.go 实现了对where表达式的计算.
+
+	where表达式会先被编译成内部的表达式树, 编译时会检查字段是否存在, 并将值转换为对应字段的类型.
+	比较的右边也可以是值表达式, 如where a > b + 1, 这时右边会对每条记录分别计算,
+	其类型规则与having相同, 只有数值之间可以互相比较. 这样的比较不能转化为区间.
+
+	查找记录时, 对每个有索引的字段, 都计算出where表达式在该字段上对应的key的区间,
+	并从中选出一个代价最小的字段, 在它的B+树上查找这些区间内的uuid.
+	如果没有任何索引能够缩小查找的范围, 则扫描表的行目录.
+
+	如果where表达式中只有对该字段的比较, 且比较能够精确的转化为区间, 那么这些区间是精确的;
+	否则, 这些区间只是where结果的超集. 区间是否精确只用于计算not和估计代价.
+	无论区间是否精确, 读出的记录都会再计算一次完整的where表达式.
+
+	where表达式使用三值逻辑: 与NULL的比较结果为unknown, not unknown仍为unknown,
+	只有结果为true的记录才满足where.
+*/
+package tbm
+
+import (
+	"bytes"
+	"nyadb2/backend/parser/statement"
+	"nyadb2/backend/utils"
+)
+
+// interval 表示key的左闭右开区间[left, right), right为nil表示正无穷.
+type interval struct {
+	left, right []byte
+}
+
+// truth 是三值逻辑中的真值, 按false < unknown < true排列.
+type truth int8
+
+const (
+	_FALSE truth = iota
+	_UNKNOWN
+	_TRUE
+)
+
+func toTruth(b bool) truth {
+	if b {
+		return _TRUE
+	}
+	return _FALSE
+}
+
+func (a truth) and(b truth) truth {
+	if b < a {
+		return b
+	}
+	return a
+}
+
+func (a truth) or(b truth) truth {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func (a truth) not() truth {
+	return _TRUE - a
+}
+
+type whereExp interface {
+	// eval 对记录e计算该表达式, 只有计算值表达式时(如溢出或除零)才会出错.
+	eval(e entry) (truth, error)
+	// ranges 计算该表达式在fd上对应的key区间, 区间有序且互不相交.
+	ranges(fd *field) (ivs []interval, exact bool)
+}
+
+type logicExp struct {
+	op   string
+	exp1 whereExp
+	exp2 whereExp
+}
+
+type notExp struct {
+	exp whereExp
+}
+
+// cmpExp 表示fd op value, 右边为值表达式时, exp不为nil, 且value不被使用.
+type cmpExp struct {
+	fd    *field
+	op    string
+	value interface{}
+	exp   valueExp
+}
+
+// isNullExp 表示fd is null, not为true时表示fd is not null.
+type isNullExp struct {
+	fd  *field
+	not bool
+}
+
+// compileExp 将语句中的表达式编译成whereExp.
+func (s scope) compileExp(exp statement.Exp) (whereExp, error) {
+	switch e := exp.(type) {
+	case *statement.LogicExp:
+		if e.LogicOp != "and" && e.LogicOp != "or" {
+			return nil, ErrInvalidLogOP
+		}
+		exp1, err := s.compileExp(e.Exp1)
+		if err != nil {
+			return nil, err
+		}
+		exp2, err := s.compileExp(e.Exp2)
+		if err != nil {
+			return nil, err
+		}
+		return &logicExp{op: e.LogicOp, exp1: exp1, exp2: exp2}, nil
+	case *statement.NotExp:
+		sub, err := s.compileExp(e.Exp)
+		if err != nil {
+			return nil, err
+		}
+		return &notExp{exp: sub}, nil
+	case *statement.SingleExp:
+		fd, err := s.field(e.Field)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkParam(kindOf(fd.FType), e.Kind); err != nil {
+			return nil, err
+		}
+		v, err := fd.StrToValue(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &cmpExp{fd: fd, op: e.CmpOp, value: v}, nil
+	case *statement.CmpExp:
+		fexp, ok := e.Exp1.(*statement.FieldExp)
+		if ok == false {
+			return nil, ErrInvalidExp
+		}
+		fd, err := s.field(fexp.Field)
+		if err != nil {
+			return nil, err
+		}
+		kind1, kind2 := kindOf(fd.FType), s.inferKind(e.Exp2)
+		if kind2 == "" {
+			kind2 = kind1
+		}
+		if kind1 != kind2 && (isNumeric(kind1) == false || isNumeric(kind2) == false) {
+			return nil, ErrInvalidExp
+		}
+		exp, err := s.compileValueExp(e.Exp2, kind2)
+		if err != nil {
+			return nil, err
+		}
+		return &cmpExp{fd: fd, op: e.CmpOp, exp: exp}, nil
+	case *statement.IsNullExp:
+		fd, err := s.field(e.Field)
+		if err != nil {
+			return nil, err
+		}
+		return &isNullExp{fd: fd, not: e.Not}, nil
+	}
+	return nil, ErrInvalidLogOP
+}
+
+func (l *logicExp) eval(e entry) (truth, error) {
+	res1, err := l.exp1.eval(e)
+	if err != nil {
+		return _FALSE, err
+	}
+	if l.op == "and" && res1 == _FALSE {
+		return _FALSE, nil
+	}
+	if l.op == "or" && res1 == _TRUE {
+		return _TRUE, nil
+	}
+	res2, err := l.exp2.eval(e)
+	if err != nil {
+		return _FALSE, err
+	}
+	if l.op == "and" {
+		return res1.and(res2), nil
+	}
+	return res1.or(res2), nil
+}
+
+func (l *logicExp) ranges(fd *field) ([]interval, bool) {
+	ivs1, exact1 := l.exp1.ranges(fd)
+	ivs2, exact2 := l.exp2.ranges(fd)
+	if l.op == "and" {
+		return intersectRanges(ivs1, ivs2), exact1 && exact2
+	}
+	return unionRanges(ivs1, ivs2), exact1 && exact2
+}
+
+func (n *notExp) eval(e entry) (truth, error) {
+	res, err := n.exp.eval(e)
+	return res.not(), err
+}
+
+func (n *notExp) ranges(fd *field) ([]interval, bool) {
+	ivs, exact := n.exp.ranges(fd)
+	if exact == false { // 超集的补集不再是超集
+		return fullRange(), false
+	}
+	ivs = complementRanges(ivs)
+	// 区间精确时表达式只涉及fd, 所以在空的entry上计算即为fd为NULL时的结果.
+	// 如果该结果为unknown, 那么not之后仍为unknown, 补集中不能包含NULL.
+	if res, _ := n.exp.eval(entry{}); res == _UNKNOWN {
+		ivs = intersectRanges(ivs, nullRanges(true))
+	}
+	return ivs, true
+}
+
+// eval 如果任一边为NULL, 则比较的结果为unknown.
+func (c *cmpExp) eval(e entry) (truth, error) {
+	v := e[c.fd]
+	if c.exp == nil {
+		if v == nil {
+			return _UNKNOWN, nil
+		}
+		return toTruth(matchCmp(c.op, c.fd.Compare(v, c.value))), nil
+	}
+	v2, err := c.exp.eval(e)
+	if err != nil {
+		return _FALSE, err
+	}
+	if v == nil || v2 == nil {
+		return _UNKNOWN, nil
+	}
+	return toTruth(matchCmp(c.op, compareValues(normalize(v), v2))), nil
+}
+
+// match 判断记录e是否满足where表达式exp, exp为nil时总是满足.
+func match(exp whereExp, e entry) (bool, error) {
+	if exp == nil {
+		return true, nil
+	}
+	res, err := exp.eval(e)
+	return res == _TRUE, err
+}
+
+// matchCmp 根据比较的结果cmp, 判断比较运算op是否成立.
+func matchCmp(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func (c *cmpExp) ranges(fd *field) ([]interval, bool) {
+	if c.fd != fd || c.exp != nil { // 右边的值随记录而变, 不能转化为区间
+		return fullRange(), false
+	}
+	return fd.CalExp(c.op, c.value)
+}
+
+func (n *isNullExp) eval(e entry) (truth, error) {
+	return toTruth((e[n.fd] == nil) != n.not), nil
+}
+
+func (n *isNullExp) ranges(fd *field) ([]interval, bool) {
+	if n.fd != fd {
+		return fullRange(), false
+	}
+	return nullRanges(n.not), true
+}
+
+func fullRange() []interval {
+	return []interval{{[]byte{}, nil}}
+}
+
+func isFullRange(ivs []interval) bool {
+	return len(ivs) == 1 && len(ivs[0].left) == 0 && ivs[0].right == nil
+}
+
+// compareRight 比较两个区间的右端点, nil表示正无穷.
+func compareRight(a, b []byte) int {
+	if a == nil && b == nil {
+		return 0
+	} else if a == nil {
+		return 1
+	} else if b == nil {
+		return -1
+	}
+	return bytes.Compare(a, b)
+}
+
+// rangesCost 粗略的估计按照ivs在索引上查找的代价, 越小越好.
+func rangesCost(ivs []interval, exact bool) int {
+	cost := 2
+	if isFullRange(ivs) {
+		cost = 4
+	} else {
+		allPoints := true
+		for _, iv := range ivs {
+			if bytes.Equal(iv.right, utils.SuccKey(iv.left)) == false {
+				allPoints = false
+				break
+			}
+		}
+		if allPoints {
+			cost = 0
+		}
+	}
+	if exact == false {
+		cost++
+	}
+	return cost
+}
+
+func intersectRanges(a, b []interval) []interval {
+	var ivs []interval
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		left, right := a[i].left, a[i].right
+		if bytes.Compare(b[j].left, left) > 0 {
+			left = b[j].left
+		}
+		if compareRight(b[j].right, right) < 0 {
+			right = b[j].right
+		}
+		if compareRight(left, right) < 0 {
+			ivs = append(ivs, interval{left, right})
+		}
+		if compareRight(a[i].right, b[j].right) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+	return ivs
+}
+
+func unionRanges(a, b []interval) []interval {
+	var ivs []interval
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		var iv interval
+		if j == len(b) || (i < len(a) && bytes.Compare(a[i].left, b[j].left) < 0) {
+			iv = a[i]
+			i++
+		} else {
+			iv = b[j]
+			j++
+		}
+
+		n := len(ivs)
+		if n > 0 && compareRight(ivs[n-1].right, iv.left) >= 0 { // 与上一个区间相交或相邻
+			if compareRight(iv.right, ivs[n-1].right) > 0 {
+				ivs[n-1].right = iv.right
+			}
+		} else {
+			ivs = append(ivs, iv)
+		}
+	}
+	return ivs
+}
+
+func complementRanges(a []interval) []interval {
+	var ivs []interval
+	next := []byte{}
+	for _, iv := range a {
+		if bytes.Compare(iv.left, next) > 0 {
+			ivs = append(ivs, interval{next, iv.left})
+		}
+		if iv.right == nil {
+			return ivs
+		}
+		next = iv.right
+	}
+	return append(ivs, interval{next, nil})
+}
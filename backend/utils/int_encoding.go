@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
 )
 
 func PutUint16(buf []byte, num uint16) {
@@ -104,3 +105,22 @@ func Uint64ToRaw(num uint64) []byte {
 	PutUint64(buf, num)
 	return buf
 }
+
+func Float64ToRaw(num float64) []byte {
+	return Uint64ToRaw(math.Float64bits(num))
+}
+
+func ParseFloat64(raw []byte) float64 {
+	return math.Float64frombits(ParseUint64(raw))
+}
+
+func BoolToRaw(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func ParseBool(raw []byte) bool {
+	return raw[0] != 0
+}
@@ -0,0 +1,70 @@
+/*
+	key_encoding.go 实现了保序的key编码.
+	编码后的key按照字节序比较的结果, 与原值之间的大小关系一致, 可以直接作为B+树的key.
+
+	有符号整数将符号位取反后按大端序存储, 于是负数排在非负数之前.
+	float64先取得其IEEE 754的二进制表示, 对于正数, 将符号位置1; 对于负数, 将所有位取反.
+	-0被当做+0处理, NaN没有意义, 调用者需要保证不会出现NaN.
+	bool中false为0, true为1.
+*/
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+func Uint32ToKey(num uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, num)
+	return key
+}
+
+func Uint64ToKey(num uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, num)
+	return key
+}
+
+func Int32ToKey(num int32) []byte {
+	return Uint32ToKey(uint32(num) ^ (1 << 31))
+}
+
+func Int64ToKey(num int64) []byte {
+	return Uint64ToKey(uint64(num) ^ (1 << 63))
+}
+
+func Float64ToKey(num float64) []byte {
+	if num == 0 { // -0
+		num = 0
+	}
+	bits := math.Float64bits(num)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return Uint64ToKey(bits)
+}
+
+func BoolToKey(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func UUIDToKey(uuid UUID) []byte {
+	return Uint64ToKey(uint64(uuid))
+}
+
+func StrToKey(str string) []byte {
+	return []byte(str)
+}
+
+// SuccKey 返回字节序下紧跟在key之后的那个key, 即key + 0x00.
+func SuccKey(key []byte) []byte {
+	succ := make([]byte, len(key)+1)
+	copy(succ, key)
+	return succ
+}
@@ -0,0 +1,37 @@
+package utils_test
+
+import (
+	"bytes"
+	"math"
+	"nyadb2/backend/utils"
+	"testing"
+)
+
+func TestIntKeyOrder(t *testing.T) {
+	nums := []int64{math.MinInt64, math.MinInt32 - 1, math.MinInt32, -2333, -1, 0, 1, 2333, math.MaxInt32, math.MaxInt64}
+	for i := 1; i < len(nums); i++ {
+		if bytes.Compare(utils.Int64ToKey(nums[i-1]), utils.Int64ToKey(nums[i])) >= 0 {
+			t.Fatal(nums[i-1], nums[i])
+		}
+		if nums[i-1] >= math.MinInt32 && nums[i] <= math.MaxInt32 &&
+			bytes.Compare(utils.Int32ToKey(int32(nums[i-1])), utils.Int32ToKey(int32(nums[i]))) >= 0 {
+			t.Fatal(nums[i-1], nums[i])
+		}
+	}
+}
+
+func TestFloatKeyOrder(t *testing.T) {
+	nums := []float64{-math.MaxFloat64, -2333.5, -1, -math.SmallestNonzeroFloat64, 0,
+		math.SmallestNonzeroFloat64, 0.5, 1, 2333.5, math.MaxFloat64}
+	for i := 1; i < len(nums); i++ {
+		if bytes.Compare(utils.Float64ToKey(nums[i-1]), utils.Float64ToKey(nums[i])) >= 0 {
+			t.Fatal(nums[i-1], nums[i])
+		}
+	}
+	if bytes.Equal(utils.Float64ToKey(math.Copysign(0, -1)), utils.Float64ToKey(0)) == false {
+		t.Fatal("-0 != 0")
+	}
+	if bytes.Compare(utils.BoolToKey(false), utils.BoolToKey(true)) >= 0 {
+		t.Fatal("false >= true")
+	}
+}
@@ -1,19 +1,16 @@
 package utils
 
 import (
+	"errors"
+	"math"
 	"strconv"
+	"strings"
 )
 
-// StrToUUID 将str转换为UUID, 使用了一种简单的hash算法, 可能会有冲突.
-// 另外转换后的UUID将无序.
-func StrToUUID(str string) UUID {
-	var seed uint64 = 13331
-	var result uint64
-	for _, b := range str {
-		result = result*seed + uint64(b)
-	}
-	return UUID(result)
-}
+var (
+	ErrInvalidBool  = errors.New("Invalid bool.")
+	ErrInvalidFloat = errors.New("Invalid float.")
+)
 
 func VarStrToRaw(str string) []byte {
 	length := len(str)
@@ -51,3 +48,49 @@ func StrToUint32(str string) (uint32, error) {
 func Uint32ToStr(num uint32) string {
 	return strconv.FormatUint(uint64(num), 10)
 }
+
+func StrToInt32(str string) (int32, error) {
+	i64, err := strconv.ParseInt(str, 10, 32)
+	return int32(i64), err
+}
+
+func Int32ToStr(num int32) string {
+	return strconv.FormatInt(int64(num), 10)
+}
+
+// StrToFloat64 将str解析为float64, NaN和无穷大都不是合法的值, -0会被转换为0.
+func StrToFloat64(str string) (float64, error) {
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrInvalidFloat
+	}
+	if f == 0 {
+		f = 0
+	}
+	return f, nil
+}
+
+func Float64ToStr(num float64) string {
+	return strconv.FormatFloat(num, 'f', -1, 64)
+}
+
+// StrToBool 不区分大小写, 如TRUE和True都表示true.
+func StrToBool(str string) (bool, error) {
+	switch strings.ToLower(str) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, ErrInvalidBool
+}
+
+func BoolToStr(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -22,9 +22,23 @@ import "nyadb2/transporter"
 
 type Client interface {
 	Execute(stat []byte) ([]byte, error)
+	Prepare(stat []byte) (Stmt, error)
 	Close()
 }
 
+// Stmt 为预编译的语句, 每次执行时依次为其中的占位符?绑定params,
+// params的类型只能为uint32, uint64, int32, int64, float64, bool或string, nil表示NULL.
+// insert中的值和值表达式中的占位符可以绑定NULL, where中的比较的占位符不能.
+type Stmt interface {
+	Execute(params ...interface{}) ([]byte, error)
+	Close() error
+}
+
+type stmt struct {
+	c  *client
+	id uint32
+}
+
 type client struct {
 	roundTripper RoundTripper
 }
@@ -40,8 +54,24 @@ func (c *client) Close() {
 }
 
 func (c *client) Execute(stat []byte) ([]byte, error) {
-	statPkg := transporter.NewPackage(stat, nil)
-	pkg, err := c.roundTripper.RoundTrip(statPkg)
+	return c.roundTrip(transporter.NewPackage(stat, nil))
+}
+
+func (c *client) Prepare(stat []byte) (Stmt, error) {
+	data, err := c.roundTrip(transporter.NewTypedPackage(transporter.PKG_PREPARE, stat))
+	if err != nil {
+		return nil, err
+	}
+	id, err := transporter.DecodeStmtID(data)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{c: c, id: id}, nil
+}
+
+// roundTrip 发送pkg, 并返回回复中的数据或错误.
+func (c *client) roundTrip(pkg transporter.Package) ([]byte, error) {
+	pkg, err := c.roundTripper.RoundTrip(pkg)
 	if err != nil {
 		return nil, err
 	}
@@ -50,3 +80,17 @@ func (c *client) Execute(stat []byte) ([]byte, error) {
 	}
 	return pkg.Data(), nil
 }
+
+func (s *stmt) Execute(params ...interface{}) ([]byte, error) {
+	data, err := transporter.EncodeExecute(s.id, params)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.roundTrip(transporter.NewTypedPackage(transporter.PKG_EXECUTE, data))
+}
+
+func (s *stmt) Close() error {
+	data := transporter.EncodeStmtID(s.id)
+	_, err := s.c.roundTrip(transporter.NewTypedPackage(transporter.PKG_CLOSE, data))
+	return err
+}
@@ -38,23 +38,52 @@ func (p *packager) Close() error {
 	return p.transporter.Close()
 }
 
+// 包的类型, 其含义见protocoler.go
+const (
+	PKG_DATA    = byte(0)
+	PKG_ERR     = byte(1)
+	PKG_PREPARE = byte(2)
+	PKG_EXECUTE = byte(3)
+	PKG_CLOSE   = byte(4)
+)
+
 type Package interface {
+	Type() byte
 	Data() []byte
 	Err() error
 }
 
 type SimplePackage struct {
+	tp   byte
 	data []byte
 	err  error
 }
 
+// NewPackage 创建一个数据包, 如果err不为nil, 则为错误包.
 func NewPackage(data []byte, err error) *SimplePackage {
+	tp := PKG_DATA
+	if err != nil {
+		tp = PKG_ERR
+	}
 	return &SimplePackage{
+		tp:   tp,
 		data: data,
 		err:  err,
 	}
 }
 
+// NewTypedPackage 创建一个类型为tp的包, tp不能为PKG_ERR.
+func NewTypedPackage(tp byte, data []byte) *SimplePackage {
+	return &SimplePackage{
+		tp:   tp,
+		data: data,
+	}
+}
+
+func (sp *SimplePackage) Type() byte {
+	return sp.tp
+}
+
 func (sp *SimplePackage) Data() []byte {
 	return sp.data
 }
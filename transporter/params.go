@@ -0,0 +1,155 @@
+/*
+	params 负责预编译语句的id和参数与二进制数据之间的转换.
+
+	execute包的data格式如下:
+	[StmtID]   uint32
+	[NoParams] uint16
+	[Param1, Param2, ..., ParamN]
+
+	每个参数的格式为:
+	[Type]  1byte
+	[Value]
+	其中Type为0时, Value为uint32, 4bytes;
+	Type为1时, Value为uint64, 8bytes;
+	Type为2时, Value为string, 格式为[Length] uint32, [Bytes];
+	Type为3时, Value为int32, 4bytes;
+	Type为4时, Value为int64, 8bytes;
+	Type为5时, Value为float64, 以IEEE 754的二进制表示存储, 8bytes;
+	Type为6时, Value为bool, 1byte, 0为false, 1为true;
+	Type为7时, 参数为NULL, 没有Value.
+
+	所有的整数都以小端序存储.
+*/
+package transporter
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	ErrInvalidParam = errors.New("Invalid parameter type.")
+)
+
+const (
+	_PARAM_UINT32 = byte(0)
+	_PARAM_UINT64 = byte(1)
+	_PARAM_STRING = byte(2)
+	_PARAM_INT32  = byte(3)
+	_PARAM_INT64  = byte(4)
+	_PARAM_FLOAT  = byte(5)
+	_PARAM_BOOL   = byte(6)
+	_PARAM_NULL   = byte(7)
+)
+
+// EncodeStmtID 将语句的id转换为prepare的返回值, 或close包的data.
+func EncodeStmtID(id uint32) []byte {
+	raw := make([]byte, 4)
+	binary.LittleEndian.PutUint32(raw, id)
+	return raw
+}
+
+func DecodeStmtID(data []byte) (uint32, error) {
+	if len(data) != 4 {
+		return 0, ErrInvalidPkgData
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+// EncodeExecute 将语句的id和参数转换为execute包的data,
+// 参数的类型只能为uint32, uint64, int32, int64, float64, bool或string, nil表示NULL.
+func EncodeExecute(id uint32, params []interface{}) ([]byte, error) {
+	raw := EncodeStmtID(id)
+	raw = append(raw, 0, 0)
+	binary.LittleEndian.PutUint16(raw[4:], uint16(len(params)))
+
+	for _, param := range params {
+		switch v := param.(type) {
+		case nil:
+			raw = append(raw, _PARAM_NULL)
+		case uint32:
+			raw = append(raw, _PARAM_UINT32, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(raw[len(raw)-4:], v)
+		case uint64:
+			raw = append(raw, _PARAM_UINT64, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint64(raw[len(raw)-8:], v)
+		case string:
+			raw = append(raw, _PARAM_STRING, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(raw[len(raw)-4:], uint32(len(v)))
+			raw = append(raw, v...)
+		case int32:
+			raw = append(raw, _PARAM_INT32, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(raw[len(raw)-4:], uint32(v))
+		case int64:
+			raw = append(raw, _PARAM_INT64, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint64(raw[len(raw)-8:], uint64(v))
+		case float64:
+			raw = append(raw, _PARAM_FLOAT, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint64(raw[len(raw)-8:], math.Float64bits(v))
+		case bool:
+			if v {
+				raw = append(raw, _PARAM_BOOL, 1)
+			} else {
+				raw = append(raw, _PARAM_BOOL, 0)
+			}
+		default:
+			return nil, ErrInvalidParam
+		}
+	}
+	return raw, nil
+}
+
+// DecodeExecute 从execute包的data中解析出语句的id和参数.
+func DecodeExecute(data []byte) (uint32, []interface{}, error) {
+	if len(data) < 6 {
+		return 0, nil, ErrInvalidPkgData
+	}
+	id := binary.LittleEndian.Uint32(data)
+	params := make([]interface{}, binary.LittleEndian.Uint16(data[4:]))
+	data = data[6:]
+
+	for i := range params {
+		if len(data) < 1 {
+			return 0, nil, ErrInvalidPkgData
+		}
+		tp := data[0]
+		data = data[1:]
+		switch {
+		case tp == _PARAM_NULL:
+			params[i] = nil
+		case tp == _PARAM_UINT32 && len(data) >= 4:
+			params[i] = binary.LittleEndian.Uint32(data)
+			data = data[4:]
+		case tp == _PARAM_UINT64 && len(data) >= 8:
+			params[i] = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case tp == _PARAM_STRING && len(data) >= 4:
+			length := binary.LittleEndian.Uint32(data)
+			data = data[4:]
+			if uint32(len(data)) < length {
+				return 0, nil, ErrInvalidPkgData
+			}
+			params[i] = string(data[:length])
+			data = data[length:]
+		case tp == _PARAM_INT32 && len(data) >= 4:
+			params[i] = int32(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case tp == _PARAM_INT64 && len(data) >= 8:
+			params[i] = int64(binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		case tp == _PARAM_FLOAT && len(data) >= 8:
+			params[i] = math.Float64frombits(binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		case tp == _PARAM_BOOL && len(data) >= 1:
+			params[i] = data[0] != 0
+			data = data[1:]
+		default:
+			return 0, nil, ErrInvalidPkgData
+		}
+	}
+	if len(data) != 0 {
+		return 0, nil, ErrInvalidPkgData
+	}
+	return id, params, nil
+}
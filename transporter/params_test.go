@@ -0,0 +1,42 @@
+package transporter_test
+
+import (
+	"nyadb2/transporter"
+	"reflect"
+	"testing"
+)
+
+func TestExecuteRoundTrip(t *testing.T) {
+	params := []interface{}{
+		uint32(1), uint64(1 << 40), "it's", int32(-3), int64(-1 << 40),
+		float64(-2.5), true, nil, "", false, nil,
+	}
+	data, err := transporter.EncodeExecute(7, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, decoded, err := transporter.DecodeExecute(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 || reflect.DeepEqual(decoded, params) == false {
+		t.Fatal(id, decoded)
+	}
+
+	// 没有参数
+	data, err = transporter.EncodeExecute(8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, decoded, err = transporter.DecodeExecute(data); err != nil || id != 8 || len(decoded) != 0 {
+		t.Fatal(id, decoded, err)
+	}
+
+	if _, err = transporter.EncodeExecute(1, []interface{}{1}); err != transporter.ErrInvalidParam {
+		t.Fatal(err)
+	}
+	data, _ = transporter.EncodeExecute(1, []interface{}{int64(1), nil})
+	if _, _, err = transporter.DecodeExecute(data[:len(data)-2]); err != transporter.ErrInvalidPkgData {
+		t.Fatal(err)
+	}
+}
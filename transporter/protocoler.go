@@ -7,6 +7,12 @@
 
 	如果flag为0, 则表示要发送的是数据. 那么data既为这份数据本身.
 	如果flag为1, 则表示要发送的是错误. 那么data为[]byte(err.Errors()).
+
+	以下几种只由client发送, 用于预编译的语句:
+	如果flag为2(prepare), 那么data为要预编译的语句, server返回该语句的id.
+	如果flag为3(execute), 那么data为语句的id和绑定的参数, 格式见params.go, server返回执行的结果.
+	如果flag为4(close), 那么data为语句的id, server释放该语句, 并返回空的数据.
+	其中语句的id都为uint32, 只在同一个连接内有效.
 */
 package transporter
 
@@ -31,11 +37,13 @@ func (p *protocoler) Decode(data []byte) (Package, error) {
 	if len(data) < 1 {
 		return nil, ErrInvalidPkgData
 	}
-	if data[0] == byte(0) { // 接受的是数据
+	if data[0] == PKG_DATA { // 接受的是数据
 		return NewPackage(data[1:], nil), nil
-	} else if data[0] == byte(1) { // 接受的是错误
+	} else if data[0] == PKG_ERR { // 接受的是错误
 		err := errors.New(string(data[1:]))
 		return NewPackage(nil, err), nil
+	} else if data[0] <= PKG_CLOSE {
+		return NewTypedPackage(data[0], data[1:]), nil
 	} else {
 		return nil, ErrInvalidPkgData
 	}
@@ -45,12 +53,12 @@ func (p *protocoler) Encode(pkg Package) []byte {
 	if pkg.Err() != nil { // 发送的是错误
 		err := pkg.Err()
 		tmp := make([]byte, len(err.Error())+1)
-		tmp[0] = byte(1)
+		tmp[0] = PKG_ERR
 		copy(tmp[1:], []byte(err.Error()))
 		return tmp
 	} else { // 发送的是数据
 		tmp := make([]byte, len(pkg.Data())+1)
-		tmp[0] = byte(0)
+		tmp[0] = pkg.Type()
 		copy(tmp[1:], pkg.Data())
 		return tmp
 	}